@@ -1,10 +1,29 @@
 module github.com/cstdev/lambdahelpers
 
+go 1.25.0
+
 require (
 	github.com/DusanKasan/parsemail v0.0.0-20190115161936-abc648830b9a
 	github.com/aws/aws-sdk-go v1.16.26
 	github.com/karrick/godirwalk v1.7.8
+	github.com/pkg/errors v0.8.0
 	github.com/sirupsen/logrus v1.3.0
-	golang.org/x/net v0.0.0-20190125091013-d26f9f9a57f3 // indirect
-	golang.org/x/text v0.3.0 // indirect
+	go.opentelemetry.io/otel v1.46.0
+	go.opentelemetry.io/otel/sdk v1.46.0
+	go.opentelemetry.io/otel/trace v1.46.0
+	golang.org/x/net v0.0.0-20190125091013-d26f9f9a57f3
+	golang.org/x/text v0.3.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/go-logr/logr v1.4.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	go.opentelemetry.io/auto/sdk v1.2.1 // indirect
+	go.opentelemetry.io/otel/metric v1.46.0 // indirect
+	golang.org/x/crypto v0.0.0-20180904163835-0709b304e793 // indirect
+	golang.org/x/sys v0.47.0 // indirect
 )
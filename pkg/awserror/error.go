@@ -0,0 +1,101 @@
+package awserror
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Error is a failed AWS call, carrying what's needed to decide how to handle it
+// without matching on strings.
+type Error struct {
+	// Op is the operation that failed, such as "s3:GetObject".
+	Op         string
+	Code       string
+	Message    string
+	RequestID  string
+	StatusCode int
+	// Retryable is true for throttling and transient service errors.
+	Retryable bool
+	// Kind is a package sentinel, such as storage.ErrObjectNotFound,
+	// that the error also matches with errors.Is.
+	Kind error
+
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("%s: %s: %s (request id %s)", e.Op, e.Code, e.Message, e.RequestID)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Op, e.Code, e.Message)
+}
+
+// Unwrap returns the original error from the SDK.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the error's Kind.
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// Wrap turns an SDK error from op into an *Error. Errors that didn't come
+// from the SDK, and nil, are returned as they are.
+func Wrap(op string, err error) error {
+	return WrapKind(op, err, nil)
+}
+
+// WrapKind is Wrap but also makes the error match kind with errors.Is.
+func WrapKind(op string, err error, kind error) error {
+	var aerr awserr.Error
+	if err == nil || !errors.As(err, &aerr) {
+		return err
+	}
+
+	e := &Error{
+		Op:        op,
+		Code:      aerr.Code(),
+		Message:   aerr.Message(),
+		Retryable: request.IsErrorRetryable(aerr) || request.IsErrorThrottle(aerr),
+		Kind:      kind,
+		Err:       err,
+	}
+	if rerr, ok := aerr.(awserr.RequestFailure); ok {
+		e.RequestID = rerr.RequestID()
+		e.StatusCode = rerr.StatusCode()
+		if e.StatusCode >= 500 {
+			e.Retryable = true
+		}
+	}
+	return e
+}
+
+// Code returns the AWS error code of err, or "" if it isn't an AWS error.
+func Code(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return aerr.Code()
+	}
+	return ""
+}
+
+// IsRetryable reports whether err is an AWS error worth trying again.
+func IsRetryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Retryable
+	}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return request.IsErrorRetryable(aerr) || request.IsErrorThrottle(aerr)
+	}
+	return false
+}
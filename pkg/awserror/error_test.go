@@ -0,0 +1,53 @@
+package awserror
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+var errTest = errors.New("test kind")
+
+func TestWrapExposesCodeRequestIDAndRetryable(t *testing.T) {
+	err := Wrap("ses:SendEmail", awserr.NewRequestFailure(awserr.New("Throttling", "Rate exceeded", nil), 400, "req-1"))
+
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatal("Expected error to be an *Error")
+	}
+	if e.Code != "Throttling" || e.RequestID != "req-1" || e.StatusCode != 400 || !e.Retryable {
+		t.Errorf("Unexpected error fields: %+v", e)
+	}
+	if e.Error() != "ses:SendEmail: Throttling: Rate exceeded (request id req-1)" {
+		t.Errorf("Unexpected message: %s", e.Error())
+	}
+}
+
+func TestWrapKindMatchesSentinelAndOriginal(t *testing.T) {
+	original := awserr.New("NoSuchKey", "missing", nil)
+	err := WrapKind("s3:GetObject", original, errTest)
+
+	if !errors.Is(err, errTest) {
+		t.Error("Expected error to match its kind")
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr != original {
+		t.Error("Expected original SDK error to be unwrapped")
+	}
+	if IsRetryable(err) {
+		t.Error("Expected NoSuchKey not to be retryable")
+	}
+}
+
+func TestWrapLeavesOtherErrorsAlone(t *testing.T) {
+	if Wrap("op", nil) != nil {
+		t.Error("Expected nil to stay nil")
+	}
+	if Wrap("op", errTest) != errTest {
+		t.Error("Expected non AWS error to be returned as is")
+	}
+	if Code(errTest) != "" {
+		t.Error("Expected no code for non AWS error")
+	}
+}
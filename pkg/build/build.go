@@ -0,0 +1,222 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+)
+
+// Defaults used when the matching Generator field is left empty.
+const (
+	DefaultBinary    = "hugo"
+	DefaultOutputDir = "public"
+	DefaultTimeout   = 5 * time.Minute
+)
+
+// waitDelay is how long Run waits for the generator's output to close once it
+// has been stopped or has exited, in case a process it started holds it open.
+const waitDelay = 5 * time.Second
+
+// ErrTimeout is returned when the generator runs for longer than its timeout.
+var ErrTimeout = errors.New("Build timed out")
+
+// ErrEmptyOutput is returned when the generator succeeds without writing anything.
+var ErrEmptyOutput = errors.New("Build output is empty")
+
+// Stats describe a successful build.
+type Stats struct {
+	// OutputDir is the absolute path of the built site.
+	OutputDir string
+	Files     int
+	Bytes     int64
+	Duration  time.Duration
+}
+
+// Generator runs a static site generator over a directory of site source.
+// Its Build method satisfies pipeline.Builder.
+type Generator struct {
+	// Binary is the generator to run, looked up in PATH unless it is a path. DefaultBinary when empty.
+	Binary string
+	// Args are passed to the binary, which is run from the source directory.
+	Args []string
+	// Env is added to the environment of the current process, as KEY=value pairs.
+	Env []string
+	// Timeout stops the generator when it runs longer, DefaultTimeout when zero.
+	Timeout time.Duration
+	// OutputDir is where the generator writes the site, relative to the source
+	// directory unless absolute. DefaultOutputDir when empty.
+	OutputDir string
+	// Logger receives the generator's output line by line, logging.Default() when nil.
+	Logger logging.Logger
+}
+
+func (g *Generator) log() logging.Logger {
+	return logging.Or(g.Logger)
+}
+
+// Build runs the generator and returns the directory the site was written to.
+func (g *Generator) Build(ctx context.Context, sourceDir string) (string, error) {
+	stats, err := g.Run(ctx, sourceDir)
+	if err != nil {
+		return "", err
+	}
+	return stats.OutputDir, nil
+}
+
+// Run runs the generator in sourceDir, logging stdout at info and stderr at
+// warn level, then checks the output directory exists and has files in it.
+func (g *Generator) Run(ctx context.Context, sourceDir string) (*Stats, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	binary := g.Binary
+	if binary == "" {
+		binary = DefaultBinary
+	}
+	timeout := g.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	sourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	outputDir := g.OutputDir
+	if outputDir == "" {
+		outputDir = DefaultOutputDir
+	}
+	if !filepath.IsAbs(outputDir) {
+		outputDir = filepath.Join(sourceDir, outputDir)
+	}
+
+	logger := g.log().WithFields(logging.Fields{
+		"binary": binary,
+		"path":   sourceDir,
+	})
+	logger.WithFields(logging.Fields{
+		"args":    strings.Join(g.Args, " "),
+		"timeout": timeout.String(),
+	}).Info("Running build")
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, binary, g.Args...)
+	cmd.Dir = sourceDir
+	cmd.Env = append(os.Environ(), g.Env...)
+	cmd.WaitDelay = waitDelay
+	killProcessGroup(cmd)
+	stdout := &lineLogger{log: logger.WithFields(logging.Fields{"stream": "stdout"}).Info}
+	stderr := &lineLogger{log: logger.WithFields(logging.Fields{"stream": "stderr"}).Warn}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err = cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	duration := time.Since(start)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		logger.WithFields(logging.Fields{"duration": duration.String()}).Error("Build timed out")
+		return nil, fmt.Errorf("%w: %s after %s", ErrTimeout, binary, timeout)
+	}
+	if err != nil {
+		logger.WithFields(logging.Fields{"error": err}).Error("Build failed")
+		if last := stderr.Last(); last != "" {
+			return nil, fmt.Errorf("Running %s failed: %w: %s", binary, err, last)
+		}
+		return nil, fmt.Errorf("Running %s failed: %w", binary, err)
+	}
+
+	stats := &Stats{OutputDir: outputDir, Duration: duration}
+	info, err := os.Stat(outputDir)
+	if err != nil {
+		logger.WithFields(logging.Fields{"error": err}).Error("Build output is missing")
+		return nil, fmt.Errorf("Build output %s: %w", outputDir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("Build output %s is not a directory", outputDir)
+	}
+	err = filepath.Walk(outputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			stats.Files++
+			stats.Bytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stats.Files == 0 {
+		logger.Error("Build output is empty")
+		return nil, fmt.Errorf("%w: %s", ErrEmptyOutput, outputDir)
+	}
+
+	logger.WithFields(logging.Fields{
+		"files":    stats.Files,
+		"bytes":    stats.Bytes,
+		"duration": duration.String(),
+	}).Info("Build complete")
+	return stats, nil
+}
+
+// lineLogger logs everything written to it a line at a time.
+type lineLogger struct {
+	log  func(msg string)
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	last string
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf.Write(p)
+	for {
+		i := bytes.IndexByte(l.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		l.line(string(l.buf.Next(i + 1)))
+	}
+	return len(p), nil
+}
+
+// Flush logs whatever is left after the last newline.
+func (l *lineLogger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buf.Len() > 0 {
+		l.line(l.buf.String())
+		l.buf.Reset()
+	}
+}
+
+// Last returns the last line that wasn't blank.
+func (l *lineLogger) Last() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+func (l *lineLogger) line(s string) {
+	s = strings.TrimRight(s, "\r\n")
+	if strings.TrimSpace(s) == "" {
+		return
+	}
+	l.last = s
+	l.log(s)
+}
@@ -0,0 +1,186 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.DebugLevel)
+	log.SetFormatter(&log.JSONFormatter{})
+	retCode := m.Run()
+	os.Exit(retCode)
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		log.WithFields(log.Fields{
+			"file":  filepath.Base(file),
+			"line":  line,
+			"error": err.Error(),
+		}).Error("unexpected error")
+		tb.FailNow()
+	}
+}
+
+func equals(tb testing.TB, expected, actual interface{}) {
+	if !reflect.DeepEqual(expected, actual) {
+		tb.Errorf("Expected: %v \n Actual: %v", expected, actual)
+	}
+}
+
+// stub writes a shell script standing in for the generator and returns the
+// source directory to run it in.
+func stub(t *testing.T, script string) (binary string, sourceDir string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Stub generator needs a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "build")
+	ok(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	binary = filepath.Join(dir, "generator")
+	ok(t, ioutil.WriteFile(binary, []byte("#!/bin/sh\n"+script), 0755))
+	sourceDir = filepath.Join(dir, "site")
+	ok(t, os.Mkdir(sourceDir, 0775))
+	return binary, sourceDir
+}
+
+func captureLogs() (logging.Logger, func() []map[string]interface{}) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return logging.Slog(l), func() []map[string]interface{} {
+		var entries []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var entry map[string]interface{}
+			json.Unmarshal([]byte(line), &entry)
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+}
+
+func TestRunBuildsSiteAndReturnsStats(t *testing.T) {
+	binary, sourceDir := stub(t, `
+mkdir -p public/posts
+printf '%s' "$SITE_TITLE" > public/index.html
+printf '%s' "$1" > public/posts/args.txt
+echo "Built site"
+echo "Deprecated option" >&2
+`)
+	logger, entries := captureLogs()
+	g := &Generator{
+		Binary: binary,
+		Args:   []string{"--minify"},
+		Env:    []string{"SITE_TITLE=Blog"},
+		Logger: logger,
+	}
+
+	stats, err := g.Run(context.Background(), sourceDir)
+	ok(t, err)
+
+	equals(t, filepath.Join(sourceDir, "public"), stats.OutputDir)
+	equals(t, 2, stats.Files)
+	equals(t, int64(len("Blog")+len("--minify")), stats.Bytes)
+
+	var output []string
+	for _, entry := range entries() {
+		if stream, ok := entry["stream"]; ok {
+			output = append(output, stream.(string)+": "+entry["msg"].(string)+" "+entry["level"].(string))
+		}
+	}
+	// The two streams are read separately so their order isn't guaranteed.
+	sort.Strings(output)
+	equals(t, []string{"stderr: Deprecated option WARN", "stdout: Built site INFO"}, output)
+}
+
+func TestBuildReturnsOutputDir(t *testing.T) {
+	binary, sourceDir := stub(t, "mkdir -p dist && touch dist/index.html\n")
+	g := &Generator{Binary: binary, OutputDir: "dist", Logger: logging.Nop()}
+
+	outputDir, err := g.Build(context.Background(), sourceDir)
+	ok(t, err)
+
+	equals(t, filepath.Join(sourceDir, "dist"), outputDir)
+}
+
+func TestRunReturnsFailureWithLastStderrLine(t *testing.T) {
+	binary, sourceDir := stub(t, "echo 'Error: config.toml not found' >&2\nexit 3\n")
+	g := &Generator{Binary: binary, Logger: logging.Nop()}
+
+	_, err := g.Run(context.Background(), sourceDir)
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("Expected exit status 3, received: %v", err)
+	}
+	if !strings.Contains(err.Error(), "config.toml not found") {
+		t.Errorf("Expected the error to include stderr, received: %v", err)
+	}
+}
+
+func TestRunRejectsMissingAndEmptyOutput(t *testing.T) {
+	binary, sourceDir := stub(t, "exit 0\n")
+	g := &Generator{Binary: binary, Logger: logging.Nop()}
+
+	_, err := g.Run(context.Background(), sourceDir)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing output error, received: %v", err)
+	}
+
+	ok(t, os.Mkdir(filepath.Join(sourceDir, "public"), 0775))
+	_, err = g.Run(context.Background(), sourceDir)
+	if !errors.Is(err, ErrEmptyOutput) {
+		t.Errorf("Expected ErrEmptyOutput, received: %v", err)
+	}
+}
+
+func TestRunStopsAtTimeout(t *testing.T) {
+	binary, sourceDir := stub(t, "exec sleep 5\n")
+	g := &Generator{Binary: binary, Timeout: 100 * time.Millisecond, Logger: logging.Nop()}
+
+	start := time.Now()
+	_, err := g.Run(context.Background(), sourceDir)
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, received: %v", err)
+	}
+	if time.Since(start) > 4*time.Second {
+		t.Error("Expected the generator to be stopped")
+	}
+}
+
+func TestRunStopsProcessesStartedByGenerator(t *testing.T) {
+	// Without exec the shell forks sleep, which holds stdout open after the
+	// shell itself is killed.
+	binary, sourceDir := stub(t, "sleep 3\n")
+	g := &Generator{Binary: binary, Timeout: 100 * time.Millisecond, Logger: logging.Nop()}
+
+	start := time.Now()
+	_, err := g.Run(context.Background(), sourceDir)
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, received: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the generator's children to be stopped, took %s", elapsed)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package build
+
+import "os/exec"
+
+// killProcessGroup leaves cmd to be killed on its own where there are no
+// process groups, relying on WaitDelay to stop waiting for its children.
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,190 @@
+package cdn
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+	"github.com/aws/aws-sdk-go/service/cloudfront/cloudfrontiface"
+	"github.com/cstdev/lambdahelpers/pkg/awserror"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+)
+
+// DefaultMaxPaths is how many paths an invalidation holds before they are
+// collapsed into wildcards. CloudFront charges per path, a wildcard counts as one.
+const DefaultMaxPaths = 100
+
+// DefaultMaxWildcards is how many wildcard paths an invalidation holds, the
+// most CloudFront allows to be in progress at once.
+const DefaultMaxWildcards = 15
+
+// Invalidator issues CloudFront invalidations for changed object keys
+type Invalidator struct {
+	Client         cloudfrontiface.CloudFrontAPI
+	DistributionID string
+
+	// MaxPaths caps the number of paths in a single invalidation,
+	// DefaultMaxPaths is used when it is zero.
+	MaxPaths int
+	// MaxWildcards caps the number of wildcard paths in a single invalidation,
+	// DefaultMaxWildcards is used when it is zero.
+	MaxWildcards int
+	// Wait blocks until CloudFront reports the invalidation as completed.
+	Wait bool
+	// Logger receives the invalidator's logs, logging.Default() when nil.
+	Logger logging.Logger
+}
+
+func (i *Invalidator) log() logging.Logger {
+	return logging.Or(i.Logger).WithFields(logging.Fields{})
+}
+
+// AfterUpload invalidates everything the report says was uploaded.
+// It can be used as storage.Bucket.AfterUpload.
+func (i *Invalidator) AfterUpload(report *storage.UploadReport) error {
+	if len(report.Uploaded) == 0 {
+		return nil
+	}
+	_, err := i.Invalidate(report.Uploaded)
+	return err
+}
+
+// Invalidate creates an invalidation for the given object keys and returns its ID.
+func (i *Invalidator) Invalidate(keys []string) (string, error) {
+	maxPaths := i.MaxPaths
+	if maxPaths <= 0 {
+		maxPaths = DefaultMaxPaths
+	}
+	maxWildcards := i.MaxWildcards
+	if maxWildcards <= 0 {
+		maxWildcards = DefaultMaxWildcards
+	}
+	paths := CollapsePaths(Paths(keys), maxPaths, maxWildcards)
+
+	i.log().WithFields(logging.Fields{
+		"paths": len(paths),
+	}).Info("Creating invalidation")
+
+	resp, err := i.Client.CreateInvalidation(&cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(i.DistributionID),
+		InvalidationBatch: &cloudfront.InvalidationBatch{
+			CallerReference: aws.String(strconv.FormatInt(time.Now().UnixNano(), 10)),
+			Paths: &cloudfront.Paths{
+				Items:    aws.StringSlice(paths),
+				Quantity: aws.Int64(int64(len(paths))),
+			},
+		},
+	})
+	if err != nil {
+		i.log().Error("Failed to create invalidation")
+		return "", awserror.Wrap("cloudfront:CreateInvalidation", err)
+	}
+
+	id := aws.StringValue(resp.Invalidation.Id)
+	if !i.Wait {
+		return id, nil
+	}
+
+	err = i.Client.WaitUntilInvalidationCompleted(&cloudfront.GetInvalidationInput{
+		DistributionId: aws.String(i.DistributionID),
+		Id:             aws.String(id),
+	})
+	if err != nil {
+		i.log().WithFields(logging.Fields{
+			"invalidation": id,
+		}).Error("Failed waiting for invalidation to complete")
+		return id, awserror.Wrap("cloudfront:WaitUntilInvalidationCompleted", err)
+	}
+
+	return id, nil
+}
+
+// Paths turns object keys into escaped CloudFront paths.
+// Keys for index.html also invalidate the folder URL that serves them.
+func Paths(keys []string) []string {
+	seen := make(map[string]bool)
+	var paths []string
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	for _, key := range keys {
+		key = strings.TrimPrefix(key, "/")
+		segments := strings.Split(key, "/")
+		for n, segment := range segments {
+			segments[n] = url.PathEscape(segment)
+		}
+		path := "/" + strings.Join(segments, "/")
+		add(path)
+		if strings.HasSuffix(path, "/index.html") {
+			add(strings.TrimSuffix(path, "index.html"))
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// CollapsePaths replaces paths with folder wildcards, starting with the
+// deepest folders, until no more than maxPaths paths remain of which no
+// more than maxWildcards are wildcards.
+func CollapsePaths(paths []string, maxPaths, maxWildcards int) []string {
+	within := func(paths []string) bool {
+		return len(paths) <= maxPaths && wildcards(paths) <= maxWildcards
+	}
+	if within(paths) {
+		return paths
+	}
+
+	depth := 0
+	for _, p := range paths {
+		if d := len(folders(p)); d > depth {
+			depth = d
+		}
+	}
+
+	for ; depth >= 0; depth-- {
+		seen := make(map[string]bool)
+		var collapsed []string
+		for _, p := range paths {
+			dirs := folders(p)
+			if len(dirs) >= depth {
+				p = "/" + strings.Join(append(dirs[:depth:depth], "*"), "/")
+			}
+			if !seen[p] {
+				seen[p] = true
+				collapsed = append(collapsed, p)
+			}
+		}
+		sort.Strings(collapsed)
+		paths = collapsed
+		if within(paths) {
+			break
+		}
+	}
+	return paths
+}
+
+// wildcards counts the paths ending in a wildcard.
+func wildcards(paths []string) int {
+	n := 0
+	for _, p := range paths {
+		if strings.HasSuffix(p, "*") {
+			n++
+		}
+	}
+	return n
+}
+
+// folders returns the folder names leading up to the last element of a path.
+func folders(path string) []string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	return segments[:len(segments)-1]
+}
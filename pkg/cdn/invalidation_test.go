@@ -0,0 +1,142 @@
+package cdn
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+	"github.com/aws/aws-sdk-go/service/cloudfront/cloudfrontiface"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.DebugLevel)
+	log.SetFormatter(&log.JSONFormatter{})
+	retCode := m.Run()
+	os.Exit(retCode)
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		log.WithFields(log.Fields{
+			"file":  filepath.Base(file),
+			"line":  line,
+			"error": err.Error(),
+		}).Error("unexpected error")
+		tb.FailNow()
+	}
+}
+
+func equals(tb testing.TB, expected, actual interface{}) {
+	if !reflect.DeepEqual(expected, actual) {
+		tb.Errorf("Expected: %v \n Actual: %v", expected, actual)
+	}
+}
+
+type mockedCloudFrontAPI struct {
+	cloudfrontiface.CloudFrontAPI
+	CreateInvalidationFunc func(*cloudfront.CreateInvalidationInput) (*cloudfront.CreateInvalidationOutput, error)
+	WaitFunc               func(*cloudfront.GetInvalidationInput) error
+}
+
+func (m *mockedCloudFrontAPI) CreateInvalidation(i *cloudfront.CreateInvalidationInput) (*cloudfront.CreateInvalidationOutput, error) {
+	return m.CreateInvalidationFunc(i)
+}
+
+func (m *mockedCloudFrontAPI) WaitUntilInvalidationCompleted(i *cloudfront.GetInvalidationInput) error {
+	return m.WaitFunc(i)
+}
+
+func TestPathsEscapeKeysAndAddFolderForIndex(t *testing.T) {
+	paths := Paths([]string{"/posts/index.html", "css/site.css", "my post.html", "css/site.css"})
+
+	equals(t, []string{"/css/site.css", "/my%20post.html", "/posts/", "/posts/index.html"}, paths)
+}
+
+func TestCollapsePathsCollapsesDeepestFoldersFirst(t *testing.T) {
+	paths := []string{
+		"/index.html",
+		"/posts/a/index.html",
+		"/posts/b/index.html",
+		"/posts/c.html",
+		"/css/site.css",
+	}
+
+	equals(t, paths, CollapsePaths(paths, 5, DefaultMaxWildcards))
+	equals(t, []string{"/css/*", "/index.html", "/posts/*"}, CollapsePaths(paths, 4, DefaultMaxWildcards))
+	equals(t, []string{"/*"}, CollapsePaths(paths, 2, DefaultMaxWildcards))
+}
+
+func TestCollapsePathsKeepsWildcardsWithinMax(t *testing.T) {
+	var paths []string
+	for n := 0; n < 60; n++ {
+		paths = append(paths,
+			fmt.Sprintf("/posts/%d/index.html", n),
+			fmt.Sprintf("/posts/%d/photo.jpg", n),
+		)
+	}
+	paths = append(paths, "/index.html")
+
+	collapsed := CollapsePaths(paths, DefaultMaxPaths, DefaultMaxWildcards)
+
+	if n := wildcards(collapsed); n > DefaultMaxWildcards {
+		t.Fatalf("Expected at most %d wildcards, received %d: %v", DefaultMaxWildcards, n, collapsed)
+	}
+	equals(t, []string{"/index.html", "/posts/*"}, collapsed)
+}
+
+func TestInvalidateSendsCollapsedPathsAndWaits(t *testing.T) {
+	var sent []string
+	waitedFor := ""
+	var keys []string
+	for n := 0; n < 5; n++ {
+		keys = append(keys, fmt.Sprintf("posts/%d.html", n))
+	}
+
+	i := Invalidator{
+		Client: &mockedCloudFrontAPI{
+			CreateInvalidationFunc: func(in *cloudfront.CreateInvalidationInput) (*cloudfront.CreateInvalidationOutput, error) {
+				sent = aws.StringValueSlice(in.InvalidationBatch.Paths.Items)
+				equals(t, int64(len(sent)), *in.InvalidationBatch.Paths.Quantity)
+				return &cloudfront.CreateInvalidationOutput{
+					Invalidation: &cloudfront.Invalidation{Id: aws.String("ID1")},
+				}, nil
+			},
+			WaitFunc: func(in *cloudfront.GetInvalidationInput) error {
+				waitedFor = *in.Id
+				return nil
+			},
+		},
+		DistributionID: "DIST",
+		MaxPaths:       3,
+		Wait:           true,
+	}
+
+	id, err := i.Invalidate(keys)
+	ok(t, err)
+
+	equals(t, "ID1", id)
+	equals(t, "ID1", waitedFor)
+	equals(t, []string{"/posts/*"}, sent)
+}
+
+func TestAfterUploadSkipsEmptyReports(t *testing.T) {
+	i := Invalidator{
+		Client: &mockedCloudFrontAPI{
+			CreateInvalidationFunc: func(*cloudfront.CreateInvalidationInput) (*cloudfront.CreateInvalidationOutput, error) {
+				t.Error("Expected no invalidation for an empty report")
+				return nil, nil
+			},
+		},
+	}
+
+	ok(t, i.AfterUpload(&storage.UploadReport{}))
+}
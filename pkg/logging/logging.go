@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Fields are the structured key/values attached to a log entry.
+// The helpers use "bucket", "key", "path", "op" and "error" consistently.
+type Fields map[string]interface{}
+
+// Logger is what the helpers log through. Adapters are provided for logrus and slog.
+type Logger interface {
+	WithFields(Fields) Logger
+	Debug(msg string)
+	Info(msg string)
+	Warn(msg string)
+	Error(msg string)
+}
+
+// Default is the logger used when a helper isn't given one,
+// the standard logrus logger to match earlier releases.
+func Default() Logger {
+	return Logrus(logrus.StandardLogger())
+}
+
+// Or returns l, or Default if l is nil, masking PII with DefaultRedaction
+// unless l already redacts or was made by Redacting with Reveal set.
+func Or(l Logger) Logger {
+	if l == nil {
+		l = Default()
+	}
+	switch l.(type) {
+	case redactingLogger, revealingLogger:
+		return l
+	}
+	return Redacting(l, DefaultRedaction())
+}
+
+// Nop returns a logger that discards everything.
+func Nop() Logger {
+	return nop{}
+}
+
+type nop struct{}
+
+func (n nop) WithFields(Fields) Logger { return n }
+func (nop) Debug(string)               {}
+func (nop) Info(string)                {}
+func (nop) Warn(string)                {}
+func (nop) Error(string)               {}
+
+// Logrus adapts a logrus logger or entry, such as one carrying the Lambda request ID.
+func Logrus(l logrus.FieldLogger) Logger {
+	return logrusLogger{l}
+}
+
+type logrusLogger struct {
+	l logrus.FieldLogger
+}
+
+func (l logrusLogger) WithFields(f Fields) Logger {
+	return logrusLogger{l.l.WithFields(logrus.Fields(f))}
+}
+func (l logrusLogger) Debug(msg string) { l.l.Debug(msg) }
+func (l logrusLogger) Info(msg string)  { l.l.Info(msg) }
+func (l logrusLogger) Warn(msg string)  { l.l.Warn(msg) }
+func (l logrusLogger) Error(msg string) { l.l.Error(msg) }
+
+// Slog adapts a log/slog logger.
+func Slog(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (l slogLogger) WithFields(f Fields) Logger {
+	args := make([]interface{}, 0, len(f)*2)
+	for k, v := range f {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		args = append(args, k, v)
+	}
+	return slogLogger{l.l.With(args...)}
+}
+func (l slogLogger) Debug(msg string) { l.l.Log(context.Background(), slog.LevelDebug, msg) }
+func (l slogLogger) Info(msg string)  { l.l.Log(context.Background(), slog.LevelInfo, msg) }
+func (l slogLogger) Warn(msg string)  { l.l.Log(context.Background(), slog.LevelWarn, msg) }
+func (l slogLogger) Error(msg string) { l.l.Log(context.Background(), slog.LevelError, msg) }
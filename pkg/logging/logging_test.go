@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogrusAdapterKeepsFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.Out = &buf
+	l.Formatter = &logrus.JSONFormatter{}
+
+	Logrus(l).WithFields(Fields{"bucket": "site"}).WithFields(Fields{"key": "index.html"}).Error("Failed")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["bucket"] != "site" || entry["key"] != "index.html" || entry["msg"] != "Failed" || entry["level"] != "error" {
+		t.Errorf("Unexpected entry: %v", entry)
+	}
+}
+
+func TestSlogAdapterKeepsFieldsAndLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	Slog(l).WithFields(Fields{"bucket": "site", "error": errors.New("boom")}).Warn("Retrying")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["bucket"] != "site" || entry["error"] != "boom" || entry["msg"] != "Retrying" || entry["level"] != "WARN" {
+		t.Errorf("Unexpected entry: %v", entry)
+	}
+}
+
+func TestNopAndOr(t *testing.T) {
+	Nop().WithFields(Fields{"a": 1}).Error("ignored")
+
+	if r, ok := Or(nil).(redactingLogger); !ok || r.l != Default() {
+		t.Error("Expected Or(nil) to return the default logger, redacted")
+	}
+	redacted := Redacting(Nop(), Redaction{Allow: []string{"sender"}})
+	if Or(redacted).(redactingLogger).allow["sender"] != true {
+		t.Error("Expected Or to keep a logger that already redacts")
+	}
+}
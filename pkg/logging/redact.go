@@ -0,0 +1,190 @@
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// RevealEnv turns redaction off for loggers using DefaultRedaction when set to "true",
+// so PII can be seen while debugging without a code change.
+const RevealEnv = "LAMBDAHELPERS_LOG_PII"
+
+// KeyEnv holds the secret DefaultRedaction keys fingerprints with. It should
+// differ between deployments and be kept out of the logs it protects.
+const KeyEnv = "LAMBDAHELPERS_LOG_KEY"
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	phonePattern = regexp.MustCompile(`\+[1-9][0-9]{6,14}`)
+)
+
+// bodyFields hold message content, which is replaced entirely.
+var bodyFields = map[string]bool{
+	"message": true,
+	"body":    true,
+	"text":    true,
+	"html":    true,
+}
+
+// phoneFields hold phone numbers in whatever format the caller passed.
+var phoneFields = map[string]bool{
+	"number": true,
+	"phone":  true,
+}
+
+// Redaction configures how a redacting logger masks PII.
+type Redaction struct {
+	// Allow lists field names logged as they are.
+	Allow []string
+	// Reveal turns redaction off, for debugging.
+	Reveal bool
+	// Key, when set, adds a short HMAC of each masked value so entries about
+	// the same person still correlate. Without it masked values carry no
+	// fingerprint, as an unkeyed hash of a phone number is easily reversed.
+	Key []byte
+}
+
+// DefaultRedaction masks everything, unless RevealEnv is set,
+// fingerprinting with the key in KeyEnv if there is one.
+func DefaultRedaction() Redaction {
+	return Redaction{
+		Reveal: os.Getenv(RevealEnv) == "true",
+		Key:    []byte(os.Getenv(KeyEnv)),
+	}
+}
+
+// Redacting wraps l so phone numbers, email addresses and message bodies
+// are masked in fields and messages before they reach it. With Reveal set
+// l is marked as revealing instead, so Or leaves it unmasked.
+func Redacting(l Logger, r Redaction) Logger {
+	if r.Reveal {
+		return revealingLogger{l}
+	}
+	allow := make(map[string]bool, len(r.Allow))
+	for _, k := range r.Allow {
+		allow[k] = true
+	}
+	return redactingLogger{l: l, allow: allow, masker: masker{key: r.Key}}
+}
+
+type redactingLogger struct {
+	l     Logger
+	allow map[string]bool
+	masker
+}
+
+func (r redactingLogger) WithFields(f Fields) Logger {
+	masked := make(Fields, len(f))
+	for k, v := range f {
+		masked[k] = r.field(k, v)
+	}
+	return redactingLogger{l: r.l.WithFields(masked), allow: r.allow, masker: r.masker}
+}
+
+func (r redactingLogger) Debug(msg string) { r.l.Debug(r.mask(msg)) }
+func (r redactingLogger) Info(msg string)  { r.l.Info(r.mask(msg)) }
+func (r redactingLogger) Warn(msg string)  { r.l.Warn(r.mask(msg)) }
+func (r redactingLogger) Error(msg string) { r.l.Error(r.mask(msg)) }
+
+// revealingLogger marks a logger that has opted out of redaction.
+type revealingLogger struct {
+	l Logger
+}
+
+func (r revealingLogger) WithFields(f Fields) Logger { return revealingLogger{r.l.WithFields(f)} }
+func (r revealingLogger) Debug(msg string)           { r.l.Debug(msg) }
+func (r revealingLogger) Info(msg string)            { r.l.Info(msg) }
+func (r revealingLogger) Warn(msg string)            { r.l.Warn(msg) }
+func (r revealingLogger) Error(msg string)           { r.l.Error(msg) }
+
+func (r redactingLogger) field(k string, v interface{}) interface{} {
+	if r.allow[k] {
+		return v
+	}
+
+	var s string
+	switch value := v.(type) {
+	case string:
+		s = value
+	case *string:
+		if value == nil {
+			return v
+		}
+		s = *value
+	case error:
+		s = value.Error()
+	case fmt.Stringer:
+		s = value.String()
+	default:
+		return v
+	}
+
+	switch {
+	case bodyFields[strings.ToLower(k)]:
+		return r.maskBody(s)
+	case phoneFields[strings.ToLower(k)]:
+		return r.maskPhone(s)
+	}
+	return r.mask(s)
+}
+
+// Mask replaces any email addresses and E.164 phone numbers in s.
+// Unlike a redacting logger given a Key, it adds no fingerprints.
+func Mask(s string) string {
+	return masker{}.mask(s)
+}
+
+// masker masks values, fingerprinting them when it has a key.
+type masker struct {
+	key []byte
+}
+
+func (m masker) mask(s string) string {
+	s = emailPattern.ReplaceAllStringFunc(s, m.maskEmail)
+	return phonePattern.ReplaceAllStringFunc(s, m.maskPhone)
+}
+
+func (m masker) maskEmail(email string) string {
+	email = strings.ToLower(email)
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return "***" + m.digest(email)
+	}
+	return email[:1] + "***" + email[at:] + m.digest(email)
+}
+
+func (m masker) maskPhone(number string) string {
+	if number == "" {
+		return number
+	}
+	if len(number) <= 4 {
+		return "***" + m.digest(number)
+	}
+	return number[:3] + strings.Repeat("*", len(number)-5) + number[len(number)-2:] + m.digest(number)
+}
+
+func (m masker) maskBody(body string) string {
+	if body == "" {
+		return body
+	}
+	if fingerprint := m.digest(body); fingerprint != "" {
+		return fmt.Sprintf("[redacted %d bytes %s]", len(body), fingerprint)
+	}
+	return fmt.Sprintf("[redacted %d bytes]", len(body))
+}
+
+// digest is a short, stable fingerprint of a value keyed with the masker's
+// key, empty without one.
+func (m masker) digest(s string) string {
+	if len(m.key) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(s))
+	return "#" + hex.EncodeToString(mac.Sum(nil)[:4])
+}
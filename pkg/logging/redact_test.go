@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+type captured struct {
+	fields Fields
+	msg    string
+}
+
+type captureLogger struct {
+	fields Fields
+	out    *captured
+}
+
+func (c captureLogger) WithFields(f Fields) Logger { return captureLogger{fields: f, out: c.out} }
+func (c captureLogger) Debug(msg string)           { *c.out = captured{c.fields, msg} }
+func (c captureLogger) Info(msg string)            { *c.out = captured{c.fields, msg} }
+func (c captureLogger) Warn(msg string)            { *c.out = captured{c.fields, msg} }
+func (c captureLogger) Error(msg string)           { *c.out = captured{c.fields, msg} }
+
+func TestRedactingMasksPhoneEmailAndBody(t *testing.T) {
+	out := &captured{}
+	l := Redacting(captureLogger{out: out}, Redaction{})
+
+	l.WithFields(Fields{
+		"number":    "+447700900123",
+		"recipient": "jane.doe@example.com",
+		"message":   "Meet me at 5",
+		"error":     errors.New("send to jane.doe@example.com failed"),
+		"key":       "mail/abc123",
+		"attempt":   2,
+	}).Error("Failed sending to +447700900123")
+
+	if v := out.fields["number"].(string); strings.Contains(v, "7700900") || !strings.HasPrefix(v, "+44") {
+		t.Errorf("Phone number not masked: %s", v)
+	}
+	if v := out.fields["recipient"].(string); strings.Contains(v, "jane.doe") || !strings.Contains(v, "@example.com") {
+		t.Errorf("Email not masked: %s", v)
+	}
+	if v := out.fields["message"].(string); strings.Contains(v, "Meet") {
+		t.Errorf("Body not masked: %s", v)
+	}
+	if v := out.fields["error"].(string); strings.Contains(v, "jane.doe") {
+		t.Errorf("Email in error not masked: %s", v)
+	}
+	if out.fields["key"] != "mail/abc123" || out.fields["attempt"] != 2 {
+		t.Errorf("Expected other fields to be untouched: %v", out.fields)
+	}
+	if strings.Contains(out.msg, "7700900") {
+		t.Errorf("Phone number in message not masked: %s", out.msg)
+	}
+}
+
+func TestRedactingKeepsValuesCorrelatable(t *testing.T) {
+	m := masker{key: []byte("deployment secret")}
+	if m.maskEmail("Jane@Example.com") != m.maskEmail("jane@example.com") {
+		t.Error("Expected the same address to mask the same way regardless of case")
+	}
+	if m.maskBody("Meet me at 5") == m.maskBody("Meet me at 6") {
+		t.Error("Expected different bodies to mask differently")
+	}
+	if m.maskPhone("+447700900123") == (masker{key: []byte("other secret")}).maskPhone("+447700900123") {
+		t.Error("Expected fingerprints to depend on the key")
+	}
+}
+
+func TestRedactingWithoutKeyAddsNoFingerprint(t *testing.T) {
+	m := masker{}
+	for masked, expected := range map[string]string{
+		m.maskPhone("+447700900123"):    "+44********23",
+		m.maskEmail("jane@example.com"): "j***@example.com",
+		m.maskBody("Meet me at 5"):      "[redacted 12 bytes]",
+	} {
+		if masked != expected {
+			t.Errorf("Expected: %s \n Actual: %s", expected, masked)
+		}
+	}
+}
+
+func TestRedactionAllowAndReveal(t *testing.T) {
+	out := &captured{}
+	Redacting(captureLogger{out: out}, Redaction{Allow: []string{"sender"}}).
+		WithFields(Fields{"sender": "blog@example.com"}).Info("Sending")
+	if out.fields["sender"] != "blog@example.com" {
+		t.Errorf("Expected allowed field to be logged as is: %v", out.fields)
+	}
+
+	Redacting(captureLogger{out: out}, Redaction{Reveal: true}).
+		WithFields(Fields{"number": "+447700900123"}).Info("Sending")
+	if out.fields["number"] != "+447700900123" {
+		t.Errorf("Expected reveal to turn redaction off: %v", out.fields)
+	}
+
+	// Or is how every helper takes its logger, so it mustn't redact again.
+	Or(Redacting(captureLogger{out: out}, Redaction{Reveal: true})).
+		WithFields(Fields{"number": "+447700900123"}).Info("Sending")
+	if out.fields["number"] != "+447700900123" {
+		t.Errorf("Expected Or to keep a revealing logger: %v", out.fields)
+	}
+
+	os.Setenv(RevealEnv, "true")
+	defer os.Unsetenv(RevealEnv)
+	if !DefaultRedaction().Reveal {
+		t.Errorf("Expected %s to reveal PII", RevealEnv)
+	}
+}
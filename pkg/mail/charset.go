@@ -0,0 +1,76 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// ErrUnsupportedCharset is returned for an Email.Charset that isn't known or
+// can't hold the email's text.
+var ErrUnsupportedCharset = errors.New("Unsupported charset")
+
+// charset converts text, which is UTF-8 in Go, into the character set an email is written in.
+type charset struct {
+	name string
+	// encoder is nil for UTF-8 and US-ASCII, which don't need converting.
+	encoder *encoding.Encoder
+	ascii   bool
+}
+
+// lookupCharset returns the charset called name, UTF-8 when it is empty.
+// Charsets that don't keep ASCII as it is, such as UTF-16, are unsupported
+// as text parts need their line breaks.
+func lookupCharset(name string) (charset, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return charset{name: charSet}, nil
+	}
+	enc, err := ianaindex.MIME.Encoding(name)
+	if err != nil {
+		return charset{}, fmt.Errorf("%w: %s", ErrUnsupportedCharset, name)
+	}
+	if enc == nil {
+		// ianaindex knows US-ASCII but has no encoding for it.
+		if strings.EqualFold(name, "US-ASCII") {
+			return charset{name: "US-ASCII", ascii: true}, nil
+		}
+		return charset{}, fmt.Errorf("%w: %s", ErrUnsupportedCharset, name)
+	}
+	canonical, err := ianaindex.MIME.Name(enc)
+	if err != nil {
+		return charset{}, fmt.Errorf("%w: %s", ErrUnsupportedCharset, name)
+	}
+	switch {
+	case canonical == charSet:
+		return charset{name: charSet}, nil
+	case strings.HasPrefix(canonical, "UTF-16"), strings.HasPrefix(canonical, "UTF-32"):
+		return charset{}, fmt.Errorf("%w: %s", ErrUnsupportedCharset, name)
+	}
+	return charset{name: canonical, encoder: enc.NewEncoder()}, nil
+}
+
+// encode converts s into the charset, returning ErrUnsupportedCharset if
+// the charset can't hold it.
+func (c charset) encode(s string) (string, error) {
+	if c.ascii {
+		for i := 0; i < len(s); i++ {
+			if s[i] >= utf8.RuneSelf {
+				return "", fmt.Errorf("%w: text isn't %s", ErrUnsupportedCharset, c.name)
+			}
+		}
+		return s, nil
+	}
+	if c.encoder == nil {
+		return s, nil
+	}
+	encoded, err := c.encoder.String(s)
+	if err != nil {
+		return "", fmt.Errorf("%w: text can't be written in %s", ErrUnsupportedCharset, c.name)
+	}
+	return encoded, nil
+}
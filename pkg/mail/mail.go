@@ -1,79 +1,317 @@
 package mail
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	netmail "net/mail"
 	"strings"
+	"time"
 
 	"github.com/DusanKasan/parsemail"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/aws/aws-sdk-go/service/ses/sesiface"
-
-	log "github.com/sirupsen/logrus"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	"github.com/cstdev/lambdahelpers/pkg/metrics"
+	"github.com/cstdev/lambdahelpers/pkg/retry"
+	"github.com/cstdev/lambdahelpers/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const subject = "S3Reader Raw"
 const charSet = "UTF-8"
 
+// ErrMissingRecipient is returned when an email has nobody to send to.
+var ErrMissingRecipient = errors.New("Missing recipient")
+
+// ErrMissingSender is returned when an email has no sender.
+var ErrMissingSender = errors.New("Missing sender")
+
 type SESMail struct {
 	Client sesiface.SESAPI
+	// Retry is the policy for SES calls, a single attempt is made when nil.
+	Retry *retry.Policy
+	// Logger receives the client's logs, logging.Default() when nil.
+	Logger logging.Logger
+	// Metrics receives sent and failed email counts and latency, nothing is recorded when nil.
+	Metrics metrics.Sink
+	// Tracer starts the spans around SES calls, the global OpenTelemetry tracer when nil.
+	Tracer trace.Tracer
+
+	ctx context.Context
+}
+
+// WithContext returns a copy of the client whose calls are cancelled along with
+// ctx and whose spans are children of the span in ctx.
+func (m *SESMail) WithContext(ctx context.Context) *SESMail {
+	c := *m
+	c.ctx = ctx
+	return &c
+}
+
+func (m *SESMail) log() logging.Logger {
+	return logging.Or(m.Logger)
 }
 
+// call makes an SES call with the client's retry policy, recording its
+// latency and a span named op.
+func (m *SESMail) call(op string, fn func(ctx context.Context) error) error {
+	return m.callWithSpan(op, func(ctx context.Context, _ trace.Span) error { return fn(ctx) })
+}
+
+// callWithSpan is call for fns that add attributes to the span.
+func (m *SESMail) callWithSpan(op string, fn func(ctx context.Context, span trace.Span) error) (err error) {
+	ctx, span := tracing.Start(m.ctx, m.Tracer, op)
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
+	err = m.Retry.DoWithLogger(m.log(), op, func() error { return fn(ctx, span) })
+	metrics.Since(m.Metrics, op, start)
+	return templateError(op, err)
+}
+
+// SendMail sends body as a plain text email with a fixed subject to a single recipient.
 func (m *SESMail) SendMail(recipient string, sender string, body string) error {
+	_, err := m.Send(Email{
+		From:     sender,
+		To:       []string{recipient},
+		Subject:  subject,
+		TextBody: body,
+	})
+	return err
+}
 
-	log.WithFields(log.Fields{
-		"sender":    sender,
-		"recipient": recipient,
+// Email is a message to send through SES. At least one of To, CC and BCC is required.
+type Email struct {
+	From    string
+	To      []string
+	CC      []string
+	BCC     []string
+	ReplyTo []string
+	Subject string
+	// TextBody and HTMLBody are sent as alternatives when both are set.
+	TextBody string
+	HTMLBody string
+	// Charset is the character set the subject, bodies and headers are written
+	// in, UTF-8 when empty. The text is converted into it, returning
+	// ErrUnsupportedCharset if the charset isn't known or can't hold the text.
+	// Emails in charsets other than UTF-8 and US-ASCII are sent with SendRaw.
+	Charset string
+	// ReturnPath is where bounces are sent, the From address when empty.
+	ReturnPath string
+	// Attachments are attached to the email, inline ones are referenced from
+	// HTMLBody with cid: URLs. Emails with attachments are sent with SendRaw.
+	Attachments []Attachment
+	// Header holds extra headers such as List-Unsubscribe. Emails with headers are sent with SendRaw.
+	Header netmail.Header
+}
+
+func (e Email) recipients() int {
+	return len(addresses(e.To)) + len(addresses(e.CC)) + len(addresses(e.BCC))
+}
+
+// addresses drops blank entries, returning nil when nothing is left.
+func addresses(list []string) []*string {
+	var out []*string
+	for _, address := range list {
+		if strings.TrimSpace(address) != "" {
+			out = append(out, aws.String(address))
+		}
+	}
+	return out
+}
+
+// Send sends the email and returns the message ID SES gave it.
+func (m *SESMail) Send(e Email) (messageID string, err error) {
+	charset, err := lookupCharset(e.Charset)
+	if err != nil {
+		return "", err
+	}
+	// SendEmail takes the text as UTF-8, so converting it needs SendRaw.
+	if len(e.Attachments) > 0 || len(e.Header) > 0 || charset.encoder != nil {
+		return m.SendRaw(e)
+	}
+
+	m.log().WithFields(logging.Fields{
+		"sender":     e.From,
+		"recipient":  strings.Join(e.To, ", "),
+		"recipients": e.recipients(),
 	}).Debug("Sending email")
 
+	if e.recipients() == 0 {
+		return "", ErrMissingRecipient
+	}
+	if e.From == "" {
+		return "", ErrMissingSender
+	}
+
+	for _, text := range []string{e.Subject, e.TextBody, e.HTMLBody} {
+		if _, err := charset.encode(text); err != nil {
+			return "", err
+		}
+	}
+	body := &ses.Body{}
+	if e.HTMLBody != "" {
+		body.Html = &ses.Content{
+			Charset: aws.String(charset.name),
+			Data:    aws.String(e.HTMLBody),
+		}
+	}
+	if e.TextBody != "" || e.HTMLBody == "" {
+		body.Text = &ses.Content{
+			Charset: aws.String(charset.name),
+			Data:    aws.String(e.TextBody),
+		}
+	}
+
 	input := &ses.SendEmailInput{
 		Destination: &ses.Destination{
-			ToAddresses: []*string{
-				aws.String(recipient),
-			},
+			ToAddresses:  addresses(e.To),
+			CcAddresses:  addresses(e.CC),
+			BccAddresses: addresses(e.BCC),
 		},
 		Message: &ses.Message{
-			Body: &ses.Body{
-				Text: &ses.Content{
-					Charset: aws.String(charSet),
-					Data:    aws.String(body),
-				},
-			},
+			Body: body,
 			Subject: &ses.Content{
-				Charset: aws.String(charSet),
-				Data:    aws.String(subject),
+				Charset: aws.String(charset.name),
+				Data:    aws.String(e.Subject),
 			},
 		},
-		Source: aws.String(sender),
+		ReplyToAddresses: addresses(e.ReplyTo),
+		Source:           aws.String(e.From),
+	}
+	if e.ReturnPath != "" {
+		input.ReturnPath = aws.String(e.ReturnPath)
 	}
 
-	_, err := m.Client.SendEmail(input)
-
+	err = m.callWithSpan("ses:SendEmail", func(ctx context.Context, span trace.Span) error {
+		resp, err := m.Client.SendEmailWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		messageID = aws.StringValue(resp.MessageId)
+		span.SetAttributes(tracing.MessageID.String(messageID))
+		return nil
+	})
 	if err != nil {
-		log.Error("Failed to send email")
-		return err
+		metrics.Or(m.Metrics).Record(metrics.EmailsFailed, 1, metrics.Count, nil)
+		m.log().Error("Failed to send email")
+		return "", err
 	}
+	metrics.Or(m.Metrics).Record(metrics.EmailsSent, 1, metrics.Count, nil)
 
-	return nil
+	return messageID, nil
 }
 
 type Message struct {
 	Subject string
 	Body    string
+	// From is the first From address, or the Sender when there is none.
+	From *netmail.Address
+	// Date is the time from the Date header, zero when it is missing.
+	Date     time.Time
+	Header   netmail.Header
+	HTMLBody string
+	// Attachments holds attached files followed by inline files referenced with cid: URLs.
+	Attachments []Attachment
 }
 
-func ParseBody(email string) Message {
-	log.Debug("Parsing body")
+// Attachment is a file sent with a message. Inline files have a ContentID.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string
+	Data        []byte
+}
 
-	p := strings.NewReader(email)
-	emailOut, err := parsemail.Parse(p)
+// Inline reports whether the file is referenced from the HTML body rather than attached.
+func (a Attachment) Inline() bool {
+	return a.ContentID != ""
+}
+
+// ParseBody parses a raw email, panicking if it can't be parsed.
+// Like Parse it doesn't log, callers such as Inbox log with their own Logger.
+func ParseBody(email string) Message {
+	message, err := Parse(strings.NewReader(email))
 	if err != nil {
 		panic(err)
 	}
 
-	message := &Message{
+	return message
+}
+
+// Parse reads a raw email into a Message.
+func Parse(r io.Reader) (Message, error) {
+	emailOut, err := parsemail.Parse(r)
+	if err != nil {
+		return Message{}, err
+	}
+
+	message := Message{
 		Subject: emailOut.Subject,
 		Body:    emailOut.TextBody,
+		From:    emailOut.Sender,
+		Date:    emailOut.Date,
+		Header:  emailOut.Header,
+
+		HTMLBody: emailOut.HTMLBody,
+	}
+	if len(emailOut.From) > 0 {
+		message.From = emailOut.From[0]
+	}
+	// Parts of a multipart message are decoded as they are read, a single part body isn't.
+	mediaType, _, _ := mime.ParseMediaType(emailOut.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		encoding := emailOut.Header.Get("Content-Transfer-Encoding")
+		message.Body = decodeTransferEncoding(encoding, message.Body)
+		message.HTMLBody = decodeTransferEncoding(encoding, message.HTMLBody)
 	}
+	for _, a := range emailOut.Attachments {
+		data, err := ioutil.ReadAll(a.Data)
+		if err != nil {
+			return Message{}, err
+		}
+		message.Attachments = append(message.Attachments, Attachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Data:        data,
+		})
+	}
+	for _, f := range emailOut.EmbeddedFiles {
+		data, err := ioutil.ReadAll(f.Data)
+		if err != nil {
+			return Message{}, err
+		}
+		message.Attachments = append(message.Attachments, Attachment{
+			ContentType: f.ContentType,
+			ContentID:   f.CID,
+			Data:        data,
+		})
+	}
+
+	return message, nil
+}
 
-	return *message
+// decodeTransferEncoding decodes a quoted-printable or base64 body, returning
+// it unchanged for other encodings or when it doesn't decode.
+func decodeTransferEncoding(encoding string, body string) string {
+	var r io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(strings.NewReader(body))
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, strings.NewReader(body))
+	default:
+		return body
+	}
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		return body
+	}
+	return string(decoded)
 }
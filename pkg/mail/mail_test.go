@@ -1,15 +1,27 @@
 package mail
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+	"github.com/cstdev/lambdahelpers/pkg/metrics"
+	"github.com/cstdev/lambdahelpers/pkg/retry"
+	"github.com/cstdev/lambdahelpers/pkg/tracing"
 	log "github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestMain(m *testing.M) {
@@ -40,13 +52,44 @@ func equals(tb testing.TB, expected, actual interface{}) {
 
 type mockedSESAPI struct {
 	sesiface.SESAPI
-	SendEmailFunc func(*ses.SendEmailInput) (*ses.SendEmailOutput, error)
+	SendEmailFunc    func(*ses.SendEmailInput) (*ses.SendEmailOutput, error)
+	SendRawEmailFunc func(*ses.SendRawEmailInput) (*ses.SendRawEmailOutput, error)
+
+	CreateTemplateFunc         func(*ses.CreateTemplateInput) (*ses.CreateTemplateOutput, error)
+	GetTemplateFunc            func(*ses.GetTemplateInput) (*ses.GetTemplateOutput, error)
+	ListTemplatesFunc          func(*ses.ListTemplatesInput) (*ses.ListTemplatesOutput, error)
+	SendTemplatedEmailFunc     func(*ses.SendTemplatedEmailInput) (*ses.SendTemplatedEmailOutput, error)
+	SendBulkTemplatedEmailFunc func(*ses.SendBulkTemplatedEmailInput) (*ses.SendBulkTemplatedEmailOutput, error)
 }
 
-func (m *mockedSESAPI) SendEmail(i *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+func (m *mockedSESAPI) SendEmailWithContext(_ aws.Context, i *ses.SendEmailInput, _ ...request.Option) (*ses.SendEmailOutput, error) {
 	return m.SendEmailFunc(i)
 }
 
+func (m *mockedSESAPI) SendRawEmailWithContext(_ aws.Context, i *ses.SendRawEmailInput, _ ...request.Option) (*ses.SendRawEmailOutput, error) {
+	return m.SendRawEmailFunc(i)
+}
+
+func (m *mockedSESAPI) CreateTemplateWithContext(_ aws.Context, i *ses.CreateTemplateInput, _ ...request.Option) (*ses.CreateTemplateOutput, error) {
+	return m.CreateTemplateFunc(i)
+}
+
+func (m *mockedSESAPI) GetTemplateWithContext(_ aws.Context, i *ses.GetTemplateInput, _ ...request.Option) (*ses.GetTemplateOutput, error) {
+	return m.GetTemplateFunc(i)
+}
+
+func (m *mockedSESAPI) ListTemplatesWithContext(_ aws.Context, i *ses.ListTemplatesInput, _ ...request.Option) (*ses.ListTemplatesOutput, error) {
+	return m.ListTemplatesFunc(i)
+}
+
+func (m *mockedSESAPI) SendTemplatedEmailWithContext(_ aws.Context, i *ses.SendTemplatedEmailInput, _ ...request.Option) (*ses.SendTemplatedEmailOutput, error) {
+	return m.SendTemplatedEmailFunc(i)
+}
+
+func (m *mockedSESAPI) SendBulkTemplatedEmailWithContext(_ aws.Context, i *ses.SendBulkTemplatedEmailInput, _ ...request.Option) (*ses.SendBulkTemplatedEmailOutput, error) {
+	return m.SendBulkTemplatedEmailFunc(i)
+}
+
 func TestSendEmailSendsTheEmailDetailsToTheService(t *testing.T) {
 	var to string
 	var from string
@@ -73,3 +116,231 @@ func TestSendEmailSendsTheEmailDetailsToTheService(t *testing.T) {
 	equals(t, expectedFrom, from)
 	equals(t, expectedBody, body)
 }
+
+func TestSendEmailReturnsErrMissingRecipient(t *testing.T) {
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendEmailFunc: func(i *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+				t.Error("Expected SendEmail not to be called")
+				return &ses.SendEmailOutput{}, nil
+			},
+		},
+	}
+
+	err := m.SendMail("", "sender@test.com", "body")
+	if !errors.Is(err, ErrMissingRecipient) {
+		t.Errorf("Expected ErrMissingRecipient, received: %v", err)
+	}
+}
+
+func TestSendSendsEveryFieldAndReturnsMessageID(t *testing.T) {
+	var input *ses.SendEmailInput
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendEmailFunc: func(i *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+				input = i
+				return &ses.SendEmailOutput{MessageId: aws.String("msg-1")}, nil
+			},
+		},
+	}
+
+	id, err := m.Send(Email{
+		From:       "Blog <blog@example.com>",
+		To:         []string{"jane@example.com", ""},
+		CC:         []string{"john@example.com"},
+		BCC:        []string{"archive@example.com"},
+		ReplyTo:    []string{"editor@example.com"},
+		Subject:    "Published",
+		TextBody:   "Your post is live",
+		HTMLBody:   "<p>Your post is live</p>",
+		Charset:    "us-ascii",
+		ReturnPath: "bounces@example.com",
+	})
+	ok(t, err)
+
+	equals(t, "msg-1", id)
+	equals(t, []string{"jane@example.com"}, aws.StringValueSlice(input.Destination.ToAddresses))
+	equals(t, []string{"john@example.com"}, aws.StringValueSlice(input.Destination.CcAddresses))
+	equals(t, []string{"archive@example.com"}, aws.StringValueSlice(input.Destination.BccAddresses))
+	equals(t, []string{"editor@example.com"}, aws.StringValueSlice(input.ReplyToAddresses))
+	equals(t, "Blog <blog@example.com>", *input.Source)
+	equals(t, "bounces@example.com", *input.ReturnPath)
+	equals(t, "Published", *input.Message.Subject.Data)
+	equals(t, "Your post is live", *input.Message.Body.Text.Data)
+	equals(t, "<p>Your post is live</p>", *input.Message.Body.Html.Data)
+	equals(t, "US-ASCII", *input.Message.Body.Html.Charset)
+	equals(t, "US-ASCII", *input.Message.Subject.Charset)
+}
+
+func TestSendDefaultsCharsetAndSendsHTMLOnly(t *testing.T) {
+	var input *ses.SendEmailInput
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendEmailFunc: func(i *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+				input = i
+				return &ses.SendEmailOutput{}, nil
+			},
+		},
+	}
+
+	_, err := m.Send(Email{From: "blog@example.com", BCC: []string{"jane@example.com"}, HTMLBody: "<p>Hi</p>"})
+	ok(t, err)
+
+	equals(t, "UTF-8", *input.Message.Body.Html.Charset)
+	equals(t, (*ses.Content)(nil), input.Message.Body.Text)
+	equals(t, (*string)(nil), input.ReturnPath)
+}
+
+func TestSendReturnsErrMissingRecipientAndSender(t *testing.T) {
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendEmailFunc: func(i *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+				t.Error("Expected SendEmail not to be called")
+				return &ses.SendEmailOutput{}, nil
+			},
+		},
+	}
+
+	_, err := m.Send(Email{From: "blog@example.com", To: []string{" "}})
+	if !errors.Is(err, ErrMissingRecipient) {
+		t.Errorf("Expected ErrMissingRecipient, received: %v", err)
+	}
+	_, err = m.Send(Email{CC: []string{"jane@example.com"}})
+	if !errors.Is(err, ErrMissingSender) {
+		t.Errorf("Expected ErrMissingSender, received: %v", err)
+	}
+}
+
+type noSleepClock struct{}
+
+func (noSleepClock) Now() time.Time        { return time.Time{} }
+func (noSleepClock) Sleep(d time.Duration) {}
+
+func TestSendEmailRetriesThrottlingWithPolicy(t *testing.T) {
+	calls := 0
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendEmailFunc: func(i *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+				calls++
+				if calls < 3 {
+					return nil, awserr.New("Throttling", "Maximum sending rate exceeded", nil)
+				}
+				return &ses.SendEmailOutput{}, nil
+			},
+		},
+		Retry: &retry.Policy{MaxAttempts: 3, Clock: noSleepClock{}},
+	}
+
+	err := m.SendMail("recipient@test.com", "sender@test.com", "body")
+	ok(t, err)
+	equals(t, 3, calls)
+}
+
+func TestSendEmailRecordsSentAndFailedMetrics(t *testing.T) {
+	fail := false
+	sink := &metrics.Memory{}
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendEmailFunc: func(i *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+				if fail {
+					return nil, awserr.New("MessageRejected", "Email address is not verified", nil)
+				}
+				return &ses.SendEmailOutput{}, nil
+			},
+		},
+		Metrics: sink,
+	}
+
+	ok(t, m.SendMail("recipient@test.com", "sender@test.com", "body"))
+	fail = true
+	m.SendMail("recipient@test.com", "sender@test.com", "body")
+
+	equals(t, float64(1), sink.Sum(metrics.EmailsSent))
+	equals(t, float64(1), sink.Sum(metrics.EmailsFailed))
+	equals(t, 2, sink.Count(metrics.Latency))
+}
+
+func TestSendEmailRecordsSpanWithMessageID(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+	m := &SESMail{
+		Client: &mockedSESAPI{
+			SendEmailFunc: func(i *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+				return &ses.SendEmailOutput{MessageId: aws.String("msg-1")}, nil
+			},
+		},
+		Tracer: tracer,
+	}
+
+	ctx, handler := tracer.Start(context.Background(), "handler")
+	ok(t, m.WithContext(ctx).SendMail("recipient@test.com", "sender@test.com", "body"))
+	handler.End()
+
+	span := exporter.GetSpans()[0]
+	equals(t, "ses:SendEmail", span.Name)
+	equals(t, handler.SpanContext().SpanID(), span.Parent.SpanID())
+	equals(t, tracing.MessageID.String("msg-1"), span.Attributes[0])
+}
+
+func TestParseBodyReadsSenderAndDate(t *testing.T) {
+	email := "From: Jane Doe <jane@example.com>\r\n" +
+		"Subject: Hello\r\n" +
+		"Date: Wed, 02 Jan 2019 15:04:05 +0000\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Body text"
+
+	m := ParseBody(email)
+
+	equals(t, "Hello", m.Subject)
+	equals(t, "Body text", m.Body)
+	equals(t, "Jane Doe", m.From.Name)
+	equals(t, "jane@example.com", m.From.Address)
+	equals(t, true, m.Date.Equal(time.Date(2019, 1, 2, 15, 4, 5, 0, time.UTC)))
+}
+
+func TestParseBodyDoesntLogThroughDefaultLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ParseBody("Subject: Hello\r\n\r\nBody text")
+
+	equals(t, "", buf.String())
+}
+
+func TestParseBodyKeepsAttachmentsAndInlineFiles(t *testing.T) {
+	email := "From: jane@example.com\r\n" +
+		"Subject: Photos\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: multipart/related; boundary=inner\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<img src=\"cid:photo1\">\r\n" +
+		"--inner\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Id: <photo1>\r\n" +
+		"\r\n" +
+		"aW5saW5l\r\n" +
+		"--inner--\r\n" +
+		"--outer\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"trip.pdf\"\r\n" +
+		"\r\n" +
+		"YXR0YWNoZWQ=\r\n" +
+		"--outer--\r\n"
+
+	m := ParseBody(email)
+
+	equals(t, "<img src=\"cid:photo1\">", m.HTMLBody)
+	equals(t, []Attachment{
+		{Filename: "trip.pdf", ContentType: "application/pdf", Data: []byte("attached")},
+		{ContentType: "image/png", ContentID: "photo1", Data: []byte("inline")},
+	}, m.Attachments)
+}
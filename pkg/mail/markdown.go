@@ -0,0 +1,324 @@
+package mail
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Markdown returns the HTML body converted to Markdown, or the text body
+// when there is no HTML or nothing is left of it once cleaned up.
+func (m Message) Markdown() string {
+	if strings.TrimSpace(m.HTMLBody) == "" {
+		return m.Body
+	}
+	md, err := HTMLToMarkdown(m.HTMLBody)
+	if err != nil || md == "" {
+		return m.Body
+	}
+	return md
+}
+
+// HTMLToMarkdown converts an HTML email body to Markdown. Scripts, styles,
+// hidden elements, Office markup and tracking pixels are dropped.
+func HTMLToMarkdown(s string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return "", err
+	}
+
+	c := &markdownConverter{}
+	md := c.render(doc)
+
+	md = spaceAfterBreak.ReplaceAllString(md, "\\\n")
+	md = trailingSpace.ReplaceAllString(md, "\n")
+	md = blankLines.ReplaceAllString(md, "\n\n")
+	md = lineStart.ReplaceAllString(md, "$1\\")
+	md = strings.Replace(md, lineStartMark, "", -1)
+	md = strings.TrimSpace(md)
+	for i, block := range c.preformatted {
+		md = strings.Replace(md, placeholder(i), block, 1)
+	}
+	return md, nil
+}
+
+var (
+	whitespace      = regexp.MustCompile(`\s+`)
+	spaceAfterBreak = regexp.MustCompile(`\\\n[ \t]+`)
+	trailingSpace   = regexp.MustCompile(`[ \t]+\n`)
+	blankLines      = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+`)
+	newlines        = regexp.MustCompile(`\n+`)
+	markdownSpecial = strings.NewReplacer(
+		`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", `\<`,
+	)
+	// blockMarker matches text starting with something that makes a heading,
+	// list or quote when it begins a line.
+	blockMarker = regexp.MustCompile(`^(\s*)([#>+-]|\d+\.)`)
+	// lineStart matches lineStartMark at the start of a line, after any quote
+	// or list markers.
+	lineStart = regexp.MustCompile(`(?m)^([ \t>]*(?:[-+*] |\d+\. )?\d*)` + lineStartMark)
+)
+
+// lineStartMark is written by markLineStart. It is replaced by a backslash
+// where the text ends up starting a line, and dropped elsewhere. HTML text
+// can't hold NUL, so it can't be forged.
+const lineStartMark = "\x00esc\x00"
+
+// markLineStart writes lineStartMark ahead of the punctuation of a
+// blockMarker, after the number of an ordered list marker as "1\." is
+// the escape for it.
+func markLineStart(text string) string {
+	loc := blockMarker.FindStringSubmatchIndex(text)
+	if loc == nil {
+		return text
+	}
+	i := loc[5] - 1
+	return text[:i] + lineStartMark + text[i:]
+}
+
+// markdownConverter keeps preformatted blocks aside so whitespace clean up
+// doesn't touch them.
+type markdownConverter struct {
+	preformatted []string
+}
+
+func placeholder(i int) string {
+	return fmt.Sprintf("\x00pre%d\x00", i)
+}
+
+func (c *markdownConverter) children(n *html.Node) string {
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(c.render(child))
+	}
+	return b.String()
+}
+
+func (c *markdownConverter) render(n *html.Node) string {
+	switch n.Type {
+	case html.DocumentNode:
+		return c.children(n)
+	case html.TextNode:
+		text := markdownSpecial.Replace(whitespace.ReplaceAllString(n.Data, " "))
+		return markLineStart(text)
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	if skipped(n) {
+		return ""
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		text := newlines.ReplaceAllString(strings.TrimSpace(c.children(n)), " ")
+		if text == "" {
+			return ""
+		}
+		return "\n\n" + strings.Repeat("#", level) + " " + text + "\n\n"
+	case atom.Br:
+		return "\\\n"
+	case atom.Hr:
+		return "\n\n---\n\n"
+	case atom.Strong, atom.B:
+		return wrap("**", c.children(n))
+	case atom.Em, atom.I:
+		return wrap("*", c.children(n))
+	case atom.Del, atom.S, atom.Strike:
+		return wrap("~~", c.children(n))
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		code := textContent(n)
+		fence := "`"
+		if strings.Contains(code, "`") {
+			fence = "``"
+		}
+		return fence + code + fence
+	case atom.Pre:
+		c.preformatted = append(c.preformatted, "```\n"+strings.Trim(textContent(n), "\n")+"\n```")
+		return "\n\n" + placeholder(len(c.preformatted)-1) + "\n\n"
+	case atom.A:
+		return c.link(n)
+	case atom.Img:
+		return image(n)
+	case atom.Blockquote:
+		text := blankLines.ReplaceAllString(strings.TrimSpace(c.children(n)), "\n\n")
+		return "\n\n" + prefixLines(text, "> ", ">") + "\n\n"
+	case atom.Ul, atom.Ol:
+		return "\n\n" + c.list(n) + "\n\n"
+	case atom.Td, atom.Th:
+		return c.children(n) + " "
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer,
+		atom.Main, atom.Aside, atom.Nav, atom.Table, atom.Tr, atom.Center,
+		atom.Address, atom.Figure, atom.Figcaption, atom.Dl, atom.Dt, atom.Dd, atom.Li:
+		return "\n\n" + strings.TrimSpace(c.children(n)) + "\n\n"
+	}
+	return c.children(n)
+}
+
+// list renders the items of a list, indenting anything after the first line
+// of an item so nested lists stay inside it.
+func (c *markdownConverter) list(n *html.Node) string {
+	var items []string
+	number := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		number = start
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode || child.DataAtom != atom.Li || skipped(child) {
+			continue
+		}
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		text := blankLines.ReplaceAllString(strings.TrimSpace(c.children(child)), "\n")
+		indent := strings.Repeat(" ", len(marker))
+		items = append(items, marker+strings.TrimPrefix(prefixLines(text, indent, ""), indent))
+	}
+	return strings.Join(items, "\n")
+}
+
+func (c *markdownConverter) link(n *html.Node) string {
+	text := strings.TrimSpace(c.children(n))
+	href, ok := safeURL(attr(n, "href"), linkSchemes)
+	if !ok {
+		return text
+	}
+	if text == "" {
+		return ""
+	}
+	return "[" + newlines.ReplaceAllString(text, " ") + "](" + escapeURL(href) + ")"
+}
+
+func image(n *html.Node) string {
+	src, ok := safeURL(attr(n, "src"), imageSchemes)
+	if !ok || trackingPixel(n) {
+		return ""
+	}
+	alt := markdownSpecial.Replace(whitespace.ReplaceAllString(attr(n, "alt"), " "))
+	return "![" + alt + "](" + escapeURL(src) + ")"
+}
+
+// skipped reports whether an element and everything in it should be left out.
+func skipped(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Title, atom.Meta, atom.Link,
+		atom.Noscript, atom.Template, atom.Iframe, atom.Object, atom.Svg, atom.Button:
+		return true
+	}
+	// Office namespaced markup such as <o:p> and <v:shape>.
+	if strings.Contains(n.Data, ":") {
+		return true
+	}
+	if _, ok := attrValue(n, "hidden"); ok {
+		return true
+	}
+	style := strings.ToLower(strings.Replace(attr(n, "style"), " ", "", -1))
+	return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+// trackingPixel reports whether an image is too small to be anything but a read receipt.
+func trackingPixel(n *html.Node) bool {
+	for _, dimension := range []string{"width", "height"} {
+		if size, err := strconv.Atoi(strings.TrimSuffix(attr(n, dimension), "px")); err == nil && size <= 1 {
+			return true
+		}
+	}
+	style := strings.ToLower(strings.Replace(attr(n, "style"), " ", "", -1))
+	for _, tiny := range []string{"width:0", "width:1px", "height:0", "height:1px"} {
+		if strings.Contains(style, tiny) {
+			return true
+		}
+	}
+	return false
+}
+
+func wrap(marker string, s string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	// Keep the surrounding spaces outside the markers so the emphasis still applies.
+	leading := s[:strings.Index(s, trimmed)]
+	trailing := s[len(leading)+len(trimmed):]
+	return leading + marker + trimmed + marker + trailing
+}
+
+func prefixLines(s string, prefix string, emptyPrefix string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = emptyPrefix
+			continue
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if n.Type == html.ElementNode && n.DataAtom == atom.Br {
+		return "\n"
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(textContent(child))
+	}
+	return b.String()
+}
+
+// linkSchemes are the schemes links may use, anything else such as
+// javascript: or data: is dropped.
+var linkSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// imageSchemes are the schemes images may use, the linkSchemes and cid: for
+// inline attachments, which post.RewriteContentIDs points at the uploaded files.
+var imageSchemes = map[string]bool{"http": true, "https": true, "mailto": true, "cid": true}
+
+// safeURL returns u without surrounding space and control characters, which
+// browsers ignore, and reports whether it is a relative URL or uses one of schemes.
+func safeURL(u string, schemes map[string]bool) (string, bool) {
+	u = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(u))
+	if u == "" {
+		return "", false
+	}
+	i := strings.IndexAny(u, ":/?#")
+	if i < 0 || u[i] != ':' {
+		return u, true
+	}
+	return u, schemes[strings.ToLower(u[:i])]
+}
+
+func escapeURL(u string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(u)
+}
+
+func attr(n *html.Node, key string) string {
+	value, _ := attrValue(n, key)
+	return value
+}
+
+func attrValue(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,81 @@
+package mail
+
+import "testing"
+
+func TestHTMLToMarkdownConvertsFormatting(t *testing.T) {
+	html := `<html><head><style>p { color: red; }</style><title>Newsletter</title></head><body>
+<h1>My  Trip</h1>
+<p>It was <strong>great</strong>, <em>really</em> <a href="https://example.com/a b">see here</a>.<br>
+Next line with <code>go test</code> and a_b.</p>
+<ul>
+  <li>One</li>
+  <li>Two
+    <ol start="3"><li>Nested</li></ol>
+  </li>
+</ul>
+<blockquote><p>Quoted</p><p>Twice</p></blockquote>
+<pre><code>func main() {
+
+	fmt.Println("hi")
+}</code></pre>
+<p><img src="https://example.com/photo.jpg" alt="A photo"></p>
+<p># Not a heading</p>
+<p>- not a list<br>+ nor this<br>&gt; nor a quote<br>12. nor a number</p>
+<p><b>Dash</b> - mid line</p>
+</body></html>`
+
+	md, err := HTMLToMarkdown(html)
+	ok(t, err)
+
+	equals(t, "# My Trip\n\n"+
+		"It was **great**, *really* [see here](https://example.com/a%20b).\\\n"+
+		"Next line with `go test` and a\\_b.\n\n"+
+		"- One\n"+
+		"- Two\n"+
+		"  3. Nested\n\n"+
+		"> Quoted\n>\n> Twice\n\n"+
+		"```\nfunc main() {\n\n\tfmt.Println(\"hi\")\n}\n```\n\n"+
+		"![A photo](https://example.com/photo.jpg)\n\n"+
+		"\\# Not a heading\n\n"+
+		"\\- not a list\\\n\\+ nor this\\\n\\> nor a quote\\\n12\\. nor a number\n\n"+
+		"**Dash** - mid line", md)
+}
+
+func TestHTMLToMarkdownStripsTrackingAndClientCruft(t *testing.T) {
+	html := `<div>Hello<o:p></o:p></div>
+<!--[if mso]><p>Outlook only</p><![endif]-->
+<div style="display: none">Preview text</div>
+<span hidden>Hidden</span>
+<img src="https://track.example.com/open.gif" width="1" height="1">
+<img src="https://track.example.com/pixel.png" style="width:1px;height:1px">
+<script>alert(1)</script>`
+
+	md, err := HTMLToMarkdown(html)
+	ok(t, err)
+
+	equals(t, "Hello", md)
+}
+
+func TestMarkdownFallsBackToTextBody(t *testing.T) {
+	equals(t, "Plain", Message{Body: "Plain"}.Markdown())
+	equals(t, "Plain", Message{Body: "Plain", HTMLBody: `<img src="x" width="1">`}.Markdown())
+	equals(t, "**Rich**", Message{Body: "Plain", HTMLBody: "<b>Rich</b>"}.Markdown())
+}
+
+func TestHTMLToMarkdownOnlyKeepsSafeLinks(t *testing.T) {
+	html := `<p><a href="javascript:alert(1)">a</a>
+<a href=" java	script:alert(1)">b</a>
+<a href="&#x01;javascript:alert(1)">c</a>
+<a href="JAVASCRIPT:alert(1)">d</a>
+<a href="data:text/html,<script>alert(1)</script>">e</a>
+<a href="vbscript:msgbox(1)">f</a>
+<a href="HTTPS://example.com">g</a>
+<a href="mailto:me@example.com">h</a>
+<a href="/posts/trip?page=2#photos">i</a></p>
+<p><img src="javascript:alert(1)" alt="j"><img src="cid:photo@example.com" alt="k"></p>`
+
+	md, err := HTMLToMarkdown(html)
+	ok(t, err)
+
+	equals(t, "a b c d e f [g](HTTPS://example.com) [h](mailto:me@example.com) [i](/posts/trip?page=2#photos)\n\n![k](cid:photo@example.com)", md)
+}
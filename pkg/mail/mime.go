@@ -0,0 +1,377 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	"github.com/cstdev/lambdahelpers/pkg/metrics"
+	"github.com/cstdev/lambdahelpers/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// base64LineLength keeps encoded attachments within the 78 character line limit.
+const base64LineLength = 76
+
+var (
+	// ErrInvalidAddress is returned by Raw and SendRaw for an address that doesn't parse.
+	ErrInvalidAddress = errors.New("Invalid address")
+	// ErrInvalidHeader is returned by Raw for a header name or value that would
+	// break out of its line.
+	ErrInvalidHeader = errors.New("Invalid header")
+)
+
+// reservedHeaders are written by Raw itself, so Email.Header can't set them.
+var reservedHeaders = map[string]bool{
+	"From": true, "To": true, "Cc": true, "Bcc": true, "Reply-To": true,
+	"Subject": true, "Date": true, "Return-Path": true, "Mime-Version": true,
+	"Content-Type": true, "Content-Transfer-Encoding": true,
+}
+
+// SendRaw sends the email as a MIME message through SendRawEmail, which
+// unlike SendEmail carries attachments and custom headers. Send calls it
+// for emails that have either.
+func (m *SESMail) SendRaw(e Email) (messageID string, err error) {
+	m.log().WithFields(logging.Fields{
+		"sender":      e.From,
+		"recipient":   strings.Join(e.To, ", "),
+		"recipients":  e.recipients(),
+		"attachments": len(e.Attachments),
+	}).Debug("Sending raw email")
+
+	if e.recipients() == 0 {
+		return "", ErrMissingRecipient
+	}
+	if e.From == "" {
+		return "", ErrMissingSender
+	}
+
+	raw, err := e.Raw()
+	if err != nil {
+		return "", err
+	}
+
+	var destinations []*string
+	for _, list := range [][]string{e.To, e.CC, e.BCC} {
+		for _, address := range addresses(list) {
+			parsed, err := parseAddress(*address)
+			if err != nil {
+				return "", err
+			}
+			destinations = append(destinations, aws.String(parsed.Address))
+		}
+	}
+	input := &ses.SendRawEmailInput{
+		Destinations: destinations,
+		RawMessage:   &ses.RawMessage{Data: raw},
+	}
+	// Source takes precedence over the Return-Path header, so it is only set
+	// when bounces should go to the From address.
+	if e.ReturnPath == "" {
+		input.Source = aws.String(e.From)
+	}
+
+	err = m.callWithSpan("ses:SendRawEmail", func(ctx context.Context, span trace.Span) error {
+		resp, err := m.Client.SendRawEmailWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		messageID = aws.StringValue(resp.MessageId)
+		span.SetAttributes(tracing.MessageID.String(messageID))
+		return nil
+	})
+	if err != nil {
+		metrics.Or(m.Metrics).Record(metrics.EmailsFailed, 1, metrics.Count, nil)
+		m.log().Error("Failed to send raw email")
+		return "", err
+	}
+	metrics.Or(m.Metrics).Record(metrics.EmailsSent, 1, metrics.Count, nil)
+
+	return messageID, nil
+}
+
+// Raw returns the email as a MIME message. Text and HTML bodies become a
+// multipart/alternative, inline attachments are related to the HTML body
+// and everything else is attached in a multipart/mixed. Text is converted
+// into Charset, then bodies are quoted-printable, attachments base64 and
+// non-ASCII headers RFC 2047 encoded. BCC recipients are left out of the headers.
+//
+// Addresses that don't parse return ErrInvalidAddress, and header names or
+// values holding line breaks return ErrInvalidHeader, so nothing supplied
+// can add headers of its own.
+func (e Email) Raw() ([]byte, error) {
+	charset, err := lookupCharset(e.Charset)
+	if err != nil {
+		return nil, err
+	}
+	body, err := e.body(charset)
+	if err != nil {
+		return nil, err
+	}
+	subject, err := charset.header(e.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, field := range []struct {
+		name string
+		list []string
+	}{
+		{"From", []string{e.From}},
+		{"To", e.To},
+		{"Cc", e.CC},
+		{"Reply-To", e.ReplyTo},
+	} {
+		formatted, err := formatAddresses(field.list)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.name, err)
+		}
+		writeHeader(&buf, field.name, formatted)
+	}
+	writeHeader(&buf, "Subject", subject)
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	if e.ReturnPath != "" {
+		returnPath, err := parseAddress(e.ReturnPath)
+		if err != nil {
+			return nil, fmt.Errorf("Return-Path: %w", err)
+		}
+		writeHeader(&buf, "Return-Path", returnPath.Address)
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	var names []string
+	for name := range e.Header {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("%w: name %q", ErrInvalidHeader, name)
+		}
+		if !reservedHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range e.Header[name] {
+			if strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf("%w: value of %s holds a line break", ErrInvalidHeader, name)
+			}
+			encoded, err := charset.header(value)
+			if err != nil {
+				return nil, err
+			}
+			writeHeader(&buf, textproto.CanonicalMIMEHeaderKey(name), encoded)
+		}
+	}
+
+	writeHeader(&buf, "Content-Type", body.header.Get("Content-Type"))
+	writeHeader(&buf, "Content-Transfer-Encoding", body.header.Get("Content-Transfer-Encoding"))
+	buf.WriteString("\r\n")
+	buf.Write(body.body)
+	return buf.Bytes(), nil
+}
+
+// mimePart is an entity ready to be written on its own or as part of a multipart.
+type mimePart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func (e Email) body(charset charset) (mimePart, error) {
+	var inline, attached []Attachment
+	for _, a := range e.Attachments {
+		if a.Inline() && e.HTMLBody != "" {
+			inline = append(inline, a)
+		} else {
+			attached = append(attached, a)
+		}
+	}
+
+	var alternatives []mimePart
+	if e.TextBody != "" || e.HTMLBody == "" {
+		text, err := textPart("text/plain", charset, e.TextBody)
+		if err != nil {
+			return mimePart{}, err
+		}
+		alternatives = append(alternatives, text)
+	}
+	if e.HTMLBody != "" {
+		html, err := textPart("text/html", charset, e.HTMLBody)
+		if err != nil {
+			return mimePart{}, err
+		}
+		if len(inline) > 0 {
+			related := []mimePart{html}
+			for _, a := range inline {
+				related = append(related, attachmentPart(a, "inline"))
+			}
+			if html, err = multipartOf("related", related); err != nil {
+				return mimePart{}, err
+			}
+		}
+		alternatives = append(alternatives, html)
+	}
+
+	content := alternatives[0]
+	// A lone body is still wrapped when there are attachments, as parsers
+	// such as the one Parse uses expect multipart/mixed to hold a multipart.
+	if len(alternatives) > 1 || (len(attached) > 0 && !strings.HasPrefix(content.header.Get("Content-Type"), "multipart/")) {
+		var err error
+		if content, err = multipartOf("alternative", alternatives); err != nil {
+			return mimePart{}, err
+		}
+	}
+	if len(attached) == 0 {
+		return content, nil
+	}
+
+	mixed := []mimePart{content}
+	for _, a := range attached {
+		mixed = append(mixed, attachmentPart(a, "attachment"))
+	}
+	return multipartOf("mixed", mixed)
+}
+
+// textPart converts text into charset and quoted-printable encodes it.
+func textPart(contentType string, charset charset, text string) (mimePart, error) {
+	encoded, err := charset.encode(text)
+	if err != nil {
+		return mimePart{}, err
+	}
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	w.Write([]byte(encoded))
+	w.Close()
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"charset": charset.name}))
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return mimePart{header: header, body: buf.Bytes()}, nil
+}
+
+func attachmentPart(a Attachment, disposition string) mimePart {
+	filename := a.Filename
+	if filename == "" && disposition == "attachment" {
+		filename = "attachment"
+	}
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	if filename != "" {
+		header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	} else {
+		header.Set("Content-Disposition", disposition)
+	}
+	if a.ContentID != "" {
+		header.Set("Content-ID", "<"+a.ContentID+">")
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(a.Data)
+	var buf bytes.Buffer
+	for len(encoded) > base64LineLength {
+		buf.WriteString(encoded[:base64LineLength] + "\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	buf.WriteString(encoded)
+	return mimePart{header: header, body: buf.Bytes()}
+}
+
+func multipartOf(subtype string, parts []mimePart) (mimePart, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, part := range parts {
+		pw, err := w.CreatePart(part.header)
+		if err != nil {
+			return mimePart{}, err
+		}
+		if _, err := pw.Write(part.body); err != nil {
+			return mimePart{}, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return mimePart{}, err
+	}
+
+	header := textproto.MIMEHeader{}
+	// Boundaries are long, so the parameter is folded onto its own line.
+	header.Set("Content-Type", "multipart/"+subtype+";\r\n boundary="+w.Boundary())
+	return mimePart{header: header, body: buf.Bytes()}, nil
+}
+
+// writeHeader writes a header field, skipping empty values.
+func writeHeader(buf *bytes.Buffer, name string, value string) {
+	if value == "" {
+		return
+	}
+	buf.WriteString(name + ": " + value + "\r\n")
+}
+
+// header converts value into the charset and RFC 2047 encodes it if it isn't
+// plain ASCII, folding between encoded words so lines stay short.
+func (c charset) header(value string) (string, error) {
+	encoded, err := c.encode(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(mime.QEncoding.Encode(c.name, encoded), "?= =?", "?=\r\n =?", -1), nil
+}
+
+// formatAddresses writes a folded address list with display names encoded.
+// Blank entries are skipped, anything else must parse as an address.
+func formatAddresses(list []string) (string, error) {
+	var formatted []string
+	for _, address := range list {
+		if strings.TrimSpace(address) == "" {
+			continue
+		}
+		parsed, err := parseAddress(address)
+		if err != nil {
+			return "", err
+		}
+		formatted = append(formatted, parsed.String())
+	}
+	return strings.Join(formatted, ",\r\n "), nil
+}
+
+func parseAddress(address string) (*netmail.Address, error) {
+	parsed, err := netmail.ParseAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrInvalidAddress, address, err)
+	}
+	return parsed, nil
+}
+
+// validHeaderName reports whether name is a header field name: printable
+// ASCII without spaces or colons.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' || name[i] == ':' {
+			return false
+		}
+	}
+	return true
+}
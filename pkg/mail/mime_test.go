@@ -0,0 +1,230 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	netmail "net/mail"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+)
+
+func TestRawRoundTripsThroughParse(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n" + strings.Repeat("\x00\xff", 100))
+	e := Email{
+		From:     "Zoë Blog <blog@example.com>",
+		To:       []string{"jane@example.com", "John <john@example.com>"},
+		BCC:      []string{"archive@example.com"},
+		Subject:  "Résumé of this week's posts",
+		TextBody: "Résumé attached.\nA line long enough to be wrapped by quoted-printable encoding, which keeps lines under 76 characters.",
+		HTMLBody: `<p>Résumé attached.</p><img src="cid:logo">`,
+		Attachments: []Attachment{
+			{Filename: "report.csv", ContentType: "text/csv", Data: []byte("slug,views\nhello,3\n")},
+			{ContentType: "image/png", ContentID: "logo", Data: png},
+		},
+		Header: netmail.Header{"List-Unsubscribe": {"<mailto:unsubscribe@example.com>"}},
+	}
+
+	raw, err := e.Raw()
+	ok(t, err)
+	for _, line := range strings.Split(string(raw), "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("Expected lines within 78 characters, received: %q", line)
+		}
+	}
+	if bytes.Contains(raw, []byte("archive@example.com")) {
+		t.Error("Expected BCC recipients to be left out of the headers")
+	}
+
+	m, err := Parse(bytes.NewReader(raw))
+	ok(t, err)
+
+	equals(t, e.Subject, m.Subject)
+	equals(t, "Zoë Blog", m.From.Name)
+	equals(t, "blog@example.com", m.From.Address)
+	// Quoted-printable writes line breaks as CRLF.
+	equals(t, e.TextBody, strings.Replace(m.Body, "\r\n", "\n", -1))
+	equals(t, e.HTMLBody, m.HTMLBody)
+	equals(t, "<mailto:unsubscribe@example.com>", m.Header.Get("List-Unsubscribe"))
+	equals(t, 2, len(m.Attachments))
+	equals(t, e.Attachments[0], m.Attachments[0])
+	equals(t, e.Attachments[1], m.Attachments[1])
+}
+
+func TestRawWritesSinglePartTextThroughParseBody(t *testing.T) {
+	e := Email{
+		From:     "blog@example.com",
+		To:       []string{"jane@example.com"},
+		Subject:  "Plain",
+		TextBody: "Crème brûlée = dessert",
+	}
+
+	raw, err := e.Raw()
+	ok(t, err)
+
+	if !bytes.Contains(raw, []byte("Content-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: quoted-printable")) {
+		t.Errorf("Expected a single quoted-printable part, received:\n%s", raw)
+	}
+	equals(t, e.TextBody, ParseBody(string(raw)).Body)
+}
+
+func TestSendUsesSendRawEmailForAttachments(t *testing.T) {
+	var input *ses.SendRawEmailInput
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendEmailFunc: func(i *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+				t.Error("Expected SendEmail not to be called")
+				return &ses.SendEmailOutput{}, nil
+			},
+			SendRawEmailFunc: func(i *ses.SendRawEmailInput) (*ses.SendRawEmailOutput, error) {
+				input = i
+				return &ses.SendRawEmailOutput{MessageId: aws.String("raw-1")}, nil
+			},
+		},
+	}
+
+	id, err := m.Send(Email{
+		From:        "Blog <blog@example.com>",
+		To:          []string{"Jane <jane@example.com>"},
+		BCC:         []string{"archive@example.com"},
+		TextBody:    "See attached",
+		Attachments: []Attachment{{Filename: "report.pdf", Data: []byte("%PDF-1.4")}},
+	})
+	ok(t, err)
+
+	equals(t, "raw-1", id)
+	equals(t, []string{"jane@example.com", "archive@example.com"}, aws.StringValueSlice(input.Destinations))
+	equals(t, "Blog <blog@example.com>", *input.Source)
+	parsed := ParseBody(string(input.RawMessage.Data))
+	equals(t, "report.pdf", parsed.Attachments[0].Filename)
+	equals(t, "application/pdf", parsed.Attachments[0].ContentType)
+}
+
+func TestSendConvertsTextIntoCharsetWithSendRaw(t *testing.T) {
+	var input *ses.SendRawEmailInput
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendRawEmailFunc: func(i *ses.SendRawEmailInput) (*ses.SendRawEmailOutput, error) {
+				input = i
+				return &ses.SendRawEmailOutput{MessageId: aws.String("raw-1")}, nil
+			},
+		},
+	}
+
+	_, err := m.Send(Email{
+		From:     "blog@example.com",
+		To:       []string{"jane@example.com"},
+		Subject:  "Crème",
+		TextBody: "Crème brûlée",
+		Charset:  "latin1",
+	})
+	ok(t, err)
+
+	raw := string(input.RawMessage.Data)
+	for _, expected := range []string{
+		"Subject: =?ISO-8859-1?q?Cr=E8me?=\r\n",
+		"Content-Type: text/plain; charset=ISO-8859-1\r\n",
+		"\r\n\r\nCr=E8me br=FBl=E9e",
+	} {
+		if !strings.Contains(raw, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, raw)
+		}
+	}
+}
+
+func TestSendRejectsUnsupportedCharsets(t *testing.T) {
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendEmailFunc: func(i *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+				t.Error("Expected SendEmail not to be called")
+				return &ses.SendEmailOutput{}, nil
+			},
+			SendRawEmailFunc: func(i *ses.SendRawEmailInput) (*ses.SendRawEmailOutput, error) {
+				t.Error("Expected SendRawEmail not to be called")
+				return &ses.SendRawEmailOutput{}, nil
+			},
+		},
+	}
+
+	for charset, text := range map[string]string{
+		"klingon":    "Hello",
+		"UTF-16":     "Hello",
+		"US-ASCII":   "Crème",
+		"ISO-8859-1": "Price: €5",
+	} {
+		_, err := m.Send(Email{From: "blog@example.com", To: []string{"jane@example.com"}, TextBody: text, Charset: charset})
+		if !errors.Is(err, ErrUnsupportedCharset) {
+			t.Errorf("Expected ErrUnsupportedCharset for %s, received: %v", charset, err)
+		}
+	}
+}
+
+func TestSendRawLeavesSourceForReturnPath(t *testing.T) {
+	var input *ses.SendRawEmailInput
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendRawEmailFunc: func(i *ses.SendRawEmailInput) (*ses.SendRawEmailOutput, error) {
+				input = i
+				return &ses.SendRawEmailOutput{}, nil
+			},
+		},
+	}
+
+	_, err := m.SendRaw(Email{
+		From:       "blog@example.com",
+		To:         []string{"jane@example.com"},
+		ReturnPath: "bounces@example.com",
+	})
+	ok(t, err)
+
+	equals(t, (*string)(nil), input.Source)
+	if !bytes.Contains(input.RawMessage.Data, []byte("Return-Path: bounces@example.com\r\n")) {
+		t.Errorf("Expected a Return-Path header, received:\n%s", input.RawMessage.Data)
+	}
+}
+
+func TestRawRejectsHeaderInjection(t *testing.T) {
+	for name, e := range map[string]Email{
+		"address":      {From: "blog@example.com", To: []string{"jane@example.com\r\nBcc: victim@example.com"}},
+		"return path":  {From: "blog@example.com", To: []string{"jane@example.com"}, ReturnPath: "bounces@example.com\r\nX-Injected: yes"},
+		"header value": {From: "blog@example.com", To: []string{"jane@example.com"}, Header: netmail.Header{"X-Campaign": {"spring\r\nX-Injected: yes"}}},
+		"header name":  {From: "blog@example.com", To: []string{"jane@example.com"}, Header: netmail.Header{"X-Injected: yes\r\nX-Campaign": {"spring"}}},
+	} {
+		raw, err := e.Raw()
+		if err == nil {
+			t.Errorf("Expected an error for an injected %s, received:\n%s", name, raw)
+		}
+	}
+
+	_, err := Email{From: "blog@example.com", To: []string{"not an address"}}.Raw()
+	if !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("Expected ErrInvalidAddress, received: %v", err)
+	}
+	_, err = Email{From: "blog@example.com", To: []string{"jane@example.com"}, Header: netmail.Header{"X-Campaign": {"a\nb"}}}.Raw()
+	if !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader, received: %v", err)
+	}
+}
+
+func TestSendRawRejectsUnparsedDestinations(t *testing.T) {
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendRawEmailFunc: func(i *ses.SendRawEmailInput) (*ses.SendRawEmailOutput, error) {
+				t.Error("Expected SendRawEmail not to be called")
+				return &ses.SendRawEmailOutput{}, nil
+			},
+		},
+	}
+
+	_, err := m.SendRaw(Email{
+		From: "blog@example.com",
+		To:   []string{"jane@example.com"},
+		BCC:  []string{"archive@example.com>, <victim@example.com"},
+	})
+
+	if !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("Expected ErrInvalidAddress, received: %v", err)
+	}
+}
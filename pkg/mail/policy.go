@@ -0,0 +1,217 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"strings"
+)
+
+// ErrRejected is returned by Decision.Err for messages the policy rejects.
+var ErrRejected = errors.New("Message rejected")
+
+// Verdict values SES gives for each check it makes on incoming mail.
+const (
+	VerdictPass             = "PASS"
+	VerdictFail             = "FAIL"
+	VerdictGray             = "GRAY"
+	VerdictProcessingFailed = "PROCESSING_FAILED"
+	VerdictDisabled         = "DISABLED"
+)
+
+// Check names a verdict the policy can require.
+type Check string
+
+// Checks SES makes on incoming mail.
+const (
+	CheckSPF   Check = "SPF"
+	CheckDKIM  Check = "DKIM"
+	CheckDMARC Check = "DMARC"
+	CheckSpam  Check = "Spam"
+	CheckVirus Check = "Virus"
+)
+
+// DefaultAuthServID identifies the Authentication-Results header SES adds to received mail.
+const DefaultAuthServID = "amazonses.com"
+
+// DefaultChecks are required when Policy.Checks is nil.
+var DefaultChecks = []Check{CheckSPF, CheckDKIM, CheckDMARC, CheckSpam, CheckVirus}
+
+// Verdicts are the results of the checks SES made on a message.
+// A check that wasn't reported is left empty.
+type Verdicts struct {
+	SPF   string
+	DKIM  string
+	DMARC string
+	Spam  string
+	Virus string
+}
+
+func (v Verdicts) get(c Check) string {
+	switch c {
+	case CheckSPF:
+		return v.SPF
+	case CheckDKIM:
+		return v.DKIM
+	case CheckDMARC:
+		return v.DMARC
+	case CheckSpam:
+		return v.Spam
+	case CheckVirus:
+		return v.Virus
+	}
+	return ""
+}
+
+// HeaderVerdicts reads the verdicts SES adds to the headers of stored mail:
+// X-SES-Spam-Verdict, X-SES-Virus-Verdict and the spf, dkim and dmarc
+// results in the first Authentication-Results header from authServID,
+// DefaultAuthServID when empty. Results claimed by anyone else, including
+// the sender, are ignored, as is Received-SPF.
+//
+// SES adds its headers above any the sender wrote, but only adds the spam
+// and virus verdicts when it scans the mail, so without scanning those read
+// here are whatever the sender wrote. The verdicts in a Receipt are the ones
+// to use when the mail came with one.
+func HeaderVerdicts(h netmail.Header, authServID string) Verdicts {
+	if authServID == "" {
+		authServID = DefaultAuthServID
+	}
+	v := Verdicts{
+		Spam:  strings.ToUpper(strings.TrimSpace(h.Get("X-SES-Spam-Verdict"))),
+		Virus: strings.ToUpper(strings.TrimSpace(h.Get("X-SES-Virus-Verdict"))),
+	}
+
+	var results []string
+	for _, header := range h["Authentication-Results"] {
+		parts := strings.Split(header, ";")
+		// The authserv-id may be followed by a version number.
+		if fields := strings.Fields(parts[0]); len(fields) > 0 && strings.EqualFold(fields[0], authServID) {
+			results = parts[1:]
+			break
+		}
+	}
+
+	for _, result := range results {
+		fields := strings.Fields(result)
+		if len(fields) == 0 {
+			continue
+		}
+		parts := strings.SplitN(fields[0], "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		verdict := authenticationVerdict(parts[1])
+		switch strings.ToLower(parts[0]) {
+		case "spf":
+			v.SPF = verdict
+		case "dkim":
+			if v.DKIM != VerdictPass {
+				v.DKIM = verdict
+			}
+		case "dmarc":
+			v.DMARC = verdict
+		}
+	}
+
+	return v
+}
+
+// authenticationVerdict maps an RFC 8601 result onto the SES verdict values.
+func authenticationVerdict(result string) string {
+	switch strings.ToLower(result) {
+	case "pass":
+		return VerdictPass
+	case "fail", "softfail":
+		return VerdictFail
+	case "temperror", "permerror":
+		return VerdictProcessingFailed
+	}
+	return VerdictGray
+}
+
+// Policy decides whether an incoming message may be published.
+type Policy struct {
+	// AllowedSenders are addresses such as jane@example.com or domains such as
+	// example.com or @example.com. Nobody is allowed when it is empty.
+	AllowedSenders []string
+	// CheckReturnPath requires the Return-Path, when there is one, to be allowed as well as From.
+	CheckReturnPath bool
+	// Checks are the verdicts that must pass, DefaultChecks when nil.
+	Checks []Check
+	// AllowGray accepts GRAY verdicts, given when the sending domain publishes
+	// nothing to check against.
+	AllowGray bool
+}
+
+// Decision is the outcome of a policy along with why a message was rejected.
+type Decision struct {
+	Accept  bool
+	Reasons []string
+}
+
+// Err returns an error wrapping ErrRejected for rejected messages, nil otherwise.
+func (d Decision) Err() error {
+	if d.Accept {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrRejected, strings.Join(d.Reasons, "; "))
+}
+
+// Evaluate checks the message's senders and verdicts against the policy.
+func (p *Policy) Evaluate(m Message, v Verdicts) Decision {
+	var reasons []string
+
+	if m.From == nil {
+		reasons = append(reasons, "message has no From address")
+	} else if !p.allowed(m.From.Address) {
+		reasons = append(reasons, fmt.Sprintf("sender %s is not allowed", m.From.Address))
+	}
+
+	if p.CheckReturnPath {
+		returnPath := strings.Trim(strings.TrimSpace(m.Header.Get("Return-Path")), "<>")
+		if returnPath != "" && !p.allowed(returnPath) {
+			reasons = append(reasons, fmt.Sprintf("return path %s is not allowed", returnPath))
+		}
+	}
+
+	checks := p.Checks
+	if checks == nil {
+		checks = DefaultChecks
+	}
+	for _, check := range checks {
+		verdict := v.get(check)
+		if verdict == VerdictPass || (verdict == VerdictGray && p.AllowGray) {
+			continue
+		}
+		if verdict == "" {
+			verdict = "missing"
+		}
+		reasons = append(reasons, fmt.Sprintf("%s verdict %s", check, verdict))
+	}
+
+	return Decision{Accept: len(reasons) == 0, Reasons: reasons}
+}
+
+func (p *Policy) allowed(address string) bool {
+	address = strings.ToLower(address)
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return false
+	}
+	domain := address[at+1:]
+
+	for _, sender := range p.AllowedSenders {
+		sender = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(sender)), "@")
+		if strings.Contains(sender, "@") {
+			if sender == address {
+				return true
+			}
+			continue
+		}
+		if domain == sender {
+			return true
+		}
+	}
+	return false
+}
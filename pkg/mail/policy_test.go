@@ -0,0 +1,84 @@
+package mail
+
+import (
+	"errors"
+	netmail "net/mail"
+	"testing"
+)
+
+var passing = Verdicts{SPF: "PASS", DKIM: "PASS", DMARC: "PASS", Spam: "PASS", Virus: "PASS"}
+
+func from(address string) Message {
+	return Message{From: &netmail.Address{Address: address}, Header: netmail.Header{}}
+}
+
+func TestPolicyAcceptsAllowedAddressesAndDomains(t *testing.T) {
+	p := Policy{AllowedSenders: []string{"Jane@Example.com", "@blog.example.org", "example.net"}}
+
+	equals(t, true, p.Evaluate(from("jane@example.com"), passing).Accept)
+	equals(t, true, p.Evaluate(from("bob@blog.example.org"), passing).Accept)
+	equals(t, true, p.Evaluate(from("bob@example.net"), passing).Accept)
+
+	d := p.Evaluate(from("bob@example.com"), passing)
+	equals(t, false, d.Accept)
+	equals(t, []string{"sender bob@example.com is not allowed"}, d.Reasons)
+	equals(t, false, p.Evaluate(from("bob@sub.example.net"), passing).Accept)
+}
+
+func TestPolicyRejectsEveryoneWithoutAllowlist(t *testing.T) {
+	p := Policy{}
+
+	equals(t, false, p.Evaluate(from("jane@example.com"), passing).Accept)
+}
+
+func TestPolicyChecksReturnPath(t *testing.T) {
+	p := Policy{AllowedSenders: []string{"example.com"}, CheckReturnPath: true}
+	m := from("jane@example.com")
+	m.Header["Return-Path"] = []string{"<bounce@spammer.test>"}
+
+	d := p.Evaluate(m, passing)
+
+	equals(t, []string{"return path bounce@spammer.test is not allowed"}, d.Reasons)
+}
+
+func TestPolicyRequiresVerdictsToPass(t *testing.T) {
+	p := Policy{AllowedSenders: []string{"example.com"}}
+	v := Verdicts{SPF: "FAIL", DKIM: "PASS", DMARC: "GRAY", Virus: "PASS"}
+
+	d := p.Evaluate(from("jane@example.com"), v)
+
+	equals(t, []string{"SPF verdict FAIL", "DMARC verdict GRAY", "Spam verdict missing"}, d.Reasons)
+	if !errors.Is(d.Err(), ErrRejected) {
+		t.Errorf("Expected ErrRejected, received: %v", d.Err())
+	}
+
+	p.AllowGray = true
+	p.Checks = []Check{CheckDKIM, CheckDMARC, CheckVirus}
+	d = p.Evaluate(from("jane@example.com"), v)
+	equals(t, true, d.Accept)
+	ok(t, d.Err())
+}
+
+func TestHeaderVerdictsReadsSESHeaders(t *testing.T) {
+	h := netmail.Header{
+		"X-Ses-Spam-Verdict":     {"PASS"},
+		"X-Ses-Virus-Verdict":    {"FAIL"},
+		"Authentication-Results": {"amazonses.com; spf=softfail (spfCheck: ...) smtp.mailfrom=a@example.com; dkim=fail header.i=@x.com; dkim=pass header.i=@example.com; dmarc=none header.from=example.com;"},
+	}
+
+	equals(t, Verdicts{SPF: "FAIL", DKIM: "PASS", DMARC: "GRAY", Spam: "PASS", Virus: "FAIL"}, HeaderVerdicts(h, ""))
+}
+
+func TestHeaderVerdictsIgnoresResultsFromOtherServers(t *testing.T) {
+	h := netmail.Header{
+		"Received-Spf": {"pass (spfCheck: domain of example.com designates 1.2.3.4 as permitted sender)"},
+		"Authentication-Results": {
+			"mx.attacker.example; spf=pass smtp.mailfrom=jane@example.com; dkim=pass; dmarc=pass",
+			"amazonses.com 1; spf=fail smtp.mailfrom=jane@example.com; dkim=none; dmarc=fail",
+		},
+	}
+
+	equals(t, Verdicts{SPF: "FAIL", DKIM: "GRAY", DMARC: "FAIL"}, HeaderVerdicts(h, ""))
+	equals(t, Verdicts{SPF: "PASS", DKIM: "PASS", DMARC: "PASS"}, HeaderVerdicts(h, "mx.attacker.example"))
+	equals(t, Verdicts{}, HeaderVerdicts(netmail.Header{"Received-Spf": h["Received-Spf"]}, ""))
+}
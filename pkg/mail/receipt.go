@@ -0,0 +1,184 @@
+package mail
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+)
+
+// ErrNotReceiptEvent is returned for records that didn't come from an SES receipt rule.
+var ErrNotReceiptEvent = errors.New("Not an SES receipt event")
+
+// ReceiptEvent is the event an SES receipt rule's Lambda action invokes a function with.
+type ReceiptEvent struct {
+	Records []ReceiptRecord `json:"Records"`
+}
+
+// ReceiptRecord is a single message in a ReceiptEvent.
+type ReceiptRecord struct {
+	EventSource  string     `json:"eventSource"`
+	EventVersion string     `json:"eventVersion"`
+	SES          ReceiptSES `json:"ses"`
+}
+
+// ReceiptSES is the message and receipt SES sends with each record.
+type ReceiptSES struct {
+	Mail    ReceiptMail `json:"mail"`
+	Receipt Receipt     `json:"receipt"`
+}
+
+// ReceiptMail describes the received message.
+type ReceiptMail struct {
+	Timestamp        time.Time       `json:"timestamp"`
+	Source           string          `json:"source"`
+	MessageID        string          `json:"messageId"`
+	Destination      []string        `json:"destination"`
+	HeadersTruncated bool            `json:"headersTruncated"`
+	Headers          []ReceiptHeader `json:"headers"`
+	CommonHeaders    CommonHeaders   `json:"commonHeaders"`
+}
+
+// ReceiptHeader is one of the message's headers, in the order they were sent.
+type ReceiptHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// CommonHeaders are the headers SES parses out of the message for you.
+type CommonHeaders struct {
+	ReturnPath string   `json:"returnPath"`
+	From       []string `json:"from"`
+	Date       string   `json:"date"`
+	To         []string `json:"to"`
+	CC         []string `json:"cc"`
+	MessageID  string   `json:"messageId"`
+	Subject    string   `json:"subject"`
+}
+
+// Receipt holds the checks SES made on the message and the action that delivered it.
+type Receipt struct {
+	Timestamp            time.Time      `json:"timestamp"`
+	ProcessingTimeMillis int64          `json:"processingTimeMillis"`
+	Recipients           []string       `json:"recipients"`
+	SpamVerdict          ReceiptVerdict `json:"spamVerdict"`
+	VirusVerdict         ReceiptVerdict `json:"virusVerdict"`
+	SPFVerdict           ReceiptVerdict `json:"spfVerdict"`
+	DKIMVerdict          ReceiptVerdict `json:"dkimVerdict"`
+	DMARCVerdict         ReceiptVerdict `json:"dmarcVerdict"`
+	DMARCPolicy          string         `json:"dmarcPolicy"`
+	Action               ReceiptAction  `json:"action"`
+}
+
+// ReceiptVerdict is the status of one check, such as PASS or FAIL.
+type ReceiptVerdict struct {
+	Status string `json:"status"`
+}
+
+// ReceiptAction is the receipt rule action that produced the event.
+// BucketName and ObjectKey are only set for S3 actions.
+type ReceiptAction struct {
+	Type           string `json:"type"`
+	TopicARN       string `json:"topicArn"`
+	BucketName     string `json:"bucketName"`
+	ObjectKey      string `json:"objectKey"`
+	FunctionARN    string `json:"functionArn"`
+	InvocationType string `json:"invocationType"`
+}
+
+// Verdicts returns the receipt's verdicts for use with a Policy.
+func (r Receipt) Verdicts() Verdicts {
+	return Verdicts{
+		SPF:   r.SPFVerdict.Status,
+		DKIM:  r.DKIMVerdict.Status,
+		DMARC: r.DMARCVerdict.Status,
+		Spam:  r.SpamVerdict.Status,
+		Virus: r.VirusVerdict.Status,
+	}
+}
+
+// Received is a message fetched for a receipt record.
+type Received struct {
+	Message Message
+	Mail    ReceiptMail
+	Receipt Receipt
+	// Key is the object the raw message was read from.
+	Key string
+}
+
+// Inbox reads the messages SES receipt rules have stored in a bucket.
+type Inbox struct {
+	Bucket *storage.Bucket
+	// KeyPrefix is the object key prefix of the rule's S3 action. Records from
+	// a Lambda action don't name the object, so it is found at KeyPrefix plus the message ID.
+	KeyPrefix string
+	// Logger receives the inbox's logs, logging.Default() when nil.
+	Logger logging.Logger
+}
+
+func (i *Inbox) log() logging.Logger {
+	return logging.Or(i.Logger)
+}
+
+// Receive fetches and parses the raw message for a receipt record.
+// Records from an S3 action are read from the bucket and key they name.
+func (i *Inbox) Receive(record ReceiptRecord) (*Received, error) {
+	if record.EventSource != "aws:ses" {
+		return nil, ErrNotReceiptEvent
+	}
+
+	info := record.SES.Mail
+	receipt := record.SES.Receipt
+	bucket := i.Bucket
+	key := i.KeyPrefix + info.MessageID
+	if receipt.Action.Type == "S3" {
+		key = receipt.Action.ObjectKey
+		if receipt.Action.BucketName != "" && receipt.Action.BucketName != bucket.Name {
+			b := *bucket
+			b.Name = receipt.Action.BucketName
+			bucket = &b
+		}
+	}
+
+	i.log().WithFields(logging.Fields{
+		"bucket":    bucket.Name,
+		"key":       key,
+		"messageId": info.MessageID,
+	}).Debug("Receiving message")
+
+	body, _, err := bucket.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	message, err := Parse(body)
+	if err != nil {
+		i.log().WithFields(logging.Fields{
+			"key":   key,
+			"error": err,
+		}).Error("Failed to parse message")
+		return nil, err
+	}
+
+	return &Received{
+		Message: message,
+		Mail:    info,
+		Receipt: receipt,
+		Key:     key,
+	}, nil
+}
+
+// ReceiveAll receives every record in the event, stopping at the first failure.
+func (i *Inbox) ReceiveAll(event ReceiptEvent) ([]*Received, error) {
+	var received []*Received
+	for _, record := range event.Records {
+		r, err := i.Receive(record)
+		if err != nil {
+			return received, err
+		}
+		received = append(received, r)
+	}
+	return received, nil
+}
@@ -0,0 +1,97 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+)
+
+type mockedS3API struct {
+	s3iface.S3API
+	GetObjectFunc func(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+}
+
+func (m mockedS3API) GetObjectWithContext(_ aws.Context, i *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	return m.GetObjectFunc(i)
+}
+
+const rawMessage = "From: Jane Doe <jane@example.com>\r\n" +
+	"Subject: My first post\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Hello"
+
+func loadReceiptEvent(t *testing.T) ReceiptEvent {
+	data, err := ioutil.ReadFile("testdata/receipt_event.json")
+	ok(t, err)
+	var event ReceiptEvent
+	ok(t, json.Unmarshal(data, &event))
+	return event
+}
+
+func inboxReading(bucket *string, key *string) *Inbox {
+	return &Inbox{
+		Bucket: &storage.Bucket{
+			Client: mockedS3API{
+				GetObjectFunc: func(i *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+					*bucket = *i.Bucket
+					*key = *i.Key
+					return &s3.GetObjectOutput{
+						Body: ioutil.NopCloser(bytes.NewReader([]byte(rawMessage))),
+					}, nil
+				},
+			},
+			Name: "inbound",
+		},
+		KeyPrefix: "mail/",
+	}
+}
+
+func TestReceiveReadsMessageForLambdaAction(t *testing.T) {
+	var bucket, key string
+	inbox := inboxReading(&bucket, &key)
+	event := loadReceiptEvent(t)
+
+	received, err := inbox.ReceiveAll(event)
+	ok(t, err)
+
+	equals(t, "inbound", bucket)
+	equals(t, "mail/o3vrnil0e2ic28trm7dfhrc2v0clambda4nbp0g1x", key)
+	equals(t, 1, len(received))
+	equals(t, "Hello", received[0].Message.Body)
+	equals(t, "jane@example.com", received[0].Message.From.Address)
+	equals(t, "blog@example.org", received[0].Mail.Destination[0])
+	equals(t, Verdicts{SPF: "PASS", DKIM: "GRAY", DMARC: "PASS", Spam: "PASS", Virus: "PASS"}, received[0].Receipt.Verdicts())
+}
+
+func TestReceiveUsesBucketAndKeyOfS3Action(t *testing.T) {
+	var bucket, key string
+	inbox := inboxReading(&bucket, &key)
+	record := loadReceiptEvent(t).Records[0]
+	record.SES.Receipt.Action = ReceiptAction{Type: "S3", BucketName: "other", ObjectKey: "incoming/abc"}
+
+	received, err := inbox.Receive(record)
+	ok(t, err)
+
+	equals(t, "other", bucket)
+	equals(t, "incoming/abc", key)
+	equals(t, "incoming/abc", received.Key)
+	equals(t, "inbound", inbox.Bucket.Name)
+}
+
+func TestReceiveRejectsOtherEvents(t *testing.T) {
+	inbox := &Inbox{Bucket: &storage.Bucket{}}
+
+	_, err := inbox.Receive(ReceiptRecord{EventSource: "aws:s3"})
+	if !errors.Is(err, ErrNotReceiptEvent) {
+		t.Errorf("Expected ErrNotReceiptEvent, received: %v", err)
+	}
+}
@@ -0,0 +1,431 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/cstdev/lambdahelpers/pkg/awserror"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	"github.com/cstdev/lambdahelpers/pkg/metrics"
+)
+
+// MaxBulkDestinations is the most destinations SES takes in one
+// SendBulkTemplatedEmail call. Larger sends are split into batches of this size.
+const MaxBulkDestinations = 50
+
+// BulkStatusSuccess is the status SES gives a destination it accepted.
+const BulkStatusSuccess = "Success"
+
+var (
+	// ErrTemplateNotFound is returned when the named SES template doesn't exist.
+	ErrTemplateNotFound = errors.New("Template not found")
+	// ErrTemplateExists is returned when creating a template whose name is taken.
+	ErrTemplateExists = errors.New("Template already exists")
+	// ErrMissingTemplate is returned when a templated email names no template.
+	ErrMissingTemplate = errors.New("Missing template")
+)
+
+// Template is an SES email template. The parts use SES's Handlebars syntax,
+// such as {{name}}, filled in from each email's template data.
+type Template struct {
+	Name    string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+func (t Template) input() *ses.Template {
+	template := &ses.Template{
+		TemplateName: aws.String(t.Name),
+		SubjectPart:  aws.String(t.Subject),
+	}
+	if t.Text != "" {
+		template.TextPart = aws.String(t.Text)
+	}
+	if t.HTML != "" {
+		template.HtmlPart = aws.String(t.HTML)
+	}
+	return template
+}
+
+// TemplateInfo is a template as listed by ListTemplates.
+type TemplateInfo struct {
+	Name    string
+	Created time.Time
+}
+
+// TemplatedEmail is an email whose content comes from an SES template.
+type TemplatedEmail struct {
+	From    string
+	To      []string
+	CC      []string
+	BCC     []string
+	ReplyTo []string
+	// ReturnPath is where bounces are sent, the From address when empty.
+	ReturnPath string
+	Template   string
+	// Data fills in the template. It is marshalled to JSON, so it can be a
+	// struct, a map or a json.RawMessage.
+	Data interface{}
+}
+
+// BulkDestination is one email of a bulk send, with its own template data.
+type BulkDestination struct {
+	To  []string
+	CC  []string
+	BCC []string
+	// Data replaces the bulk email's DefaultData for this destination.
+	Data interface{}
+}
+
+// BulkEmail sends a template to many destinations.
+type BulkEmail struct {
+	From    string
+	ReplyTo []string
+	// ReturnPath is where bounces are sent, the From address when empty.
+	ReturnPath string
+	Template   string
+	// DefaultData fills in the template for destinations that don't have their own data.
+	DefaultData  interface{}
+	Destinations []BulkDestination
+}
+
+// BulkStatus is what happened to a single destination of a bulk send.
+type BulkStatus struct {
+	// Index is the destination's position in BulkEmail.Destinations.
+	Index     int
+	MessageID string
+	// Status is BulkStatusSuccess or the reason SES gave for not sending.
+	Status string
+	Err    error
+}
+
+// BulkReport lists the status of every destination of a bulk send, in order.
+type BulkReport struct {
+	Statuses []BulkStatus
+}
+
+// Failed returns the destinations that weren't sent.
+func (r *BulkReport) Failed() []BulkStatus {
+	var failed []BulkStatus
+	for _, status := range r.Statuses {
+		if status.Err != nil {
+			failed = append(failed, status)
+		}
+	}
+	return failed
+}
+
+// Err returns an error summarising the failed destinations, or nil if there were none.
+func (r *BulkReport) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d destinations failed to send, first error: destination %d: %w",
+		len(failed), len(r.Statuses), failed[0].Index, failed[0].Err)
+}
+
+func templateError(op string, err error) error {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case ses.ErrCodeTemplateDoesNotExistException:
+			return awserror.WrapKind(op, err, ErrTemplateNotFound)
+		case ses.ErrCodeAlreadyExistsException:
+			return awserror.WrapKind(op, err, ErrTemplateExists)
+		}
+	}
+	return awserror.Wrap(op, err)
+}
+
+// templateData marshals data for SES, which takes "{}" for no data.
+func templateData(data interface{}) (string, error) {
+	if data == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("Marshalling template data: %w", err)
+	}
+	return string(b), nil
+}
+
+// CreateTemplate creates an SES template, returning ErrTemplateExists if the name is taken.
+func (m *SESMail) CreateTemplate(t Template) error {
+	m.log().WithFields(logging.Fields{
+		"template": t.Name,
+	}).Info("Creating template")
+	err := m.call("ses:CreateTemplate", func(ctx context.Context) error {
+		_, err := m.Client.CreateTemplateWithContext(ctx, &ses.CreateTemplateInput{Template: t.input()})
+		return err
+	})
+	if err != nil {
+		m.log().WithFields(logging.Fields{
+			"template": t.Name,
+			"error":    err,
+		}).Error("Failed to create template")
+	}
+	return err
+}
+
+// UpdateTemplate replaces an SES template, returning ErrTemplateNotFound if it doesn't exist.
+func (m *SESMail) UpdateTemplate(t Template) error {
+	m.log().WithFields(logging.Fields{
+		"template": t.Name,
+	}).Info("Updating template")
+	err := m.call("ses:UpdateTemplate", func(ctx context.Context) error {
+		_, err := m.Client.UpdateTemplateWithContext(ctx, &ses.UpdateTemplateInput{Template: t.input()})
+		return err
+	})
+	if err != nil {
+		m.log().WithFields(logging.Fields{
+			"template": t.Name,
+			"error":    err,
+		}).Error("Failed to update template")
+	}
+	return err
+}
+
+// DeleteTemplate deletes an SES template. SES doesn't report templates
+// that don't exist, so deleting one is not an error.
+func (m *SESMail) DeleteTemplate(name string) error {
+	m.log().WithFields(logging.Fields{
+		"template": name,
+	}).Info("Deleting template")
+	err := m.call("ses:DeleteTemplate", func(ctx context.Context) error {
+		_, err := m.Client.DeleteTemplateWithContext(ctx, &ses.DeleteTemplateInput{TemplateName: aws.String(name)})
+		return err
+	})
+	if err != nil {
+		m.log().WithFields(logging.Fields{
+			"template": name,
+			"error":    err,
+		}).Error("Failed to delete template")
+	}
+	return err
+}
+
+// GetTemplate returns an SES template, or ErrTemplateNotFound.
+func (m *SESMail) GetTemplate(name string) (*Template, error) {
+	var resp *ses.GetTemplateOutput
+	err := m.call("ses:GetTemplate", func(ctx context.Context) (err error) {
+		resp, err = m.Client.GetTemplateWithContext(ctx, &ses.GetTemplateInput{TemplateName: aws.String(name)})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Template{
+		Name:    aws.StringValue(resp.Template.TemplateName),
+		Subject: aws.StringValue(resp.Template.SubjectPart),
+		Text:    aws.StringValue(resp.Template.TextPart),
+		HTML:    aws.StringValue(resp.Template.HtmlPart),
+	}, nil
+}
+
+// ListTemplates returns every SES template in the account, following pagination.
+func (m *SESMail) ListTemplates() ([]TemplateInfo, error) {
+	var templates []TemplateInfo
+	input := &ses.ListTemplatesInput{}
+	for {
+		var resp *ses.ListTemplatesOutput
+		err := m.call("ses:ListTemplates", func(ctx context.Context) (err error) {
+			resp, err = m.Client.ListTemplatesWithContext(ctx, input)
+			return err
+		})
+		if err != nil {
+			m.log().WithFields(logging.Fields{
+				"error": err,
+			}).Error("Failed to list templates")
+			return templates, err
+		}
+		for _, t := range resp.TemplatesMetadata {
+			templates = append(templates, TemplateInfo{
+				Name:    aws.StringValue(t.Name),
+				Created: aws.TimeValue(t.CreatedTimestamp),
+			})
+		}
+		if aws.StringValue(resp.NextToken) == "" {
+			return templates, nil
+		}
+		input.NextToken = resp.NextToken
+	}
+}
+
+// SendTemplated sends an email made from an SES template and returns the message ID SES gave it.
+func (m *SESMail) SendTemplated(e TemplatedEmail) (messageID string, err error) {
+	m.log().WithFields(logging.Fields{
+		"sender":    e.From,
+		"recipient": strings.Join(e.To, ", "),
+		"template":  e.Template,
+	}).Debug("Sending templated email")
+
+	switch {
+	case Email{To: e.To, CC: e.CC, BCC: e.BCC}.recipients() == 0:
+		return "", ErrMissingRecipient
+	case e.From == "":
+		return "", ErrMissingSender
+	case e.Template == "":
+		return "", ErrMissingTemplate
+	}
+	data, err := templateData(e.Data)
+	if err != nil {
+		return "", err
+	}
+
+	input := &ses.SendTemplatedEmailInput{
+		Destination: &ses.Destination{
+			ToAddresses:  addresses(e.To),
+			CcAddresses:  addresses(e.CC),
+			BccAddresses: addresses(e.BCC),
+		},
+		ReplyToAddresses: addresses(e.ReplyTo),
+		Source:           aws.String(e.From),
+		Template:         aws.String(e.Template),
+		TemplateData:     aws.String(data),
+	}
+	if e.ReturnPath != "" {
+		input.ReturnPath = aws.String(e.ReturnPath)
+	}
+
+	var resp *ses.SendTemplatedEmailOutput
+	err = m.call("ses:SendTemplatedEmail", func(ctx context.Context) (err error) {
+		resp, err = m.Client.SendTemplatedEmailWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		metrics.Or(m.Metrics).Record(metrics.EmailsFailed, 1, metrics.Count, nil)
+		m.log().WithFields(logging.Fields{
+			"error": err,
+		}).Error("Failed to send templated email")
+		return "", err
+	}
+	metrics.Or(m.Metrics).Record(metrics.EmailsSent, 1, metrics.Count, nil)
+	return aws.StringValue(resp.MessageId), nil
+}
+
+// SendBulkTemplated sends the template to every destination, in batches of
+// MaxBulkDestinations. A destination whose data can't be marshalled is
+// marked as failed and left out of its batch, and a batch that fails marks
+// the destinations sent in it as failed, without stopping the rest. The
+// report holds a status for each destination and the error summarises any
+// that failed.
+func (m *SESMail) SendBulkTemplated(e BulkEmail) (*BulkReport, error) {
+	switch {
+	case len(e.Destinations) == 0:
+		return nil, ErrMissingRecipient
+	case e.From == "":
+		return nil, ErrMissingSender
+	case e.Template == "":
+		return nil, ErrMissingTemplate
+	}
+	defaultData, err := templateData(e.DefaultData)
+	if err != nil {
+		return nil, err
+	}
+
+	m.log().WithFields(logging.Fields{
+		"sender":       e.From,
+		"template":     e.Template,
+		"destinations": len(e.Destinations),
+	}).Info("Sending bulk templated email")
+
+	report := &BulkReport{}
+	for start := 0; start < len(e.Destinations); start += MaxBulkDestinations {
+		end := start + MaxBulkDestinations
+		if end > len(e.Destinations) {
+			end = len(e.Destinations)
+		}
+		statuses, err := m.sendBulkBatch(e, defaultData, start, e.Destinations[start:end])
+		if err != nil {
+			m.log().WithFields(logging.Fields{
+				"first": start,
+				"last":  end - 1,
+				"error": err,
+			}).Error("Failed to send bulk batch")
+		}
+		report.Statuses = append(report.Statuses, statuses...)
+	}
+
+	sent := len(report.Statuses) - len(report.Failed())
+	metrics.Or(m.Metrics).Record(metrics.EmailsSent, float64(sent), metrics.Count, nil)
+	metrics.Or(m.Metrics).Record(metrics.EmailsFailed, float64(len(report.Failed())), metrics.Count, nil)
+	return report, report.Err()
+}
+
+// sendBulkBatch sends a batch of destinations starting at offset, returning
+// a status for each of them. The error is that of the SES call, which every
+// destination sent in it also fails with.
+func (m *SESMail) sendBulkBatch(e BulkEmail, defaultData string, offset int, batch []BulkDestination) ([]BulkStatus, error) {
+	input := &ses.SendBulkTemplatedEmailInput{
+		DefaultTemplateData: aws.String(defaultData),
+		ReplyToAddresses:    addresses(e.ReplyTo),
+		Source:              aws.String(e.From),
+		Template:            aws.String(e.Template),
+	}
+	if e.ReturnPath != "" {
+		input.ReturnPath = aws.String(e.ReturnPath)
+	}
+	statuses := make([]BulkStatus, len(batch))
+	// sent holds the position in batch of each destination in the input.
+	var sent []int
+	for i, d := range batch {
+		statuses[i] = BulkStatus{Index: offset + i}
+		destination := &ses.BulkEmailDestination{
+			Destination: &ses.Destination{
+				ToAddresses:  addresses(d.To),
+				CcAddresses:  addresses(d.CC),
+				BccAddresses: addresses(d.BCC),
+			},
+		}
+		if d.Data != nil {
+			data, err := templateData(d.Data)
+			if err != nil {
+				statuses[i].Err = err
+				continue
+			}
+			destination.ReplacementTemplateData = aws.String(data)
+		}
+		input.Destinations = append(input.Destinations, destination)
+		sent = append(sent, i)
+	}
+	if len(sent) == 0 {
+		return statuses, nil
+	}
+
+	var resp *ses.SendBulkTemplatedEmailOutput
+	err := m.call("ses:SendBulkTemplatedEmail", func(ctx context.Context) (err error) {
+		resp, err = m.Client.SendBulkTemplatedEmailWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		for _, i := range sent {
+			statuses[i].Err = err
+		}
+		return statuses, err
+	}
+
+	// SES returns the statuses in the order the destinations were given.
+	for j, i := range sent {
+		if j >= len(resp.Status) {
+			statuses[i].Status = "Missing"
+			statuses[i].Err = errors.New("SES returned no status")
+			continue
+		}
+		status := resp.Status[j]
+		statuses[i].Status = aws.StringValue(status.Status)
+		statuses[i].MessageID = aws.StringValue(status.MessageId)
+		if statuses[i].Status != BulkStatusSuccess {
+			statuses[i].Err = fmt.Errorf("%s: %s", statuses[i].Status, aws.StringValue(status.Error))
+		}
+	}
+	return statuses, nil
+}
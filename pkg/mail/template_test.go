@@ -0,0 +1,225 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/cstdev/lambdahelpers/pkg/metrics"
+)
+
+func TestCreateTemplateReturnsErrTemplateExists(t *testing.T) {
+	var input *ses.CreateTemplateInput
+	m := SESMail{
+		Client: &mockedSESAPI{
+			CreateTemplateFunc: func(i *ses.CreateTemplateInput) (*ses.CreateTemplateOutput, error) {
+				input = i
+				return nil, awserr.New(ses.ErrCodeAlreadyExistsException, "Template published already exists", nil)
+			},
+		},
+	}
+
+	err := m.CreateTemplate(Template{Name: "published", Subject: "Published {{slug}}", HTML: "<p>{{title}}</p>"})
+
+	if !errors.Is(err, ErrTemplateExists) {
+		t.Errorf("Expected ErrTemplateExists, received: %v", err)
+	}
+	equals(t, "Published {{slug}}", *input.Template.SubjectPart)
+	equals(t, "<p>{{title}}</p>", *input.Template.HtmlPart)
+	equals(t, (*string)(nil), input.Template.TextPart)
+}
+
+func TestGetTemplateReturnsErrTemplateNotFound(t *testing.T) {
+	m := SESMail{
+		Client: &mockedSESAPI{
+			GetTemplateFunc: func(i *ses.GetTemplateInput) (*ses.GetTemplateOutput, error) {
+				return nil, awserr.New(ses.ErrCodeTemplateDoesNotExistException, "Template missing does not exist", nil)
+			},
+		},
+	}
+
+	_, err := m.GetTemplate("missing")
+
+	if !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected ErrTemplateNotFound, received: %v", err)
+	}
+}
+
+func TestListTemplatesFollowsNextToken(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := SESMail{
+		Client: &mockedSESAPI{
+			ListTemplatesFunc: func(i *ses.ListTemplatesInput) (*ses.ListTemplatesOutput, error) {
+				if i.NextToken == nil {
+					return &ses.ListTemplatesOutput{
+						TemplatesMetadata: []*ses.TemplateMetadata{{Name: aws.String("published"), CreatedTimestamp: aws.Time(created)}},
+						NextToken:         aws.String("page-2"),
+					}, nil
+				}
+				return &ses.ListTemplatesOutput{
+					TemplatesMetadata: []*ses.TemplateMetadata{{Name: aws.String("failed"), CreatedTimestamp: aws.Time(created)}},
+				}, nil
+			},
+		},
+	}
+
+	templates, err := m.ListTemplates()
+	ok(t, err)
+
+	equals(t, []TemplateInfo{{Name: "published", Created: created}, {Name: "failed", Created: created}}, templates)
+}
+
+func TestSendTemplatedMarshalsData(t *testing.T) {
+	var input *ses.SendTemplatedEmailInput
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendTemplatedEmailFunc: func(i *ses.SendTemplatedEmailInput) (*ses.SendTemplatedEmailOutput, error) {
+				input = i
+				return &ses.SendTemplatedEmailOutput{MessageId: aws.String("msg-1")}, nil
+			},
+		},
+	}
+
+	id, err := m.SendTemplated(TemplatedEmail{
+		From:     "blog@example.com",
+		To:       []string{"jane@example.com"},
+		Template: "published",
+		Data:     map[string]string{"slug": "hello-world"},
+	})
+	ok(t, err)
+
+	equals(t, "msg-1", id)
+	equals(t, "published", *input.Template)
+	equals(t, `{"slug":"hello-world"}`, *input.TemplateData)
+
+	_, err = m.SendTemplated(TemplatedEmail{From: "blog@example.com", To: []string{"jane@example.com"}})
+	if !errors.Is(err, ErrMissingTemplate) {
+		t.Errorf("Expected ErrMissingTemplate, received: %v", err)
+	}
+}
+
+func TestSendTemplatedCountsOneEmailWhateverTheRecipients(t *testing.T) {
+	sink := &metrics.Memory{}
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendTemplatedEmailFunc: func(i *ses.SendTemplatedEmailInput) (*ses.SendTemplatedEmailOutput, error) {
+				return &ses.SendTemplatedEmailOutput{MessageId: aws.String("msg-1")}, nil
+			},
+		},
+		Metrics: sink,
+	}
+
+	_, err := m.SendTemplated(TemplatedEmail{
+		From:     "blog@example.com",
+		To:       []string{"jane@example.com", "john@example.com"},
+		CC:       []string{"editor@example.com"},
+		Template: "published",
+	})
+	ok(t, err)
+
+	equals(t, float64(1), sink.Sum(metrics.EmailsSent))
+}
+
+func TestSendBulkTemplatedBatchesAndReportsEachDestination(t *testing.T) {
+	var batches []int
+	sink := &metrics.Memory{}
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendBulkTemplatedEmailFunc: func(i *ses.SendBulkTemplatedEmailInput) (*ses.SendBulkTemplatedEmailOutput, error) {
+				batches = append(batches, len(i.Destinations))
+				if len(batches) == 3 {
+					return nil, awserr.New("Throttling", "Maximum sending rate exceeded", nil)
+				}
+				out := &ses.SendBulkTemplatedEmailOutput{}
+				for n, d := range i.Destinations {
+					status := &ses.BulkEmailDestinationStatus{
+						Status:    aws.String(BulkStatusSuccess),
+						MessageId: aws.String(fmt.Sprintf("msg-%d-%d", len(batches), n)),
+					}
+					if *d.Destination.ToAddresses[0] == "reader7@example.com" {
+						status = &ses.BulkEmailDestinationStatus{
+							Status: aws.String("MessageRejected"),
+							Error:  aws.String("Email address is on the suppression list"),
+						}
+					}
+					out.Status = append(out.Status, status)
+				}
+				equals(t, `{"site":"Blog"}`, *i.DefaultTemplateData)
+				equals(t, fmt.Sprintf(`{"n":%d}`, (len(batches)-1)*MaxBulkDestinations), *i.Destinations[0].ReplacementTemplateData)
+				return out, nil
+			},
+		},
+		Metrics: sink,
+	}
+
+	var destinations []BulkDestination
+	for n := 0; n < 120; n++ {
+		destinations = append(destinations, BulkDestination{
+			To:   []string{fmt.Sprintf("reader%d@example.com", n)},
+			Data: map[string]int{"n": n},
+		})
+	}
+	report, err := m.SendBulkTemplated(BulkEmail{
+		From:         "blog@example.com",
+		Template:     "published",
+		DefaultData:  map[string]string{"site": "Blog"},
+		Destinations: destinations,
+	})
+
+	if err == nil {
+		t.Fatal("Expected the failed destinations to be reported")
+	}
+	equals(t, []int{50, 50, 20}, batches)
+	equals(t, 120, len(report.Statuses))
+	equals(t, "msg-2-10", report.Statuses[60].MessageID)
+	equals(t, "MessageRejected", report.Statuses[7].Status)
+	failed := report.Failed()
+	equals(t, 21, len(failed))
+	equals(t, 7, failed[0].Index)
+	equals(t, 100, failed[1].Index)
+	equals(t, float64(99), sink.Sum(metrics.EmailsSent))
+	equals(t, float64(21), sink.Sum(metrics.EmailsFailed))
+}
+
+func TestSendBulkTemplatedFailsOnlyDestinationsWhoseDataDoesntMarshal(t *testing.T) {
+	var sent []string
+	m := SESMail{
+		Client: &mockedSESAPI{
+			SendBulkTemplatedEmailFunc: func(i *ses.SendBulkTemplatedEmailInput) (*ses.SendBulkTemplatedEmailOutput, error) {
+				out := &ses.SendBulkTemplatedEmailOutput{}
+				for n, d := range i.Destinations {
+					sent = append(sent, *d.Destination.ToAddresses[0])
+					out.Status = append(out.Status, &ses.BulkEmailDestinationStatus{
+						Status:    aws.String(BulkStatusSuccess),
+						MessageId: aws.String(fmt.Sprintf("msg-%d", n)),
+					})
+				}
+				return out, nil
+			},
+		},
+	}
+
+	report, err := m.SendBulkTemplated(BulkEmail{
+		From:     "blog@example.com",
+		Template: "published",
+		Destinations: []BulkDestination{
+			{To: []string{"one@example.com"}},
+			{To: []string{"two@example.com"}, Data: map[string]interface{}{"bad": make(chan int)}},
+			{To: []string{"three@example.com"}, Data: map[string]string{"name": "Three"}},
+		},
+	})
+
+	if err == nil {
+		t.Fatal("Expected the unmarshallable destination to be reported")
+	}
+	equals(t, []string{"one@example.com", "three@example.com"}, sent)
+	equals(t, "msg-0", report.Statuses[0].MessageID)
+	equals(t, "msg-1", report.Statuses[2].MessageID)
+	failed := report.Failed()
+	equals(t, 1, len(failed))
+	equals(t, 1, failed[0].Index)
+}
@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Unit is a CloudWatch metric unit.
+type Unit string
+
+// Units used by the helpers.
+const (
+	Count        Unit = "Count"
+	Bytes        Unit = "Bytes"
+	Milliseconds Unit = "Milliseconds"
+)
+
+// Names of the metrics the helpers record.
+const (
+	// EmailsSent and EmailsFailed count emails, one per email however many
+	// recipients it has, and one per destination of a bulk send.
+	EmailsSent       = "EmailsSent"
+	EmailsFailed     = "EmailsFailed"
+	SMSSent          = "SMSSent"
+	SMSFailed        = "SMSFailed"
+	BytesUploaded    = "BytesUploaded"
+	BytesDownloaded  = "BytesDownloaded"
+	ObjectsProcessed = "ObjectsProcessed"
+	Latency          = "Latency"
+)
+
+// Sink receives metrics. Dimensions are added to any the sink was configured with.
+type Sink interface {
+	Record(name string, value float64, unit Unit, dimensions map[string]string)
+}
+
+// Or returns s, or a sink that drops everything if s is nil.
+func Or(s Sink) Sink {
+	if s == nil {
+		return nop{}
+	}
+	return s
+}
+
+type nop struct{}
+
+func (nop) Record(string, float64, Unit, map[string]string) {}
+
+// Since records the milliseconds elapsed from start as Latency for an operation.
+func Since(s Sink, operation string, start time.Time) {
+	Or(s).Record(Latency, float64(time.Since(start))/float64(time.Millisecond), Milliseconds, map[string]string{
+		"Operation": operation,
+	})
+}
+
+// EMF writes each metric as a CloudWatch Embedded Metric Format JSON line,
+// which Lambda ships to CloudWatch from stdout without an agent.
+type EMF struct {
+	Namespace string
+	// Dimensions are added to every metric, for example the function name.
+	Dimensions map[string]string
+	// Out defaults to os.Stdout.
+	Out io.Writer
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit Unit   `json:"Unit"`
+}
+
+// Record writes a single EMF line for the metric.
+func (e *EMF) Record(name string, value float64, unit Unit, dimensions map[string]string) {
+	doc := make(map[string]interface{}, len(e.Dimensions)+len(dimensions)+2)
+	var keys []string
+	for _, dims := range []map[string]string{e.Dimensions, dimensions} {
+		for k, v := range dims {
+			if _, seen := doc[k]; !seen {
+				keys = append(keys, k)
+			}
+			doc[k] = v
+		}
+	}
+	sort.Strings(keys)
+	if keys == nil {
+		keys = []string{}
+	}
+
+	now := time.Now
+	if e.now != nil {
+		now = e.now
+	}
+	doc["_aws"] = emfMetadata{
+		Timestamp: now().UnixNano() / int64(time.Millisecond),
+		CloudWatchMetrics: []emfDirective{
+			{
+				Namespace:  e.Namespace,
+				Dimensions: [][]string{keys},
+				Metrics:    []emfMetric{{Name: name, Unit: unit}},
+			},
+		},
+	}
+	doc[name] = value
+
+	line, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+
+	out := e.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out.Write(append(line, '\n'))
+}
+
+// Record is a metric kept by Memory.
+type Record struct {
+	Name       string
+	Value      float64
+	Unit       Unit
+	Dimensions map[string]string
+}
+
+// Memory keeps metrics in memory, for tests.
+type Memory struct {
+	mu      sync.Mutex
+	Records []Record
+}
+
+// Record keeps the metric.
+func (m *Memory) Record(name string, value float64, unit Unit, dimensions map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Records = append(m.Records, Record{Name: name, Value: value, Unit: unit, Dimensions: dimensions})
+}
+
+// Sum adds up every value recorded for name.
+func (m *Memory) Sum(name string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total float64
+	for _, r := range m.Records {
+		if r.Name == name {
+			total += r.Value
+		}
+	}
+	return total
+}
+
+// Count returns how many times name was recorded.
+func (m *Memory) Count(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, r := range m.Records {
+		if r.Name == name {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func equals(tb testing.TB, expected, actual interface{}) {
+	if !reflect.DeepEqual(expected, actual) {
+		tb.Errorf("Expected: %v \n Actual: %v", expected, actual)
+	}
+}
+
+func TestEMFWritesEmbeddedMetricFormatLine(t *testing.T) {
+	var buf bytes.Buffer
+	e := &EMF{
+		Namespace:  "Blog",
+		Dimensions: map[string]string{"Service": "publisher"},
+		Out:        &buf,
+		now:        func() time.Time { return time.Unix(1546300800, 0) },
+	}
+
+	e.Record(BytesUploaded, 1024, Bytes, map[string]string{"Operation": "Upload"})
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	equals(t, float64(1024), doc["BytesUploaded"])
+	equals(t, "publisher", doc["Service"])
+	equals(t, "Upload", doc["Operation"])
+
+	aws := doc["_aws"].(map[string]interface{})
+	equals(t, float64(1546300800000), aws["Timestamp"])
+	directive := aws["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	equals(t, "Blog", directive["Namespace"])
+	equals(t, []interface{}{[]interface{}{"Operation", "Service"}}, directive["Dimensions"])
+	equals(t, []interface{}{map[string]interface{}{"Name": "BytesUploaded", "Unit": "Bytes"}}, directive["Metrics"])
+}
+
+func TestEMFWritesOneLinePerMetric(t *testing.T) {
+	var buf bytes.Buffer
+	e := &EMF{Namespace: "Blog", Out: &buf}
+
+	e.Record(EmailsSent, 1, Count, nil)
+	e.Record(SMSSent, 1, Count, nil)
+
+	equals(t, 2, bytes.Count(buf.Bytes(), []byte("\n")))
+}
+
+func TestMemorySumsAndCounts(t *testing.T) {
+	m := &Memory{}
+	m.Record(BytesDownloaded, 10, Bytes, nil)
+	m.Record(BytesDownloaded, 5, Bytes, nil)
+	Since(m, "GetObject", time.Now())
+
+	equals(t, float64(15), m.Sum(BytesDownloaded))
+	equals(t, 1, m.Count(Latency))
+	equals(t, "GetObject", m.Records[2].Dimensions["Operation"])
+}
@@ -1,40 +1,110 @@
 package notification
 
 import (
+	"context"
 	"errors"
+	"regexp"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/aws/aws-sdk-go/service/sns/snsiface"
-	log "github.com/sirupsen/logrus"
+	"github.com/cstdev/lambdahelpers/pkg/awserror"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	"github.com/cstdev/lambdahelpers/pkg/metrics"
+	"github.com/cstdev/lambdahelpers/pkg/retry"
+	"github.com/cstdev/lambdahelpers/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var (
+	// ErrEmptyMessage is returned when there is no message to send.
+	ErrEmptyMessage = errors.New("Missing message")
+	// ErrInvalidPhoneNumber is returned when the number is missing or not in E.164 format.
+	ErrInvalidPhoneNumber = errors.New("Missing or invalid phone number")
+)
+
+// e164 matches phone numbers in the international format SNS expects, such as +447700900123.
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
 // SMS contains the AWS SNS client used to send the messages with
 type SMS struct {
 	Client snsiface.SNSAPI
+	// Retry is the policy for SNS calls, a single attempt is made when nil.
+	Retry *retry.Policy
+	// Logger receives the client's logs, logging.Default() when nil.
+	Logger logging.Logger
+	// Metrics receives sent and failed message counts and latency, nothing is recorded when nil.
+	Metrics metrics.Sink
+	// Tracer starts the spans around SNS calls, the global OpenTelemetry tracer when nil.
+	Tracer trace.Tracer
+
+	ctx context.Context
+}
+
+// WithContext returns a copy of the client whose calls are cancelled along with
+// ctx and whose spans are children of the span in ctx.
+func (s *SMS) WithContext(ctx context.Context) *SMS {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+func (s *SMS) log() logging.Logger {
+	return logging.Or(s.Logger)
 }
 
 // SendMessage sends the provided message to the provided number
 func (s *SMS) SendMessage(message string, number string) error {
-	log.Info("Sending message")
-	if message == "" || number == "" {
-		log.WithFields(log.Fields{
+	ctx, span := tracing.Start(s.ctx, s.Tracer, "sns:Publish")
+	messageID, err := s.sendMessage(ctx, message, number)
+	if messageID != "" {
+		span.SetAttributes(tracing.MessageID.String(messageID))
+	}
+	tracing.End(span, err)
+	if err != nil {
+		metrics.Or(s.Metrics).Record(metrics.SMSFailed, 1, metrics.Count, nil)
+		return err
+	}
+	metrics.Or(s.Metrics).Record(metrics.SMSSent, 1, metrics.Count, nil)
+	return nil
+}
+
+func (s *SMS) sendMessage(ctx context.Context, message string, number string) (string, error) {
+	s.log().Info("Sending message")
+	if message == "" {
+		s.log().WithFields(logging.Fields{
+			"message": message,
+			"number":  number,
+		}).Error("Missing message")
+		return "", ErrEmptyMessage
+	}
+	if !e164.MatchString(number) {
+		s.log().WithFields(logging.Fields{
 			"message": message,
 			"number":  number,
-		}).Error("Missing message or phone number")
-		return errors.New("Missing message or phone number")
+		}).Error("Missing or invalid phone number")
+		return "", ErrInvalidPhoneNumber
 	}
 	messageParams := &sns.PublishInput{
 		Message:     aws.String(message),
 		PhoneNumber: aws.String(number),
 	}
-	resp, err := s.Client.Publish(messageParams)
-	if err != nil {
-		log.Error("Failed to send text message")
+	var resp *sns.PublishOutput
+	start := time.Now()
+	err := s.Retry.DoWithLogger(s.log(), "sns:Publish", func() (err error) {
+		resp, err = s.Client.PublishWithContext(ctx, messageParams)
 		return err
+	})
+	metrics.Since(s.Metrics, "sns:Publish", start)
+	if err != nil {
+		s.log().Error("Failed to send text message")
+		return "", awserror.Wrap("sns:Publish", err)
 	}
 
-	log.Debug(resp)
+	s.log().WithFields(logging.Fields{
+		"messageId": aws.StringValue(resp.MessageId),
+	}).Debug("Sent message")
 
-	return nil
+	return aws.StringValue(resp.MessageId), nil
 }
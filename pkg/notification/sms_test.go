@@ -1,15 +1,29 @@
 package notification
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/cstdev/lambdahelpers/pkg/awserror"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	"github.com/cstdev/lambdahelpers/pkg/metrics"
+	"github.com/cstdev/lambdahelpers/pkg/retry"
+	"github.com/cstdev/lambdahelpers/pkg/tracing"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestMain(m *testing.M) {
@@ -43,7 +57,7 @@ type mockSMSAPI struct {
 	PublishFunc func(*sns.PublishInput) (*sns.PublishOutput, error)
 }
 
-func (s *mockSMSAPI) Publish(i *sns.PublishInput) (*sns.PublishOutput, error) {
+func (s *mockSMSAPI) PublishWithContext(_ aws.Context, i *sns.PublishInput, _ ...request.Option) (*sns.PublishOutput, error) {
 	return s.PublishFunc(i)
 }
 
@@ -68,3 +82,126 @@ func TestPublishIsCalledWithMessageTextAndNumber(t *testing.T) {
 	equals(t, expectedMessage, message)
 	equals(t, expectedNumber, number)
 }
+
+func TestSendMessageReturnsValidationErrors(t *testing.T) {
+	s := SMS{
+		Client: &mockSMSAPI{
+			PublishFunc: func(i *sns.PublishInput) (*sns.PublishOutput, error) {
+				t.Error("Expected Publish not to be called")
+				return &sns.PublishOutput{}, nil
+			},
+		},
+	}
+
+	tests := []struct {
+		message  string
+		number   string
+		expected error
+	}{
+		{"", "+12345678910", ErrEmptyMessage},
+		{"Hello", "", ErrInvalidPhoneNumber},
+		{"Hello", "07700900123", ErrInvalidPhoneNumber},
+		{"Hello", "+44 7700 900123", ErrInvalidPhoneNumber},
+	}
+
+	for _, test := range tests {
+		err := s.SendMessage(test.message, test.number)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("Expected %v for %q, received: %v", test.expected, test.number, err)
+		}
+	}
+}
+
+type noSleepClock struct{}
+
+func (noSleepClock) Now() time.Time        { return time.Time{} }
+func (noSleepClock) Sleep(d time.Duration) {}
+
+func TestSendMessageGivesUpAfterPolicyAttempts(t *testing.T) {
+	calls := 0
+	s := SMS{
+		Client: &mockSMSAPI{
+			PublishFunc: func(i *sns.PublishInput) (*sns.PublishOutput, error) {
+				calls++
+				return nil, awserr.New("ThrottledException", "Rate exceeded", nil)
+			},
+		},
+		Retry: &retry.Policy{MaxAttempts: 2, Clock: noSleepClock{}},
+	}
+
+	err := s.SendMessage("Hello", "+12345678910")
+	if awserror.Code(err) != "ThrottledException" {
+		t.Errorf("Expected throttling error, received: %v", err)
+	}
+	equals(t, 2, calls)
+}
+
+type captureLogger struct {
+	fields logging.Fields
+	out    *[]logging.Fields
+}
+
+func (c captureLogger) WithFields(f logging.Fields) logging.Logger {
+	return captureLogger{fields: f, out: c.out}
+}
+func (c captureLogger) Debug(string) { *c.out = append(*c.out, c.fields) }
+func (c captureLogger) Info(string)  { *c.out = append(*c.out, c.fields) }
+func (c captureLogger) Warn(string)  { *c.out = append(*c.out, c.fields) }
+func (c captureLogger) Error(string) { *c.out = append(*c.out, c.fields) }
+
+func TestSendMessageRedactsMessageAndNumberInLogs(t *testing.T) {
+	var logged []logging.Fields
+	s := SMS{
+		Logger: captureLogger{out: &logged},
+	}
+
+	s.SendMessage("Your secret code", "07700900123")
+	s.SendMessage("", "+447700900123")
+
+	for _, fields := range logged {
+		for _, v := range fields {
+			if str, ok := v.(string); ok && (strings.Contains(str, "secret") || strings.Contains(str, "7700900")) {
+				t.Errorf("Expected PII to be redacted, logged: %v", fields)
+			}
+		}
+	}
+}
+
+func TestSendMessageRecordsSentAndFailedMetrics(t *testing.T) {
+	sink := &metrics.Memory{}
+	s := SMS{
+		Client: &mockSMSAPI{
+			PublishFunc: func(i *sns.PublishInput) (*sns.PublishOutput, error) {
+				return &sns.PublishOutput{}, nil
+			},
+		},
+		Metrics: sink,
+	}
+
+	ok(t, s.SendMessage("Hello", "+12345678910"))
+	s.SendMessage("Hello", "not a number")
+
+	equals(t, float64(1), sink.Sum(metrics.SMSSent))
+	equals(t, float64(1), sink.Sum(metrics.SMSFailed))
+	equals(t, 1, sink.Count(metrics.Latency))
+}
+
+func TestSendMessageRecordsSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	s := SMS{
+		Client: &mockSMSAPI{
+			PublishFunc: func(i *sns.PublishInput) (*sns.PublishOutput, error) {
+				return &sns.PublishOutput{MessageId: aws.String("msg-1")}, nil
+			},
+		},
+		Tracer: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test"),
+	}
+
+	ok(t, s.SendMessage("Hello", "+12345678910"))
+	s.SendMessage("", "+12345678910")
+
+	spans := exporter.GetSpans()
+	equals(t, tracing.MessageID.String("msg-1"), spans[0].Attributes[0])
+	equals(t, codes.Error, spans[1].Status.Code)
+	equals(t, ErrEmptyMessage.Error(), spans[1].Status.Description)
+}
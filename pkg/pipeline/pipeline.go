@@ -0,0 +1,534 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	"github.com/cstdev/lambdahelpers/pkg/mail"
+	"github.com/cstdev/lambdahelpers/pkg/notification"
+	"github.com/cstdev/lambdahelpers/pkg/post"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+)
+
+// DefaultWorkDir is where the site source is downloaded when Pipeline.WorkDir is empty.
+const DefaultWorkDir = "/tmp/site/"
+
+// DefaultRejectedPrefix is where rejected mail is moved when Pipeline.RejectedPrefix is empty.
+const DefaultRejectedPrefix = "rejected/"
+
+// DefaultLeaseTTL is how long a run holds its mail when Pipeline.LeaseTTL is zero,
+// the longest a Lambda function can run.
+const DefaultLeaseTTL = 15 * time.Minute
+
+// PublishedTag is set on mail in the inbox to the slug of its post once the
+// post is uploaded. A run retrying mail whose later steps failed writes the
+// post under that slug again, rather than publishing it a second time under
+// a new slug.
+const PublishedTag = "published"
+
+// ErrRejected wraps the error from a step that failed because of the mail
+// itself, such as mail that can't be parsed, isn't allowed by the policy or
+// can't be made into a post. Rejected mail is moved under the rejected prefix
+// so it doesn't hold up the mail behind it.
+var ErrRejected = errors.New("Mail rejected")
+
+// errSkipped is returned by a step that has nothing to do because an earlier step didn't produce its input.
+var errSkipped = errors.New("Step skipped")
+
+// Step names a stage of the pipeline.
+type Step string
+
+// Steps in the order Run takes them.
+const (
+	StepRead       Step = "read"
+	StepParse      Step = "parse"
+	StepConvert    Step = "convert"
+	StepUploadPost Step = "upload-post"
+	StepDownload   Step = "download"
+	StepBuild      Step = "build"
+	StepUploadSite Step = "upload-site"
+	StepDelete     Step = "delete"
+	StepNotify     Step = "notify"
+)
+
+// Steps lists every step in the order they run.
+var Steps = []Step{
+	StepRead, StepParse, StepConvert, StepUploadPost, StepDownload,
+	StepBuild, StepUploadSite, StepDelete, StepNotify,
+}
+
+// ErrorAction decides what happens after a step fails.
+type ErrorAction int
+
+const (
+	// Abort stops the run, only notifying. This is the default.
+	Abort ErrorAction = iota
+	// Continue records the failure and carries on. Steps needing the failed
+	// step's output are skipped, and the incoming mail is not deleted.
+	Continue
+)
+
+// Builder turns the site source in sourceDir into the site to upload.
+// It returns the directory the site was written to.
+type Builder interface {
+	Build(ctx context.Context, sourceDir string) (outputDir string, err error)
+}
+
+// BuilderFunc adapts a function to a Builder.
+type BuilderFunc func(ctx context.Context, sourceDir string) (string, error)
+
+// Build calls f.
+func (f BuilderFunc) Build(ctx context.Context, sourceDir string) (string, error) {
+	return f(ctx, sourceDir)
+}
+
+// State is what the steps have produced so far. Each step reads the
+// output of the steps before it.
+type State struct {
+	// Key is the incoming mail and Lease the claim on it, set by StepRead.
+	Key   string
+	Lease *storage.Lease
+	// Rejected is set once a step fails with ErrRejected.
+	Rejected bool
+	// Message is set by StepParse.
+	Message *mail.Message
+	// Post is set by StepConvert.
+	Post *post.Post
+	// SourceDir is set by StepDownload, OutputDir by StepBuild.
+	SourceDir string
+	OutputDir string
+	// Report is set by StepUploadSite.
+	Report *storage.UploadReport
+}
+
+// Hooks are called around every step.
+type Hooks struct {
+	// Before runs ahead of the step. Returning an error fails the step without running it.
+	Before func(ctx context.Context, step Step, state *State) error
+	// After runs once the step is done with the error it returned, if any.
+	// Whatever it returns replaces that error, so nil lets the run carry on.
+	After func(ctx context.Context, step Step, state *State, err error) error
+}
+
+// StepResult is how a single step went.
+type StepResult struct {
+	Step     Step
+	Duration time.Duration
+	Skipped  bool
+	Err      error
+}
+
+// Summary describes a run.
+type Summary struct {
+	Key      string
+	Slug     string
+	Steps    []StepResult
+	Duration time.Duration
+	// Err is the failure that aborted the run, if any.
+	Err error
+}
+
+// Failed returns the steps that returned an error.
+func (s *Summary) Failed() []StepResult {
+	var failed []StepResult
+	for _, result := range s.Steps {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Pipeline publishes mail delivered to a bucket as a post on a static site.
+// Steps whose component is nil are skipped: without a Builder nothing is
+// downloaded, built or uploaded, and without SMS nobody is notified.
+type Pipeline struct {
+	// Inbox holds incoming mail, the first object in it no other run has
+	// claimed is published.
+	Inbox *storage.Bucket
+	// Owner names this run in the lease on its mail, a random ID when empty.
+	Owner string
+	// LeaseTTL is how long the mail is held, DefaultLeaseTTL when zero.
+	LeaseTTL time.Duration
+	// RejectedPrefix is where rejected mail is moved in the inbox,
+	// DefaultRejectedPrefix when empty.
+	RejectedPrefix string
+	// Source holds the site source the post is added to.
+	Source *storage.Bucket
+	// Site is where the built site is uploaded.
+	Site *storage.Bucket
+
+	// Policy, when set, rejects mail it doesn't accept during StepParse.
+	Policy *mail.Policy
+	// Receipts are the SES receipts for mail in the inbox by object key, such
+	// as from the event the function was invoked with. The policy uses a
+	// receipt's verdicts. Mail without one has no verdicts, failing every
+	// check the policy requires, unless TrustHeaderVerdicts is set.
+	Receipts map[string]mail.Receipt
+	// TrustHeaderVerdicts has the policy use the verdicts in the headers of
+	// mail without a receipt, read by mail.HeaderVerdicts under AuthServID.
+	// Only set it when SES scans the inbox's mail, as without scanning the
+	// sender can write the X-SES verdict headers themselves.
+	TrustHeaderVerdicts bool
+	// AuthServID is passed to mail.HeaderVerdicts, mail.DefaultAuthServID when empty.
+	AuthServID string
+	// Converter turns the message into a post, the zero Converter when nil.
+	Converter *post.Converter
+	Builder   Builder
+	// WorkDir is where the site source is downloaded, DefaultWorkDir when empty.
+	WorkDir string
+
+	SMS *notification.SMS
+	// NotifyNumber is texted a summary at the end of every run.
+	NotifyNumber string
+	// NotifyMessage writes the text, DefaultNotifyMessage when nil.
+	NotifyMessage func(*Summary) string
+
+	Hooks Hooks
+	// OnError sets the action for a failing step, Abort for steps not listed.
+	OnError map[Step]ErrorAction
+	// Logger receives the pipeline's logs, logging.Default() when nil.
+	Logger logging.Logger
+}
+
+func (p *Pipeline) log() logging.Logger {
+	return logging.Or(p.Logger)
+}
+
+func (p *Pipeline) rejectedPrefix() string {
+	if p.RejectedPrefix == "" {
+		return DefaultRejectedPrefix
+	}
+	return p.RejectedPrefix
+}
+
+// Run claims the first unclaimed mail in the inbox and takes it through
+// every step. It always returns a summary; the error is the one that aborted
+// the run, if any. When there is no mail to claim it returns
+// storage.ErrNoUnclaimedObjects without notifying anyone.
+//
+// Published mail is deleted and rejected mail moved under RejectedPrefix,
+// even when the run is aborted. Mail that failed for any other reason stays
+// in the inbox to be tried again, and the lease on it is released.
+func (p *Pipeline) Run(ctx context.Context) (*Summary, error) {
+	start := time.Now()
+	state := &State{}
+	summary := &Summary{}
+	failed := false
+	defer p.release(ctx, state)
+
+	for _, step := range Steps {
+		skip := (summary.Err != nil && step != StepNotify) || (step == StepDelete && failed)
+		if step == StepDelete && state.Rejected {
+			// Rejected mail is moved even after an abort so it doesn't block the inbox.
+			skip = false
+		}
+		if skip {
+			summary.Steps = append(summary.Steps, StepResult{Step: step, Skipped: true})
+			continue
+		}
+		if step == StepNotify {
+			summary.Key = state.Key
+			if state.Post != nil {
+				summary.Slug = state.Post.Slug
+			}
+			summary.Duration = time.Since(start)
+		}
+
+		result := p.runStep(ctx, step, state, summary)
+		summary.Steps = append(summary.Steps, result)
+		if result.Err == nil {
+			continue
+		}
+
+		failed = true
+		if errors.Is(result.Err, ErrRejected) {
+			state.Rejected = true
+		}
+		if step == StepRead && errors.Is(result.Err, storage.ErrNoUnclaimedObjects) {
+			summary.Err = result.Err
+			break
+		}
+		if p.OnError[step] == Abort && summary.Err == nil {
+			summary.Err = fmt.Errorf("Step %s failed: %w", step, result.Err)
+		}
+	}
+
+	summary.Duration = time.Since(start)
+	return summary, summary.Err
+}
+
+func (p *Pipeline) runStep(ctx context.Context, step Step, state *State, summary *Summary) StepResult {
+	start := time.Now()
+	result := StepResult{Step: step}
+
+	var err error
+	if p.Hooks.Before != nil {
+		err = p.Hooks.Before(ctx, step, state)
+	}
+	if err == nil {
+		err = p.step(ctx, step, state, summary)
+	}
+	if errors.Is(err, errSkipped) {
+		result.Skipped = true
+		err = nil
+	}
+	if p.Hooks.After != nil {
+		err = p.Hooks.After(ctx, step, state, err)
+	}
+	result.Err = err
+	result.Duration = time.Since(start)
+
+	entry := p.log().WithFields(logging.Fields{
+		"step":     string(step),
+		"duration": result.Duration.String(),
+		"skipped":  result.Skipped,
+	})
+	if err != nil {
+		entry.WithFields(logging.Fields{"error": err}).Error("Pipeline step failed")
+	} else {
+		entry.Info("Pipeline step done")
+	}
+	return result
+}
+
+func (p *Pipeline) step(ctx context.Context, step Step, state *State, summary *Summary) error {
+	switch step {
+	case StepRead:
+		if p.Inbox == nil {
+			return errors.New("Pipeline has no inbox bucket")
+		}
+		ttl := p.LeaseTTL
+		if ttl <= 0 {
+			ttl = DefaultLeaseTTL
+		}
+		lease, err := p.Inbox.WithContext(ctx).ClaimNext(p.owner(), ttl, p.rejectedPrefix())
+		if err != nil {
+			return err
+		}
+		state.Key, state.Lease = lease.Key, lease
+		return nil
+
+	case StepParse:
+		if state.Key == "" {
+			return errSkipped
+		}
+		p.log().WithFields(logging.Fields{
+			"key": state.Key,
+		}).Debug("Parsing message")
+		body, _, err := p.Inbox.WithContext(ctx).Open(state.Key)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		message, err := mail.Parse(body)
+		if err != nil {
+			return reject(err)
+		}
+		if p.Policy != nil {
+			var verdicts mail.Verdicts
+			if receipt, ok := p.Receipts[state.Key]; ok {
+				verdicts = receipt.Verdicts()
+			} else if p.TrustHeaderVerdicts {
+				verdicts = mail.HeaderVerdicts(message.Header, p.AuthServID)
+			}
+			if err := p.Policy.Evaluate(message, verdicts).Err(); err != nil {
+				return reject(err)
+			}
+		}
+		state.Message = &message
+		return nil
+
+	case StepConvert:
+		if state.Message == nil {
+			return errSkipped
+		}
+		converter := p.Converter
+		if converter == nil {
+			converter = &post.Converter{}
+		}
+		published, err := p.published(ctx, state.Key)
+		if err != nil {
+			return err
+		}
+		if published != "" {
+			retry := *converter
+			retry.Slug = func(mail.Message) string { return published }
+			retry.Slugs = nil
+			converter = &retry
+		}
+		converted, err := converter.Convert(*state.Message)
+		if err != nil {
+			return reject(err)
+		}
+		state.Post = converted
+		return nil
+
+	case StepUploadPost:
+		if state.Post == nil || p.Source == nil {
+			return errSkipped
+		}
+		if err := state.Post.Upload(p.Source.WithContext(ctx)); err != nil {
+			return err
+		}
+		return p.markPublished(ctx, state.Key, state.Post.Slug)
+
+	case StepDownload:
+		if p.Builder == nil || p.Source == nil {
+			return errSkipped
+		}
+		dir := p.WorkDir
+		if dir == "" {
+			dir = DefaultWorkDir
+		}
+		if err := p.Source.WithContext(ctx).DownloadAllObjectsInBucket(dir); err != nil {
+			return err
+		}
+		state.SourceDir = dir
+		return nil
+
+	case StepBuild:
+		if p.Builder == nil || state.SourceDir == "" {
+			return errSkipped
+		}
+		outputDir, err := p.Builder.Build(ctx, state.SourceDir)
+		if err != nil {
+			return err
+		}
+		state.OutputDir = outputDir
+		return nil
+
+	case StepUploadSite:
+		if state.OutputDir == "" || p.Site == nil {
+			return errSkipped
+		}
+		report, err := p.Site.WithContext(ctx).Upload(state.OutputDir)
+		state.Report = report
+		return err
+
+	case StepDelete:
+		if state.Key == "" {
+			return errSkipped
+		}
+		if state.Rejected {
+			return p.moveToRejected(ctx, state.Key)
+		}
+		return p.Inbox.WithContext(ctx).DeleteObject(state.Key)
+
+	case StepNotify:
+		if p.SMS == nil || p.NotifyNumber == "" {
+			return errSkipped
+		}
+		message := DefaultNotifyMessage
+		if p.NotifyMessage != nil {
+			message = p.NotifyMessage
+		}
+		return p.SMS.WithContext(ctx).SendMessage(message(summary), p.NotifyNumber)
+	}
+	return fmt.Errorf("Unknown pipeline step %s", step)
+}
+
+// reject marks err as caused by the mail itself.
+func reject(err error) error {
+	return fmt.Errorf("%w: %w", ErrRejected, err)
+}
+
+// moveToRejected copies the mail under the rejected prefix and deletes it from the inbox.
+func (p *Pipeline) moveToRejected(ctx context.Context, key string) error {
+	inbox := p.Inbox.WithContext(ctx)
+	body, _, err := inbox.Open(key)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return fmt.Errorf("Reading %s: %w", key, err)
+	}
+	rejectedKey := p.rejectedPrefix() + strings.TrimPrefix(key, "/")
+	if err := inbox.UploadObject(rejectedKey, data, "message/rfc822"); err != nil {
+		return err
+	}
+	p.log().WithFields(logging.Fields{
+		"key":         key,
+		"rejectedKey": rejectedKey,
+	}).Warn("Moved rejected mail")
+	return inbox.DeleteObject(key)
+}
+
+// published returns the slug an earlier run published the mail under, if any.
+func (p *Pipeline) published(ctx context.Context, key string) (string, error) {
+	if p.Inbox == nil || key == "" {
+		return "", nil
+	}
+	tags, err := p.Inbox.WithContext(ctx).ObjectTags(key)
+	if err != nil {
+		return "", err
+	}
+	return tags[PublishedTag], nil
+}
+
+// markPublished tags the mail with the slug its post was uploaded under,
+// keeping any other tags on it.
+func (p *Pipeline) markPublished(ctx context.Context, key, slug string) error {
+	if p.Inbox == nil || key == "" {
+		return nil
+	}
+	inbox := p.Inbox.WithContext(ctx)
+	tags, err := inbox.ObjectTags(key)
+	if err != nil {
+		return err
+	}
+	tags[PublishedTag] = slug
+	return inbox.PutObjectTags(key, tags)
+}
+
+// release gives up the lease on the mail so a later run can pick it up
+// straight away if it is still in the inbox.
+func (p *Pipeline) release(ctx context.Context, state *State) {
+	if state.Lease == nil {
+		return
+	}
+	if err := p.Inbox.WithContext(ctx).ReleaseLease(state.Lease); err != nil {
+		p.log().WithFields(logging.Fields{
+			"key":   state.Key,
+			"error": err,
+		}).Warn("Failed to release lease on mail")
+	}
+}
+
+// owner returns the name this run claims mail under.
+func (p *Pipeline) owner() string {
+	if p.Owner != "" {
+		return p.Owner
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("pipeline-%d", time.Now().UnixNano())
+	}
+	return "pipeline-" + hex.EncodeToString(id)
+}
+
+// DefaultNotifyMessage says what was published, or which steps failed.
+func DefaultNotifyMessage(s *Summary) string {
+	failed := s.Failed()
+	if len(failed) == 0 {
+		if s.Slug == "" {
+			return "Nothing was published"
+		}
+		return fmt.Sprintf("Published %s", s.Slug)
+	}
+
+	var steps []string
+	for _, result := range failed {
+		steps = append(steps, string(result.Step))
+	}
+	return fmt.Sprintf("Publishing %s failed at %s", s.Key, strings.Join(steps, ", "))
+}
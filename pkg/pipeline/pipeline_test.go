@@ -0,0 +1,442 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/cstdev/lambdahelpers/pkg/mail"
+	"github.com/cstdev/lambdahelpers/pkg/notification"
+	"github.com/cstdev/lambdahelpers/pkg/post"
+	"github.com/cstdev/lambdahelpers/pkg/s3/manager"
+	"github.com/cstdev/lambdahelpers/pkg/slug"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.DebugLevel)
+	log.SetFormatter(&log.JSONFormatter{})
+	retCode := m.Run()
+	os.Exit(retCode)
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		log.WithFields(log.Fields{
+			"file":  filepath.Base(file),
+			"line":  line,
+			"error": err.Error(),
+		}).Error("unexpected error")
+		tb.FailNow()
+	}
+}
+
+func equals(tb testing.TB, expected, actual interface{}) {
+	if !reflect.DeepEqual(expected, actual) {
+		tb.Errorf("Expected: %v \n Actual: %v", expected, actual)
+	}
+}
+
+type mockedBucketAPI struct {
+	s3iface.S3API
+	manager.S3Manager
+	objects    map[string]string
+	tags       map[string]map[string]string
+	uploaded   *[]string
+	deleted    *[]string
+	failUpload error
+}
+
+func newBucket(name string, objects map[string]string) (*storage.Bucket, mockedBucketAPI) {
+	m := mockedBucketAPI{
+		objects:  objects,
+		tags:     map[string]map[string]string{},
+		uploaded: &[]string{},
+		deleted:  &[]string{},
+	}
+	return &storage.Bucket{Client: m, Manager: m, Name: name}, m
+}
+
+func (m mockedBucketAPI) ListObjectsV2WithContext(_ aws.Context, i *s3.ListObjectsV2Input, _ ...request.Option) (*s3.ListObjectsV2Output, error) {
+	out := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	for key := range m.objects {
+		out.Contents = append(out.Contents, &s3.Object{Key: aws.String(key)})
+	}
+	return out, nil
+}
+
+func (m mockedBucketAPI) GetObjectWithContext(_ aws.Context, i *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	body, exists := m.objects[*i.Key]
+	if !exists {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "missing", nil)
+	}
+	return &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewReader([]byte(body))),
+		ETag: aws.String("\"1\""),
+	}, nil
+}
+
+func (m mockedBucketAPI) HeadObjectWithContext(_ aws.Context, i *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	if _, exists := m.objects[*i.Key]; !exists {
+		return nil, awserr.New("NotFound", "missing", nil)
+	}
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func (m mockedBucketAPI) GetObjectTaggingWithContext(_ aws.Context, i *s3.GetObjectTaggingInput, _ ...request.Option) (*s3.GetObjectTaggingOutput, error) {
+	out := &s3.GetObjectTaggingOutput{}
+	for key, value := range m.tags[*i.Key] {
+		out.TagSet = append(out.TagSet, &s3.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return out, nil
+}
+
+func (m mockedBucketAPI) PutObjectTaggingWithContext(_ aws.Context, i *s3.PutObjectTaggingInput, _ ...request.Option) (*s3.PutObjectTaggingOutput, error) {
+	tags := map[string]string{}
+	for _, tag := range i.Tagging.TagSet {
+		tags[*tag.Key] = *tag.Value
+	}
+	m.tags[*i.Key] = tags
+	return &s3.PutObjectTaggingOutput{}, nil
+}
+
+// PutObjectWithContext writes leases, honouring If-None-Match.
+func (m mockedBucketAPI) PutObjectWithContext(_ aws.Context, i *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	r := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if _, exists := m.objects[*i.Key]; exists && r.HTTPRequest.Header.Get("If-None-Match") == "*" {
+		return nil, awserr.New("PreconditionFailed", "exists", nil)
+	}
+	body, _ := ioutil.ReadAll(i.Body)
+	m.objects[*i.Key] = string(body)
+	return &s3.PutObjectOutput{ETag: aws.String("\"1\"")}, nil
+}
+
+// DeleteObjectWithContext records the mail deleted, leaving out released leases.
+func (m mockedBucketAPI) DeleteObjectWithContext(_ aws.Context, i *s3.DeleteObjectInput, _ ...request.Option) (*s3.DeleteObjectOutput, error) {
+	if !strings.HasPrefix(*i.Key, storage.DefaultLeasePrefix) {
+		*m.deleted = append(*m.deleted, *i.Key)
+	}
+	delete(m.objects, *i.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m mockedBucketAPI) WaitUntilObjectNotExistsWithContext(_ aws.Context, i *s3.HeadObjectInput, _ ...request.WaiterOption) error {
+	return nil
+}
+
+func (m mockedBucketAPI) UploadWithContext(_ aws.Context, i *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	if m.failUpload != nil {
+		return nil, m.failUpload
+	}
+	*m.uploaded = append(*m.uploaded, *i.Key)
+	return &s3manager.UploadOutput{}, nil
+}
+
+func (m mockedBucketAPI) DownloadWithContext(_ aws.Context, w io.WriterAt, i *s3.GetObjectInput, options ...func(*s3manager.Downloader)) (int64, error) {
+	n, err := w.WriteAt([]byte(m.objects[*i.Key]), 0)
+	return int64(n), err
+}
+
+type mockSMSAPI struct {
+	snsiface.SNSAPI
+	sent *[]string
+}
+
+func (s mockSMSAPI) PublishWithContext(_ aws.Context, i *sns.PublishInput, _ ...request.Option) (*sns.PublishOutput, error) {
+	*s.sent = append(*s.sent, *i.Message)
+	return &sns.PublishOutput{MessageId: aws.String("msg-1")}, nil
+}
+
+const rawMail = "From: Jane Doe <jane@example.com>\r\n" +
+	"Subject: My first post\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Hello"
+
+type fixture struct {
+	pipeline *Pipeline
+	inbox    mockedBucketAPI
+	source   mockedBucketAPI
+	site     mockedBucketAPI
+	sent     *[]string
+}
+
+func newFixture(raw string) fixture {
+	inbox, inboxAPI := newBucket("inbox", map[string]string{"incoming/1": raw})
+	source, sourceAPI := newBucket("source", map[string]string{"config.toml": "title = \"Blog\""})
+	site, siteAPI := newBucket("site", nil)
+	sent := &[]string{}
+	return fixture{
+		pipeline: &Pipeline{
+			Inbox:        inbox,
+			Source:       source,
+			Site:         site,
+			SMS:          &notification.SMS{Client: mockSMSAPI{sent: sent}},
+			NotifyNumber: "+447700900123",
+		},
+		inbox:  inboxAPI,
+		source: sourceAPI,
+		site:   siteAPI,
+		sent:   sent,
+	}
+}
+
+func steps(summary *Summary) map[Step]StepResult {
+	results := make(map[Step]StepResult)
+	for _, result := range summary.Steps {
+		results[result.Step] = result
+	}
+	return results
+}
+
+func TestRunPublishesPostDeletesMailAndNotifies(t *testing.T) {
+	f := newFixture(rawMail)
+
+	summary, err := f.pipeline.Run(context.Background())
+	ok(t, err)
+
+	equals(t, "incoming/1", summary.Key)
+	equals(t, "my-first-post", summary.Slug)
+	equals(t, []string{"/content/post/my-first-post.md"}, *f.source.uploaded)
+	equals(t, []string{"incoming/1"}, *f.inbox.deleted)
+	equals(t, []string{"Published my-first-post"}, *f.sent)
+	equals(t, len(Steps), len(summary.Steps))
+	equals(t, true, steps(summary)[StepBuild].Skipped)
+	_, leased := f.inbox.objects["leases/incoming/1"]
+	equals(t, false, leased)
+}
+
+func TestRunPublishesMailWithNonLatinSubject(t *testing.T) {
+	f := newFixture("Subject: =?UTF-8?B?5pel5pys6Kqe?=\r\nDate: Wed, 02 Jan 2019 15:04:05 +0000\r\nContent-Type: text/plain\r\n\r\nHello")
+
+	summary, err := f.pipeline.Run(context.Background())
+	ok(t, err)
+
+	equals(t, "2019-01-02-150405", summary.Slug)
+	equals(t, []string{"/content/post/2019-01-02-150405.md"}, *f.source.uploaded)
+	equals(t, []string{"incoming/1"}, *f.inbox.deleted)
+}
+
+func TestRunBuildsAndUploadsSite(t *testing.T) {
+	f := newFixture(rawMail)
+	workDir, err := ioutil.TempDir("", "pipeline")
+	ok(t, err)
+	defer os.RemoveAll(workDir)
+
+	var sourceFiles []string
+	f.pipeline.WorkDir = workDir
+	f.pipeline.Builder = BuilderFunc(func(ctx context.Context, sourceDir string) (string, error) {
+		files, _ := ioutil.ReadDir(sourceDir)
+		for _, file := range files {
+			sourceFiles = append(sourceFiles, file.Name())
+		}
+		public := filepath.Join(sourceDir, "public")
+		os.MkdirAll(public, 0775)
+		return public, ioutil.WriteFile(filepath.Join(public, "index.html"), []byte("<html>"), 0664)
+	})
+
+	_, err = f.pipeline.Run(context.Background())
+	ok(t, err)
+
+	equals(t, []string{"config.toml"}, sourceFiles)
+	equals(t, []string{"/index.html"}, *f.site.uploaded)
+}
+
+func TestRunMovesRejectedMailAndAborts(t *testing.T) {
+	f := newFixture("Subject: Hello\r\nContent-Type: text/plain\r\n\r\nHello")
+	f.pipeline.Converter = &post.Converter{Slug: func(mail.Message) string { return "" }}
+
+	summary, err := f.pipeline.Run(context.Background())
+
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("Expected ErrRejected, received: %v", err)
+	}
+	results := steps(summary)
+	equals(t, true, results[StepConvert].Err != nil)
+	equals(t, true, results[StepUploadPost].Skipped)
+	equals(t, false, results[StepDelete].Skipped)
+	equals(t, []string{"rejected/incoming/1"}, *f.inbox.uploaded)
+	equals(t, []string{"incoming/1"}, *f.inbox.deleted)
+	equals(t, []string{"Publishing incoming/1 failed at convert"}, *f.sent)
+}
+
+func TestRunUsesReceiptVerdictsOverHeaders(t *testing.T) {
+	f := newFixture(rawMail)
+	f.pipeline.Policy = &mail.Policy{AllowedSenders: []string{"example.com"}}
+
+	_, err := f.pipeline.Run(context.Background())
+	if !errors.Is(err, mail.ErrRejected) {
+		t.Fatalf("Expected mail without verdicts to be rejected, received: %v", err)
+	}
+
+	f = newFixture(rawMail)
+	f.pipeline.Policy = &mail.Policy{AllowedSenders: []string{"example.com"}}
+	pass := mail.ReceiptVerdict{Status: mail.VerdictPass}
+	f.pipeline.Receipts = map[string]mail.Receipt{"incoming/1": {
+		SPFVerdict: pass, DKIMVerdict: pass, DMARCVerdict: pass, SpamVerdict: pass, VirusVerdict: pass,
+	}}
+
+	summary, err := f.pipeline.Run(context.Background())
+	ok(t, err)
+	equals(t, "my-first-post", summary.Slug)
+}
+
+func TestRunOnlyTrustsHeaderVerdictsWhenConfigured(t *testing.T) {
+	raw := "X-SES-Spam-Verdict: PASS\r\n" +
+		"X-SES-Virus-Verdict: PASS\r\n" +
+		"Authentication-Results: amazonses.com; spf=pass; dkim=pass; dmarc=pass\r\n" +
+		rawMail
+
+	f := newFixture(raw)
+	f.pipeline.Policy = &mail.Policy{AllowedSenders: []string{"example.com"}}
+
+	_, err := f.pipeline.Run(context.Background())
+	if !errors.Is(err, mail.ErrRejected) {
+		t.Fatalf("Expected mail with only header verdicts to be rejected, received: %v", err)
+	}
+
+	f = newFixture(raw)
+	f.pipeline.Policy = &mail.Policy{AllowedSenders: []string{"example.com"}}
+	f.pipeline.TrustHeaderVerdicts = true
+
+	summary, err := f.pipeline.Run(context.Background())
+	ok(t, err)
+	equals(t, "my-first-post", summary.Slug)
+}
+
+func TestRunKeepsMailWhenPublishingFails(t *testing.T) {
+	f := newFixture(rawMail)
+	f.pipeline.Source.Manager = mockedBucketAPI{failUpload: errors.New("upload failed")}
+
+	summary, err := f.pipeline.Run(context.Background())
+
+	if err == nil || errors.Is(err, ErrRejected) {
+		t.Fatalf("Expected the run to fail without rejecting the mail, received: %v", err)
+	}
+	equals(t, true, steps(summary)[StepDelete].Skipped)
+	equals(t, 0, len(*f.inbox.uploaded))
+	equals(t, 0, len(*f.inbox.deleted))
+	_, leased := f.inbox.objects["leases/incoming/1"]
+	equals(t, false, leased)
+}
+
+func TestRunRetriesMailUnderTheSlugItWasPublishedUnder(t *testing.T) {
+	f := newFixture(rawMail)
+	workDir, err := ioutil.TempDir("", "pipeline")
+	ok(t, err)
+	defer os.RemoveAll(workDir)
+
+	f.pipeline.WorkDir = workDir
+	f.pipeline.Converter = &post.Converter{Slugs: &slug.Generator{Bucket: f.pipeline.Source}}
+	builds := 0
+	f.pipeline.Builder = BuilderFunc(func(ctx context.Context, sourceDir string) (string, error) {
+		builds++
+		if builds == 1 {
+			return "", errors.New("build failed")
+		}
+		return sourceDir, nil
+	})
+
+	_, err = f.pipeline.Run(context.Background())
+	if err == nil {
+		t.Fatal("Expected the first run to fail building the site")
+	}
+	equals(t, "my-first-post", f.inbox.tags["incoming/1"][PublishedTag])
+	equals(t, 0, len(*f.inbox.deleted))
+
+	summary, err := f.pipeline.Run(context.Background())
+	ok(t, err)
+
+	equals(t, "my-first-post", summary.Slug)
+	_, duplicated := f.source.objects[storage.PostKey("my-first-post-2")]
+	equals(t, false, duplicated)
+	equals(t, []string{"incoming/1"}, *f.inbox.deleted)
+}
+
+func TestRunLeavesMailClaimedByAnotherRun(t *testing.T) {
+	f := newFixture(rawMail)
+	f.inbox.objects["leases/incoming/1"] = `{"owner":"other","expires":"2999-01-01T00:00:00Z"}`
+
+	_, err := f.pipeline.Run(context.Background())
+
+	if !errors.Is(err, storage.ErrNoUnclaimedObjects) {
+		t.Errorf("Expected ErrNoUnclaimedObjects, received: %v", err)
+	}
+	equals(t, 0, len(*f.source.uploaded))
+	equals(t, 0, len(*f.inbox.deleted))
+	equals(t, 0, len(*f.sent))
+}
+
+func TestRunContinuesPastStepsConfiguredToContinue(t *testing.T) {
+	f := newFixture(rawMail)
+	f.pipeline.Source.Manager = mockedBucketAPI{failUpload: errors.New("upload failed")}
+	f.pipeline.OnError = map[Step]ErrorAction{StepUploadPost: Continue}
+
+	summary, err := f.pipeline.Run(context.Background())
+	ok(t, err)
+
+	equals(t, 1, len(summary.Failed()))
+	equals(t, StepUploadPost, summary.Failed()[0].Step)
+	equals(t, 0, len(*f.inbox.deleted))
+	equals(t, []string{"Publishing incoming/1 failed at upload-post"}, *f.sent)
+}
+
+func TestRunCallsHooksAroundSteps(t *testing.T) {
+	f := newFixture(rawMail)
+	f.pipeline.Source.Manager = mockedBucketAPI{failUpload: errors.New("upload failed")}
+	var before []Step
+	f.pipeline.Hooks = Hooks{
+		Before: func(ctx context.Context, step Step, state *State) error {
+			before = append(before, step)
+			return nil
+		},
+		After: func(ctx context.Context, step Step, state *State, err error) error {
+			if step == StepUploadPost {
+				return nil
+			}
+			return err
+		},
+	}
+
+	summary, err := f.pipeline.Run(context.Background())
+	ok(t, err)
+
+	equals(t, Steps, before)
+	equals(t, 0, len(summary.Failed()))
+	equals(t, []string{"incoming/1"}, *f.inbox.deleted)
+}
+
+func TestRunReturnsNoUnclaimedObjectsWithoutNotifying(t *testing.T) {
+	f := newFixture(rawMail)
+	f.pipeline.Inbox, _ = newBucket("inbox", map[string]string{})
+
+	_, err := f.pipeline.Run(context.Background())
+
+	if !errors.Is(err, storage.ErrNoUnclaimedObjects) {
+		t.Errorf("Expected ErrNoUnclaimedObjects, received: %v", err)
+	}
+	equals(t, 0, len(*f.sent))
+}
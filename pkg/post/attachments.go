@@ -0,0 +1,124 @@
+package post
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/cstdev/lambdahelpers/pkg/mail"
+)
+
+// DefaultAttachmentKeyPrefix is where attachments are uploaded when
+// Converter.AttachmentKeyPrefix is empty. Hugo copies static/ to the site root.
+const DefaultAttachmentKeyPrefix = "/static/attachments/"
+
+// DefaultAttachmentURLPrefix is where the site serves DefaultAttachmentKeyPrefix from.
+const DefaultAttachmentURLPrefix = "/attachments/"
+
+// Attachment is a file from the message along with where the post links to it.
+type Attachment struct {
+	mail.Attachment
+	Name string
+	Key  string
+	URL  string
+}
+
+// IsImage reports whether the attachment can be shown with an image link.
+func (a Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// cidReference matches src="cid:..." and similar attribute values in HTML.
+var cidReference = regexp.MustCompile(`(?i)(["'(])cid:([^"')]+)(["')])`)
+
+// unsafeName matches anything other than the characters kept in attachment names.
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// attachments names each file and places it in a folder for the post.
+func (c *Converter) attachments(slug string, files []mail.Attachment) []Attachment {
+	keyPrefix := c.AttachmentKeyPrefix
+	if keyPrefix == "" {
+		keyPrefix = DefaultAttachmentKeyPrefix
+	}
+	urlPrefix := c.AttachmentURLPrefix
+	if urlPrefix == "" {
+		urlPrefix = DefaultAttachmentURLPrefix
+	}
+
+	used := make(map[string]bool)
+	var attachments []Attachment
+	for i, file := range files {
+		name := uniqueName(attachmentName(file, i+1), used)
+		attachments = append(attachments, Attachment{
+			Attachment: file,
+			Name:       name,
+			Key:        strings.TrimSuffix(keyPrefix, "/") + "/" + slug + "/" + name,
+			URL:        strings.TrimSuffix(urlPrefix, "/") + "/" + slug + "/" + name,
+		})
+	}
+	return attachments
+}
+
+// attachmentName keeps the base of the sent filename with anything that
+// needs escaping in a URL replaced. Files without a name are numbered.
+func attachmentName(file mail.Attachment, n int) string {
+	name := path.Base(strings.Replace(file.Filename, "\\", "/", -1))
+	name = strings.Trim(unsafeName.ReplaceAllString(name, "-"), "-.")
+	if name != "" {
+		return name
+	}
+
+	name = fmt.Sprintf("attachment-%d", n)
+	if exts, err := mime.ExtensionsByType(file.ContentType); err == nil && len(exts) > 0 {
+		name += exts[0]
+	}
+	return name
+}
+
+func uniqueName(name string, used map[string]bool) string {
+	unique := name
+	ext := path.Ext(name)
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	used[unique] = true
+	return unique
+}
+
+// RewriteContentIDs replaces cid: references in an HTML body with the URLs
+// of the matching inline attachments. References without a match are left alone.
+func RewriteContentIDs(html string, attachments []Attachment) string {
+	urls := make(map[string]string)
+	for _, a := range attachments {
+		if a.Inline() {
+			urls[strings.Trim(a.ContentID, "<>")] = a.URL
+		}
+	}
+
+	return cidReference.ReplaceAllStringFunc(html, func(ref string) string {
+		parts := cidReference.FindStringSubmatch(ref)
+		url, ok := urls[parts[2]]
+		if !ok {
+			return ref
+		}
+		return parts[1] + url + parts[3]
+	})
+}
+
+// attachmentLinks lists the attachments the content doesn't already link to.
+func attachmentLinks(content string, attachments []Attachment) string {
+	var links []string
+	for _, a := range attachments {
+		if strings.Contains(content, a.URL) {
+			continue
+		}
+		if a.IsImage() {
+			links = append(links, fmt.Sprintf("![%s](%s)", a.Name, a.URL))
+		} else {
+			links = append(links, fmt.Sprintf("[%s](%s)", a.Name, a.URL))
+		}
+	}
+	return strings.Join(links, "\n\n")
+}
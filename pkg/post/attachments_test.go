@@ -0,0 +1,152 @@
+package post
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/cstdev/lambdahelpers/pkg/mail"
+	"github.com/cstdev/lambdahelpers/pkg/slug"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+)
+
+func messageWithAttachments() mail.Message {
+	m := testMessage()
+	m.HTMLBody = `<p>Look</p><img src="cid:photo1@example.com">`
+	m.Attachments = []mail.Attachment{
+		{Filename: `C:\Users\jane\My Trip (1).pdf`, ContentType: "application/pdf", Data: []byte("pdf")},
+		{Filename: "photo.jpg", ContentType: "image/jpeg", Data: []byte("jpg")},
+		{ContentType: "image/png", ContentID: "<photo1@example.com>", Data: []byte("png")},
+		{Filename: "photo.jpg", ContentType: "image/jpeg", Data: []byte("jpg2")},
+	}
+	return m
+}
+
+func TestConvertNamesAttachmentsInFolderForPost(t *testing.T) {
+	c := Converter{AttachmentKeyPrefix: "/static/files/", AttachmentURLPrefix: "/files"}
+
+	p, err := c.Convert(messageWithAttachments())
+	ok(t, err)
+
+	var keys, urls []string
+	for _, a := range p.Attachments {
+		keys = append(keys, a.Key)
+		urls = append(urls, a.URL)
+	}
+	equals(t, []string{
+		"/static/files/my-first-post/My-Trip-1-.pdf",
+		"/static/files/my-first-post/photo.jpg",
+		"/static/files/my-first-post/attachment-3.png",
+		"/static/files/my-first-post/photo-2.jpg",
+	}, keys)
+	equals(t, "/files/my-first-post/attachment-3.png", urls[2])
+}
+
+func TestConvertLinksAttachmentsFromContent(t *testing.T) {
+	c := Converter{}
+	m := messageWithAttachments()
+	m.HTMLBody = ""
+	m.Attachments = m.Attachments[:3]
+
+	p, err := c.Convert(m)
+	ok(t, err)
+
+	equals(t, "Hello world\n\n"+
+		"[My-Trip-1-.pdf](/attachments/my-first-post/My-Trip-1-.pdf)\n\n"+
+		"![photo.jpg](/attachments/my-first-post/photo.jpg)\n\n"+
+		"![attachment-3.png](/attachments/my-first-post/attachment-3.png)", p.Content)
+}
+
+func TestConvertUsesHTMLBodyWithInlineImagesInPlace(t *testing.T) {
+	c := Converter{}
+	m := messageWithAttachments()
+	m.Attachments = m.Attachments[1:3]
+
+	p, err := c.Convert(m)
+	ok(t, err)
+
+	equals(t, "Look\n\n"+
+		"![](/attachments/my-first-post/attachment-2.png)\n\n"+
+		"![photo.jpg](/attachments/my-first-post/photo.jpg)", p.Content)
+}
+
+func TestRewriteContentIDsUsesUploadedURLs(t *testing.T) {
+	c := Converter{}
+	m := messageWithAttachments()
+	attachments := c.attachments("post", m.Attachments)
+
+	html := RewriteContentIDs(m.HTMLBody+`<img src='cid:unknown'>`, attachments)
+
+	equals(t, `<p>Look</p><img src="/attachments/post/attachment-3.png"><img src='cid:unknown'>`, html)
+}
+
+func TestUploadWritesPostBeforeAttachments(t *testing.T) {
+	var keys []string
+	var types []string
+	b := &storage.Bucket{
+		Manager: mockedManager{
+			UploadFunc: func(i *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				keys = append(keys, *i.Key)
+				if i.ContentType != nil {
+					types = append(types, *i.ContentType)
+				}
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+	m := messageWithAttachments()
+	m.Attachments = m.Attachments[1:2]
+	c := Converter{}
+
+	p, err := c.Convert(m)
+	ok(t, err)
+	ok(t, p.Upload(b))
+
+	equals(t, []string{"/content/post/my-first-post.md", "/static/attachments/my-first-post/photo.jpg"}, keys)
+	equals(t, []string{"image/jpeg"}, types)
+}
+
+func TestUploadRenamesPostWhenSlugTakenSinceConvert(t *testing.T) {
+	var keys []string
+	b := &storage.Bucket{
+		Manager: mockedManager{
+			UploadFunc: func(i *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				u := &s3manager.Uploader{}
+				for _, option := range options {
+					option(u)
+				}
+				if len(u.RequestOptions) > 0 && *i.Key == "/content/post/my-first-post.md" {
+					return nil, awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+				}
+				keys = append(keys, *i.Key)
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+	c := Converter{
+		Slugs: &slug.Generator{
+			Bucket: &storage.Bucket{
+				Client: mockedS3API{
+					HeadObjectFunc: func(i *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+						return nil, awserr.New("NotFound", "Not Found", nil)
+					},
+				},
+				Name: "TestBucket",
+			},
+		},
+	}
+	m := messageWithAttachments()
+	m.Attachments = m.Attachments[1:2]
+
+	p, err := c.Convert(m)
+	ok(t, err)
+	equals(t, "my-first-post", p.Slug)
+	ok(t, p.Upload(b))
+
+	equals(t, "my-first-post-2", p.Slug)
+	equals(t, Field{"slug", "my-first-post-2"}, p.FrontMatter[3])
+	equals(t, []string{"/content/post/my-first-post-2.md", "/static/attachments/my-first-post-2/photo.jpg"}, keys)
+}
@@ -0,0 +1,93 @@
+package post
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeFrontMatter writes fields between the delimiters of the format.
+// Strings are always quoted, which keeps values such as "yes" or "1.0"
+// from being read back as other types.
+func writeFrontMatter(buf *bytes.Buffer, format Format, fields []Field) error {
+	delimiter, separator := "---", ": "
+	if format == TOML {
+		delimiter, separator = "+++", " = "
+	}
+
+	buf.WriteString(delimiter + "\n")
+	for _, field := range fields {
+		value, err := encodeValue(field.Value)
+		if err != nil {
+			return fmt.Errorf("Front matter field %s: %w", field.Name, err)
+		}
+		buf.WriteString(encodeKey(field.Name) + separator + value + "\n")
+	}
+	buf.WriteString(delimiter + "\n")
+	return nil
+}
+
+// encodeValue uses the syntax YAML flow values and TOML values have in common.
+func encodeValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return quote(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = quote(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]", nil
+	}
+	return "", fmt.Errorf("unsupported value type %T", v)
+}
+
+// encodeKey leaves bare keys alone and quotes anything else.
+func encodeKey(key string) string {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return quote(key)
+		}
+	}
+	return key
+}
+
+// quote writes a double quoted string using only escapes YAML and TOML share.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,257 @@
+package post
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cstdev/lambdahelpers/pkg/mail"
+	"github.com/cstdev/lambdahelpers/pkg/slug"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+)
+
+// ErrMissingSlug is returned when a post has nothing to name its file after.
+var ErrMissingSlug = errors.New("Post has no slug")
+
+// fallbackSlugLayout names posts whose subject makes no slug after their date and time.
+const fallbackSlugLayout = "2006-01-02-150405"
+
+// Format is the front matter syntax written at the top of a post.
+type Format int
+
+const (
+	// YAML front matter between --- lines. This is the default.
+	YAML Format = iota
+	// TOML front matter between +++ lines.
+	TOML
+)
+
+// Field is a single front matter entry.
+// Values may be strings, bools, numbers, time.Time or []string.
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// Mapping works out a front matter value from the message.
+// Returning nil leaves the field out of the post.
+type Mapping func(mail.Message) interface{}
+
+// Post is a Hugo markdown post.
+type Post struct {
+	Slug        string
+	FrontMatter []Field
+	Content     string
+	Format      Format
+	// Attachments are uploaded alongside the post by Upload.
+	Attachments []Attachment
+
+	// rename rebuilds the post under the next of the candidates when
+	// Converter.Slugs named it and another writer takes the slug first.
+	rename     func(slug string) *Post
+	candidates []string
+}
+
+// Converter turns emailed messages into posts.
+type Converter struct {
+	// Format is the front matter syntax, YAML when unset.
+	Format Format
+	// Draft marks posts as drafts so Hugo only builds them with --buildDrafts.
+	Draft bool
+	// Mappings add front matter fields or replace the defaults of the same name:
+	// title, date, author, slug and draft. A nil Mapping removes a default field.
+	Mappings map[string]Mapping
+	// Slug names the post, Slugify of the subject when nil. When nothing is
+	// left of the subject, such as one written in Japanese, the post is named
+	// after the message's date and time instead.
+	Slug func(mail.Message) string
+	// Slugs, when set, makes the slug unique among the posts in its bucket
+	// instead of using Slug, so a new post never replaces an old one.
+	Slugs *slug.Generator
+	// Now dates messages without a Date header, time.Now when nil.
+	Now func() time.Time
+	// AttachmentKeyPrefix is where attachments are uploaded in the bucket,
+	// DefaultAttachmentKeyPrefix when empty. Each post gets a folder named after its slug.
+	AttachmentKeyPrefix string
+	// AttachmentURLPrefix is the path the site serves AttachmentKeyPrefix from,
+	// DefaultAttachmentURLPrefix when empty.
+	AttachmentURLPrefix string
+}
+
+// Convert builds a post from the message. Fields are written in the order
+// title, date, author, slug, draft followed by any extra mappings by name.
+func (c *Converter) Convert(m mail.Message) (*Post, error) {
+	date := m.Date
+	if date.IsZero() {
+		now := time.Now
+		if c.Now != nil {
+			now = c.Now
+		}
+		date = now()
+	}
+
+	title := m.Subject
+	if Slugify(title) == "" {
+		title = date.Format(fallbackSlugLayout)
+	}
+	name := Slugify(title)
+	if c.Slug != nil {
+		name = c.Slug(m)
+	}
+	var candidates []string
+	if c.Slugs != nil {
+		var err error
+		name, err = c.Slugs.Unique(title, date)
+		if errors.Is(err, slug.ErrEmptySlug) {
+			return nil, ErrMissingSlug
+		}
+		if err != nil {
+			return nil, err
+		}
+		if candidates, err = c.Slugs.Candidates(title, date); err != nil {
+			return nil, err
+		}
+		for len(candidates) > 0 && candidates[0] != name {
+			candidates = candidates[1:]
+		}
+	}
+	if name == "" {
+		return nil, ErrMissingSlug
+	}
+
+	p := c.build(m, date, name)
+	if c.Slugs != nil && len(candidates) > 0 {
+		p.rename = func(name string) *Post { return c.build(m, date, name) }
+		p.candidates = candidates[1:]
+	}
+	return p, nil
+}
+
+// build makes the post for the message under the given slug.
+func (c *Converter) build(m mail.Message, date time.Time, name string) *Post {
+	defaults := []Field{
+		{"title", m.Subject},
+		{"date", date},
+		{"author", author(m)},
+		{"slug", name},
+		{"draft", c.Draft},
+	}
+
+	var fields []Field
+	for _, field := range defaults {
+		if mapping, ok := c.Mappings[field.Name]; ok {
+			if mapping == nil {
+				continue
+			}
+			field.Value = mapping(m)
+		}
+		if field.Value != nil && field.Value != "" {
+			fields = append(fields, field)
+		}
+	}
+
+	var extra []string
+	for name := range c.Mappings {
+		if !isDefault(name) && c.Mappings[name] != nil {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		if value := c.Mappings[name](m); value != nil {
+			fields = append(fields, Field{name, value})
+		}
+	}
+
+	attachments := c.attachments(name, m.Attachments)
+	m.HTMLBody = RewriteContentIDs(m.HTMLBody, attachments)
+
+	content := m.Markdown()
+	if links := attachmentLinks(content, attachments); links != "" {
+		content = strings.TrimRight(content, "\n") + "\n\n" + links
+	}
+
+	return &Post{
+		Slug:        name,
+		FrontMatter: fields,
+		Content:     content,
+		Format:      c.Format,
+		Attachments: attachments,
+	}
+}
+
+// Bytes renders the post as front matter followed by the content.
+func (p *Post) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeFrontMatter(&buf, p.Format, p.FrontMatter); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	buf.WriteString(p.Content)
+	if !strings.HasSuffix(p.Content, "\n") {
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// Upload writes the post to the bucket, named after its slug, followed by
+// its attachments. Posts named by Converter.Slugs are written with
+// CreateFile, and when another writer has taken the slug since it was
+// checked the post is renamed to the next free slug and written again,
+// before any attachments are written into the folder for the slug.
+// Other posts are written with UploadFile, replacing any post of that name.
+func (p *Post) Upload(b *storage.Bucket) error {
+	for {
+		data, err := p.Bytes()
+		if err != nil {
+			return err
+		}
+		if p.rename == nil {
+			err = b.UploadFile(p.Slug, string(data))
+		} else {
+			err = b.CreateFile(p.Slug, string(data))
+		}
+		if errors.Is(err, storage.ErrObjectExists) && len(p.candidates) > 0 {
+			renamed := p.rename(p.candidates[0])
+			renamed.rename, renamed.candidates = p.rename, p.candidates[1:]
+			*p = *renamed
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+
+	for _, a := range p.Attachments {
+		if err := b.UploadObject(a.Key, a.Data, a.ContentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Slugify transliterates s to a lowercase ASCII slug no longer than slug.DefaultMaxLength.
+func Slugify(s string) string {
+	return slug.Make(s, slug.DefaultMaxLength)
+}
+
+func author(m mail.Message) interface{} {
+	if m.From == nil {
+		return nil
+	}
+	if m.From.Name != "" {
+		return m.From.Name
+	}
+	return m.From.Address
+}
+
+func isDefault(name string) bool {
+	switch name {
+	case "title", "date", "author", "slug", "draft":
+		return true
+	}
+	return false
+}
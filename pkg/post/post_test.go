@@ -0,0 +1,238 @@
+package post
+
+import (
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/cstdev/lambdahelpers/pkg/mail"
+	"github.com/cstdev/lambdahelpers/pkg/s3/manager"
+	"github.com/cstdev/lambdahelpers/pkg/slug"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.DebugLevel)
+	log.SetFormatter(&log.JSONFormatter{})
+	retCode := m.Run()
+	os.Exit(retCode)
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		log.WithFields(log.Fields{
+			"file":  filepath.Base(file),
+			"line":  line,
+			"error": err.Error(),
+		}).Error("unexpected error")
+		tb.FailNow()
+	}
+}
+
+func equals(tb testing.TB, expected, actual interface{}) {
+	if !reflect.DeepEqual(expected, actual) {
+		tb.Errorf("Expected: %s \n Actual: %s", expected, actual)
+	}
+}
+
+func testMessage() mail.Message {
+	return mail.Message{
+		Subject: `My "First" Post!`,
+		Body:    "Hello world",
+		From:    &netmail.Address{Name: "Jane Doe", Address: "jane@example.com"},
+		Date:    time.Date(2019, 1, 2, 15, 4, 5, 0, time.UTC),
+		Header:  netmail.Header{"X-Tags": {"go, lambda"}},
+	}
+}
+
+func TestConvertWritesYAMLFrontMatter(t *testing.T) {
+	c := Converter{Draft: true}
+
+	p, err := c.Convert(testMessage())
+	ok(t, err)
+	data, err := p.Bytes()
+	ok(t, err)
+
+	equals(t, `---
+title: "My \"First\" Post!"
+date: 2019-01-02T15:04:05Z
+author: "Jane Doe"
+slug: "my-first-post"
+draft: true
+---
+
+Hello world
+`, string(data))
+}
+
+func TestConvertWritesTOMLFrontMatter(t *testing.T) {
+	c := Converter{Format: TOML}
+
+	p, err := c.Convert(testMessage())
+	ok(t, err)
+	data, err := p.Bytes()
+	ok(t, err)
+
+	equals(t, `+++
+title = "My \"First\" Post!"
+date = 2019-01-02T15:04:05Z
+author = "Jane Doe"
+slug = "my-first-post"
+draft = false
++++
+
+Hello world
+`, string(data))
+}
+
+func TestConvertAppliesMappings(t *testing.T) {
+	c := Converter{
+		Mappings: map[string]Mapping{
+			"author": func(m mail.Message) interface{} { return m.From.Address },
+			"draft":  nil,
+			"tags": func(m mail.Message) interface{} {
+				return strings.Split(m.Header.Get("X-Tags"), ", ")
+			},
+		},
+	}
+
+	p, err := c.Convert(testMessage())
+	ok(t, err)
+
+	equals(t, []Field{
+		{"title", `My "First" Post!`},
+		{"date", time.Date(2019, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"author", "jane@example.com"},
+		{"slug", "my-first-post"},
+		{"tags", []string{"go", "lambda"}},
+	}, p.FrontMatter)
+}
+
+func TestConvertDatesMessagesWithoutDateHeader(t *testing.T) {
+	now := time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)
+	m := testMessage()
+	m.Date = time.Time{}
+	m.From = nil
+	c := Converter{Now: func() time.Time { return now }}
+
+	p, err := c.Convert(m)
+	ok(t, err)
+
+	equals(t, Field{"date", now}, p.FrontMatter[1])
+	equals(t, "slug", p.FrontMatter[2].Name)
+}
+
+func TestConvertReturnsErrMissingSlug(t *testing.T) {
+	c := Converter{Slug: func(mail.Message) string { return "" }}
+
+	_, err := c.Convert(testMessage())
+	equals(t, ErrMissingSlug, err)
+}
+
+func TestConvertNamesPostsWithNonLatinSubjects(t *testing.T) {
+	m := testMessage()
+	c := Converter{}
+
+	m.Subject = "Привет мир"
+	p, err := c.Convert(m)
+	ok(t, err)
+	equals(t, "privet-mir", p.Slug)
+	equals(t, Field{"title", "Привет мир"}, p.FrontMatter[0])
+
+	// Nothing is left of a Japanese subject, so the date and time name the post.
+	m.Subject = "日本語"
+	p, err = c.Convert(m)
+	ok(t, err)
+	equals(t, "2019-01-02-150405", p.Slug)
+	equals(t, Field{"title", "日本語"}, p.FrontMatter[0])
+}
+
+func TestBytesRejectsUnsupportedValues(t *testing.T) {
+	p := Post{FrontMatter: []Field{{"weight", struct{}{}}}}
+
+	_, err := p.Bytes()
+	if err == nil {
+		t.Error("Expected an error for an unsupported value")
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	equals(t, "hello-world-2019", Slugify("  Hello, World! 2019 "))
+	equals(t, "", Slugify("--"))
+}
+
+type mockedManager struct {
+	manager.S3Manager
+	UploadFunc func(*s3manager.UploadInput, ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
+}
+
+func (m mockedManager) UploadWithContext(_ aws.Context, i *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	return m.UploadFunc(i, options...)
+}
+
+func TestUploadWritesPostNamedAfterSlug(t *testing.T) {
+	var key string
+	b := &storage.Bucket{
+		Manager: mockedManager{
+			UploadFunc: func(i *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				key = *i.Key
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+	c := Converter{}
+
+	p, err := c.Convert(testMessage())
+	ok(t, err)
+	ok(t, p.Upload(b))
+
+	equals(t, "/content/post/my-first-post.md", key)
+}
+
+type mockedS3API struct {
+	s3iface.S3API
+	HeadObjectFunc func(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+}
+
+func (m mockedS3API) HeadObjectWithContext(_ aws.Context, i *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	return m.HeadObjectFunc(i)
+}
+
+func TestConvertMakesSlugUniqueInBucket(t *testing.T) {
+	c := Converter{
+		Slugs: &slug.Generator{
+			Bucket: &storage.Bucket{
+				Client: mockedS3API{
+					HeadObjectFunc: func(i *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+						if *i.Key == "/content/post/my-first-post.md" {
+							return &s3.HeadObjectOutput{}, nil
+						}
+						return nil, awserr.New("NotFound", "Not Found", nil)
+					},
+				},
+				Name: "TestBucket",
+			},
+		},
+	}
+
+	p, err := c.Convert(testMessage())
+	ok(t, err)
+
+	equals(t, "my-first-post-2", p.Slug)
+	equals(t, Field{"slug", "my-first-post-2"}, p.FrontMatter[3])
+}
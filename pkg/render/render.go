@@ -0,0 +1,356 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+
+	"github.com/cstdev/lambdahelpers/pkg/mail"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+)
+
+// Directories holding the files shared by every template. Layouts and
+// partials are parsed alongside each template under their path, so a
+// template uses one with {{template "layouts/base.html" .}} and fills in
+// its blocks with {{define}}.
+const (
+	LayoutsDir  = "layouts"
+	PartialsDir = "partials"
+)
+
+// Suffixes of the files making up a template called name, such as
+// published.subject.txt, published.txt, published.html and published.sms.txt.
+const (
+	SubjectSuffix = ".subject.txt"
+	TextSuffix    = ".txt"
+	HTMLSuffix    = ".html"
+	SMSSuffix     = ".sms.txt"
+)
+
+// DefaultMaxSMSSegments is how many segments a rendered text message may
+// need when Templates.MaxSMSSegments is zero.
+const DefaultMaxSMSSegments = 3
+
+var (
+	// ErrTemplateNotFound is returned when there are no files for the named template.
+	ErrTemplateNotFound = errors.New("Template not found")
+	// ErrSMSTooLong is returned when a rendered text message needs more segments than allowed.
+	ErrSMSTooLong = errors.New("Text message is too long")
+	// ErrInvalidName is returned for template names ending in .subject or .sms,
+	// whose files can't be told apart from another template's subject or text message.
+	ErrInvalidName = errors.New("Invalid template name")
+)
+
+// Templates renders emails and text messages from a set of template files.
+// Subjects, text bodies and text messages use text/template, HTML bodies
+// use html/template so data is escaped. Missing data is an error.
+// The files are parsed once, on the first render.
+type Templates struct {
+	// Funcs are made available to every template. They must be set before the first render.
+	Funcs map[string]interface{}
+	// MaxSMSSegments caps the segments a text message may be split into,
+	// DefaultMaxSMSSegments when zero.
+	MaxSMSSegments int
+
+	files  map[string]string
+	once   sync.Once
+	parsed map[string]parsed
+}
+
+// parsed is a template file along with the layouts and partials it may use,
+// or the error parsing them.
+type parsed struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+	err  error
+}
+
+// New returns templates made from files, keyed by slash separated paths.
+func New(files map[string]string) *Templates {
+	return &Templates{files: files}
+}
+
+// Load reads every file under dir in fsys, such as an embed.FS.
+func Load(fsys fs.FS, dir string) (*Templates, error) {
+	files := make(map[string]string)
+	dir = path.Clean(dir)
+	err := fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		if dir != "." {
+			p = strings.TrimPrefix(p, dir+"/")
+		}
+		files[p] = string(b)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Loading templates from %s: %w", dir, err)
+	}
+	return New(files), nil
+}
+
+// LoadBucket reads every object under prefix in the bucket, so templates can
+// change without a deploy.
+func LoadBucket(b *storage.Bucket, prefix string) (*Templates, error) {
+	keys, err := b.Keys(prefix)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+		body, _, err := b.Open(key)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Reading template %s: %w", key, err)
+		}
+		files[strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")] = string(data)
+	}
+	return New(files), nil
+}
+
+// Email renders the subject and bodies of the named template into e, ready
+// for SESMail.Send. The subject is required along with at least one body.
+func (t *Templates) Email(name string, data interface{}, e mail.Email) (mail.Email, error) {
+	if err := checkName(name); err != nil {
+		return e, err
+	}
+	subject, found, err := t.text(name+SubjectSuffix, data)
+	if err != nil {
+		return e, err
+	}
+	if !found {
+		return e, fmt.Errorf("%w: %s", ErrTemplateNotFound, name+SubjectSuffix)
+	}
+	text, foundText, err := t.text(name+TextSuffix, data)
+	if err != nil {
+		return e, err
+	}
+	html, foundHTML, err := t.html(name+HTMLSuffix, data)
+	if err != nil {
+		return e, err
+	}
+	if !foundText && !foundHTML {
+		return e, fmt.Errorf("%w: %s has no text or HTML body", ErrTemplateNotFound, name)
+	}
+
+	// Header values can't span lines.
+	e.Subject = strings.Join(strings.Fields(subject), " ")
+	e.TextBody = text
+	e.HTMLBody = html
+	return e, nil
+}
+
+// SMS renders the named text message, ready for SMS.SendMessage. It returns
+// ErrSMSTooLong when the message needs more than MaxSMSSegments segments.
+func (t *Templates) SMS(name string, data interface{}) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+	message, found, err := t.text(name+SMSSuffix, data)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("%w: %s", ErrTemplateNotFound, name+SMSSuffix)
+	}
+	message = strings.TrimSpace(message)
+
+	max := t.MaxSMSSegments
+	if max <= 0 {
+		max = DefaultMaxSMSSegments
+	}
+	if segments := Segments(message); segments > max {
+		return "", fmt.Errorf("%w: %s needs %d segments, at most %d are allowed", ErrSMSTooLong, name, segments, max)
+	}
+	return message, nil
+}
+
+// checkName rejects names whose files would collide with another template's,
+// such as the text body of "x.sms", which is the text message of "x".
+func checkName(name string) error {
+	for _, suffix := range []string{SubjectSuffix, SMSSuffix} {
+		if strings.HasSuffix(name, strings.TrimSuffix(suffix, TextSuffix)) {
+			return fmt.Errorf("%w: %s ends in %s", ErrInvalidName, name, strings.TrimSuffix(suffix, TextSuffix))
+		}
+	}
+	return nil
+}
+
+// shared returns the layouts and partials with the given suffix, in order.
+func (t *Templates) shared(suffix string) []string {
+	var paths []string
+	for p := range t.files {
+		if isShared(p) && path.Ext(p) == suffix {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func isShared(p string) bool {
+	dir := strings.SplitN(p, "/", 2)[0]
+	return dir == LayoutsDir || dir == PartialsDir
+}
+
+// parse parses the layouts and partials once and each template file into a
+// clone of them. Errors are kept with the template they belong to so one
+// broken template doesn't stop the others rendering.
+func (t *Templates) parse() map[string]parsed {
+	t.once.Do(func() {
+		t.parsed = make(map[string]parsed)
+
+		text := texttemplate.New("").Funcs(t.Funcs).Option("missingkey=error")
+		var textErr error
+		for _, p := range t.shared(".txt") {
+			if _, err := text.New(p).Parse(t.files[p]); err != nil {
+				textErr = err
+				break
+			}
+		}
+		html := htmltemplate.New("").Funcs(t.Funcs).Option("missingkey=error")
+		var htmlErr error
+		for _, p := range t.shared(".html") {
+			if _, err := html.New(p).Parse(t.files[p]); err != nil {
+				htmlErr = err
+				break
+			}
+		}
+
+		for name, content := range t.files {
+			if isShared(name) {
+				continue
+			}
+			switch path.Ext(name) {
+			case ".txt":
+				t.parsed[name] = parseText(text, textErr, name, content)
+			case ".html":
+				t.parsed[name] = parseHTML(html, htmlErr, name, content)
+			}
+		}
+	})
+	return t.parsed
+}
+
+// parseText parses content into a clone of shared. It is parsed last so its
+// definitions replace the layouts' blocks.
+func parseText(shared *texttemplate.Template, sharedErr error, name string, content string) parsed {
+	if sharedErr != nil {
+		return parsed{err: sharedErr}
+	}
+	tmpl, err := shared.Clone()
+	if err != nil {
+		return parsed{err: err}
+	}
+	tmpl, err = tmpl.New(name).Parse(content)
+	return parsed{text: tmpl, err: err}
+}
+
+func parseHTML(shared *htmltemplate.Template, sharedErr error, name string, content string) parsed {
+	if sharedErr != nil {
+		return parsed{err: sharedErr}
+	}
+	tmpl, err := shared.Clone()
+	if err != nil {
+		return parsed{err: err}
+	}
+	tmpl, err = tmpl.New(name).Parse(content)
+	return parsed{html: tmpl, err: err}
+}
+
+func (t *Templates) text(name string, data interface{}) (string, bool, error) {
+	p, ok := t.parse()[name]
+	if !ok {
+		return "", false, nil
+	}
+	if p.err != nil {
+		return "", true, p.err
+	}
+	var buf bytes.Buffer
+	if err := p.text.Execute(&buf, data); err != nil {
+		return "", true, err
+	}
+	return buf.String(), true, nil
+}
+
+func (t *Templates) html(name string, data interface{}) (string, bool, error) {
+	p, ok := t.parse()[name]
+	if !ok {
+		return "", false, nil
+	}
+	if p.err != nil {
+		return "", true, p.err
+	}
+	var buf bytes.Buffer
+	if err := p.html.Execute(&buf, data); err != nil {
+		return "", true, err
+	}
+	return buf.String(), true, nil
+}
+
+// gsm7 holds the characters of the GSM 03.38 default alphabet, gsm7Extended
+// those that take two characters' room.
+const (
+	gsm7 = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+		"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extended = "^{}\\[~]|€\f"
+)
+
+// Segments returns how many SMS segments message is sent as. Messages in the
+// GSM alphabet fit 160 characters in one segment or 153 per segment when
+// split, anything else is sent as UCS-2 with 70, or 67 per segment.
+func Segments(message string) int {
+	if message == "" {
+		return 0
+	}
+
+	length := 0
+	gsm := true
+	for _, r := range message {
+		switch {
+		case strings.ContainsRune(gsm7, r):
+			length++
+		case strings.ContainsRune(gsm7Extended, r):
+			length += 2
+		default:
+			gsm = false
+		}
+	}
+
+	single, multi := 160, 153
+	if !gsm {
+		// UCS-2 counts UTF-16 code units, so characters outside the BMP take two.
+		length = 0
+		for _, r := range message {
+			length++
+			if r > 0xFFFF {
+				length++
+			}
+		}
+		single, multi = 70, 67
+	}
+	if length <= single {
+		return 1
+	}
+	return (length + multi - 1) / multi
+}
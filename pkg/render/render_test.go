@@ -0,0 +1,215 @@
+package render
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/cstdev/lambdahelpers/pkg/mail"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+//go:embed testdata/templates
+var testTemplates embed.FS
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.DebugLevel)
+	log.SetFormatter(&log.JSONFormatter{})
+	retCode := m.Run()
+	os.Exit(retCode)
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		log.WithFields(log.Fields{
+			"file":  filepath.Base(file),
+			"line":  line,
+			"error": err.Error(),
+		}).Error("unexpected error")
+		tb.FailNow()
+	}
+}
+
+func equals(tb testing.TB, expected, actual interface{}) {
+	if !reflect.DeepEqual(expected, actual) {
+		tb.Errorf("Expected: %v \n Actual: %v", expected, actual)
+	}
+}
+
+type published struct {
+	Title string
+	URL   string
+	Site  string
+}
+
+var post = published{Title: "Fish & <Chips>", URL: "https://example.com/posts/fish-and-chips/", Site: "Blog"}
+
+func TestEmailRendersLayoutsPartialsAndEscapesHTML(t *testing.T) {
+	templates, err := Load(testTemplates, "testdata/templates")
+	ok(t, err)
+
+	e, err := templates.Email("published", post, mail.Email{From: "blog@example.com", To: []string{"jane@example.com"}})
+	ok(t, err)
+
+	equals(t, "blog@example.com", e.From)
+	equals(t, "Published: Fish & <Chips>", e.Subject)
+	equals(t, "Fish & <Chips> is live at https://example.com/posts/fish-and-chips/\n-- \nBlog\n", e.TextBody)
+	equals(t, `<html><body><h1>Fish &amp; &lt;Chips&gt;</h1><a href="https://example.com/posts/fish-and-chips/">Read it</a><p>Sent by Blog</p></body></html>`+"\n", e.HTMLBody)
+}
+
+func TestEmailReturnsErrors(t *testing.T) {
+	templates := New(map[string]string{
+		"subject-only.subject.txt": "Hello",
+		"broken.subject.txt":       "Hello {{.Missing}}",
+		"broken.txt":               "Body",
+	})
+
+	_, err := templates.Email("missing", post, mail.Email{})
+	if !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected ErrTemplateNotFound, received: %v", err)
+	}
+	_, err = templates.Email("subject-only", post, mail.Email{})
+	if !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected ErrTemplateNotFound for a template without a body, received: %v", err)
+	}
+	_, err = templates.Email("broken", map[string]string{}, mail.Email{})
+	if err == nil || !strings.Contains(err.Error(), "Missing") {
+		t.Errorf("Expected an error for missing data, received: %v", err)
+	}
+}
+
+func TestEmailRendersSameTemplateRepeatedly(t *testing.T) {
+	templates, err := Load(testTemplates, "testdata/templates")
+	ok(t, err)
+
+	for _, title := range []string{"First", "Second"} {
+		e, err := templates.Email("published", published{Title: title, Site: "Blog"}, mail.Email{})
+		ok(t, err)
+		equals(t, "Published: "+title, e.Subject)
+		if !strings.Contains(e.HTMLBody, "<h1>"+title+"</h1>") {
+			t.Errorf("Expected the HTML body to hold %s, received: %s", title, e.HTMLBody)
+		}
+	}
+}
+
+func TestRejectsNamesCollidingWithSuffixes(t *testing.T) {
+	templates := New(map[string]string{
+		"x.subject.txt": "Subject",
+		"x.sms.txt":     "Text message",
+		"x.txt":         "Body",
+	})
+
+	_, err := templates.Email("x.sms", post, mail.Email{})
+	if !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName, received: %v", err)
+	}
+	_, err = templates.SMS("x.subject", post)
+	if !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName, received: %v", err)
+	}
+	_, err = templates.Email("x", post, mail.Email{})
+	ok(t, err)
+}
+
+func TestEmailUsesFuncs(t *testing.T) {
+	templates := New(map[string]string{
+		"shout.subject.txt": "{{upper .Title}}",
+		"shout.html":        "<b>{{upper .Title}}</b>",
+	})
+	templates.Funcs = map[string]interface{}{"upper": strings.ToUpper}
+
+	e, err := templates.Email("shout", post, mail.Email{})
+	ok(t, err)
+
+	equals(t, "FISH & <CHIPS>", e.Subject)
+	equals(t, "<b>FISH &amp; &lt;CHIPS&gt;</b>", e.HTMLBody)
+	equals(t, "", e.TextBody)
+}
+
+func TestSMSRendersAndChecksLength(t *testing.T) {
+	templates, err := Load(testTemplates, "testdata/templates")
+	ok(t, err)
+
+	message, err := templates.SMS("published", post)
+	ok(t, err)
+	equals(t, "Fish & <Chips> is live: https://example.com/posts/fish-and-chips/", message)
+
+	templates.MaxSMSSegments = 1
+	_, err = templates.SMS("published", published{Title: strings.Repeat("Long title ", 20)})
+	if !errors.Is(err, ErrSMSTooLong) {
+		t.Errorf("Expected ErrSMSTooLong, received: %v", err)
+	}
+}
+
+func TestSegmentsCountsGSMAndUnicode(t *testing.T) {
+	equals(t, 0, Segments(""))
+	equals(t, 1, Segments(strings.Repeat("a", 160)))
+	equals(t, 2, Segments(strings.Repeat("a", 161)))
+	equals(t, 1, Segments(strings.Repeat("€", 80)))
+	equals(t, 2, Segments(strings.Repeat("€", 81)))
+	equals(t, 1, Segments(strings.Repeat("ж", 70)))
+	equals(t, 2, Segments(strings.Repeat("ж", 71)))
+	// 300 + 2 + 100 UTF-16 code units at 67 per segment.
+	equals(t, 6, Segments(strings.Repeat("a", 300)+"😀"+strings.Repeat("ж", 100)))
+}
+
+type mockedBucketAPI struct {
+	s3iface.S3API
+	objects map[string]string
+}
+
+func (m mockedBucketAPI) ListObjectsV2WithContext(_ aws.Context, i *s3.ListObjectsV2Input, _ ...request.Option) (*s3.ListObjectsV2Output, error) {
+	out := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	for key := range m.objects {
+		if strings.HasPrefix(key, *i.Prefix) {
+			out.Contents = append(out.Contents, &s3.Object{Key: aws.String(key)})
+		}
+	}
+	return out, nil
+}
+
+func (m mockedBucketAPI) GetObjectWithContext(_ aws.Context, i *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	body := m.objects[*i.Key]
+	return &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(body))),
+		ContentLength: aws.Int64(int64(len(body))),
+	}, nil
+}
+
+func TestLoadBucketReadsTemplatesUnderPrefix(t *testing.T) {
+	b := &storage.Bucket{
+		Client: mockedBucketAPI{objects: map[string]string{
+			"templates/":                       "",
+			"templates/failed.sms.txt":         "{{.Title}} failed",
+			"templates/partials/signature.txt": "{{define \"signature\"}}- {{.Site}}{{end}}",
+			"templates/failed.subject.txt":     "Failed",
+			"templates/failed.txt":             "{{.Title}} failed {{template \"signature\" .}}",
+			"other/failed.sms.txt":             "Wrong template",
+		}},
+		Name: "config",
+	}
+
+	templates, err := LoadBucket(b, "templates/")
+	ok(t, err)
+
+	message, err := templates.SMS("failed", post)
+	ok(t, err)
+	equals(t, "Fish & <Chips> failed", message)
+	e, err := templates.Email("failed", post, mail.Email{})
+	ok(t, err)
+	equals(t, "Fish & <Chips> failed - Blog", e.TextBody)
+}
@@ -0,0 +1,175 @@
+package retry
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/cstdev/lambdahelpers/pkg/awserror"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+)
+
+// DefaultRetryableCodes are the AWS error codes retried when a Policy doesn't list its own.
+var DefaultRetryableCodes = []string{
+	"Throttling",
+	"ThrottlingException",
+	"ThrottledException",
+	"TooManyRequestsException",
+	"RequestLimitExceeded",
+	"ProvisionedThroughputExceededException",
+	"SlowDown",
+	"ServiceUnavailable",
+	"InternalError",
+	"InternalFailure",
+	"RequestTimeout",
+}
+
+// Clock is the time source a Policy waits on, replaced by a fake in tests.
+type Clock interface {
+	Now() time.Time
+	Sleep(time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// Policy retries failed AWS calls with exponential backoff and jitter.
+// A nil *Policy makes a single attempt.
+type Policy struct {
+	// MaxAttempts includes the first call, values below 1 are treated as 1.
+	MaxAttempts int
+	// BaseDelay is the backoff before the second attempt, doubling each time after.
+	BaseDelay time.Duration
+	// MaxDelay caps a single backoff, no cap when zero.
+	MaxDelay time.Duration
+	// Deadline is the total time allowed across all attempts, no limit when zero.
+	// No attempt is started that couldn't begin before it passes.
+	Deadline time.Duration
+	// RetryableCodes lists the AWS error codes to retry. When nil,
+	// DefaultRetryableCodes and any error the SDK considers retryable are retried.
+	RetryableCodes []string
+
+	// Clock defaults to the system clock.
+	Clock Clock
+	// Jitter picks the actual wait from the backoff, defaulting to a
+	// random duration between zero and the backoff ("full jitter").
+	Jitter func(time.Duration) time.Duration
+}
+
+// DefaultPolicy makes up to 5 attempts starting at 100ms between them, within 10 seconds.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MaxAttempts: 5,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    2 * time.Second,
+		Deadline:    10 * time.Second,
+	}
+}
+
+// Do calls fn until it succeeds, returns an error that isn't retryable,
+// or the policy runs out of attempts or time. The last error is returned.
+func (p *Policy) Do(op string, fn func() error) error {
+	return p.DoWithLogger(logging.Default(), op, fn)
+}
+
+// DoWithLogger is Do, logging each attempt to logger.
+func (p *Policy) DoWithLogger(logger logging.Logger, op string, fn func() error) error {
+	if p == nil {
+		return fn()
+	}
+
+	clock := p.clock()
+	start := clock.Now()
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+
+		fields := logging.Fields{
+			"op":      op,
+			"attempt": attempt,
+		}
+		if err == nil {
+			logger.WithFields(fields).Debug("Attempt succeeded")
+			return nil
+		}
+		fields["error"] = err
+
+		if !p.Retryable(err) {
+			logger.WithFields(fields).Debug("Attempt failed, not retryable")
+			return err
+		}
+		if attempt >= maxAttempts {
+			logger.WithFields(fields).Warn("Attempt failed, out of attempts")
+			return err
+		}
+
+		wait := p.jitter(p.backoff(attempt))
+		if p.Deadline > 0 && clock.Now().Add(wait).Sub(start) >= p.Deadline {
+			logger.WithFields(fields).Warn("Attempt failed, retry would pass deadline")
+			return err
+		}
+
+		fields["wait"] = wait
+		logger.WithFields(fields).Warn("Attempt failed, retrying")
+		clock.Sleep(wait)
+	}
+}
+
+// Retryable reports whether the policy would retry err.
+func (p *Policy) Retryable(err error) bool {
+	codes := p.RetryableCodes
+	if codes == nil {
+		if awserror.IsRetryable(err) {
+			return true
+		}
+		codes = DefaultRetryableCodes
+	}
+
+	code := awserror.Code(err)
+	if code == "" {
+		return false
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff is the wait before the attempt after the given one.
+func (p *Policy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for n := 1; n < attempt; n++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+func (p *Policy) jitter(d time.Duration) time.Duration {
+	if p.Jitter != nil {
+		return p.Jitter(d)
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func (p *Policy) clock() Clock {
+	if p.Clock != nil {
+		return p.Clock
+	}
+	return realClock{}
+}
@@ -0,0 +1,140 @@
+package retry
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.DebugLevel)
+	log.SetFormatter(&log.JSONFormatter{})
+	retCode := m.Run()
+	os.Exit(retCode)
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		log.WithFields(log.Fields{
+			"file":  filepath.Base(file),
+			"line":  line,
+			"error": err.Error(),
+		}).Error("unexpected error")
+		tb.FailNow()
+	}
+}
+
+func equals(tb testing.TB, expected, actual interface{}) {
+	if !reflect.DeepEqual(expected, actual) {
+		tb.Errorf("Expected: %v \n Actual: %v", expected, actual)
+	}
+}
+
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+}
+
+func noJitter(d time.Duration) time.Duration { return d }
+
+var errThrottled = awserr.New("Throttling", "Rate exceeded", nil)
+
+func failing(times int, err error) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= times {
+			return err
+		}
+		return nil
+	}, &calls
+}
+
+func TestDoBacksOffExponentiallyUntilSuccess(t *testing.T) {
+	clock := &fakeClock{}
+	p := &Policy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond, Clock: clock, Jitter: noJitter}
+	fn, calls := failing(3, errThrottled)
+
+	ok(t, p.Do("ses:SendEmail", fn))
+
+	equals(t, 4, *calls)
+	equals(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}, clock.sleeps)
+}
+
+func TestDoStopsAfterMaxAttempts(t *testing.T) {
+	clock := &fakeClock{}
+	p := &Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, Clock: clock, Jitter: noJitter}
+	fn, calls := failing(10, errThrottled)
+
+	err := p.Do("sns:Publish", fn)
+
+	equals(t, errThrottled, err)
+	equals(t, 3, *calls)
+}
+
+func TestDoDoesNotRetryOtherErrors(t *testing.T) {
+	clock := &fakeClock{}
+	p := &Policy{MaxAttempts: 3, Clock: clock, Jitter: noJitter}
+	invalid := awserr.New("InvalidParameter", "bad number", nil)
+
+	fn, calls := failing(10, invalid)
+	equals(t, invalid, p.Do("sns:Publish", fn))
+	equals(t, 1, *calls)
+
+	plain := errors.New("not from AWS")
+	fn, calls = failing(10, plain)
+	equals(t, plain, p.Do("sns:Publish", fn))
+	equals(t, 1, *calls)
+}
+
+func TestDoGivesUpBeforePassingDeadline(t *testing.T) {
+	clock := &fakeClock{}
+	p := &Policy{MaxAttempts: 10, BaseDelay: time.Second, Deadline: 4 * time.Second, Clock: clock, Jitter: noJitter}
+	fn, calls := failing(10, errThrottled)
+
+	err := p.Do("s3:GetObject", fn)
+
+	equals(t, errThrottled, err)
+	equals(t, 3, *calls)
+	equals(t, []time.Duration{time.Second, 2 * time.Second}, clock.sleeps)
+}
+
+func TestRetryableCodesReplaceDefaults(t *testing.T) {
+	p := &Policy{RetryableCodes: []string{"InvalidParameter"}}
+
+	equals(t, true, p.Retryable(awserr.New("InvalidParameter", "", nil)))
+	equals(t, false, p.Retryable(errThrottled))
+}
+
+func TestNilPolicyMakesOneAttempt(t *testing.T) {
+	var p *Policy
+	fn, calls := failing(10, errThrottled)
+
+	equals(t, errThrottled, p.Do("s3:GetObject", fn))
+	equals(t, 1, *calls)
+}
+
+func TestDefaultJitterStaysWithinBackoff(t *testing.T) {
+	p := &Policy{}
+	for n := 0; n < 100; n++ {
+		if d := p.jitter(time.Second); d < 0 || d > time.Second {
+			t.Fatalf("Jitter out of range: %v", d)
+		}
+	}
+}
@@ -30,3 +30,11 @@ func (b *BucketManager) Download(w io.WriterAt, i *s3.GetObjectInput, opts ...fu
 func (b *BucketManager) Upload(i *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
 	return b.Uploader.Upload(i, opts...)
 }
+
+func (b *BucketManager) DownloadWithContext(ctx aws.Context, w io.WriterAt, i *s3.GetObjectInput, opts ...func(*s3manager.Downloader)) (int64, error) {
+	return b.Downloader.DownloadWithContext(ctx, w, i, opts...)
+}
+
+func (b *BucketManager) UploadWithContext(ctx aws.Context, i *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	return b.Uploader.UploadWithContext(ctx, i, opts...)
+}
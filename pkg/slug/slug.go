@@ -0,0 +1,197 @@
+package slug
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultMaxLength is the longest slug Make returns when no length is given.
+const DefaultMaxLength = 60
+
+// DefaultMaxAttempts is how many suffixes Unique tries when Generator.MaxAttempts is zero.
+const DefaultMaxAttempts = 100
+
+var (
+	// ErrEmptySlug is returned when nothing usable is left of a title, such as one made of emoji.
+	ErrEmptySlug = errors.New("Title has nothing to make a slug from")
+	// ErrSlugTaken is returned when every slug tried is already in use.
+	ErrSlugTaken = errors.New("No unused slug found")
+)
+
+// Strategy decides how Unique changes a slug that is already in use.
+type Strategy int
+
+const (
+	// Suffix appends -2, -3 and so on. This is the default.
+	Suffix Strategy = iota
+	// DatePrefix puts the post's date in front of the slug, then falls back to Suffix.
+	DatePrefix
+)
+
+// letters are transliterations that decomposing and dropping accents doesn't cover.
+var letters = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "Æ", "AE", "œ", "oe", "Œ", "OE",
+	"ø", "o", "Ø", "O", "đ", "d", "Đ", "D", "ð", "d", "Ð", "D",
+	"ł", "l", "Ł", "L", "þ", "th", "Þ", "TH", "ı", "i", "й", "y", "Й", "Y",
+	"&", " and ", "'", "", "’", "",
+)
+
+// scripts transliterates lowercase Cyrillic and Greek letters once accents
+// are dropped. Scripts without an alphabet to spell out, such as Chinese or
+// Japanese, are left out and make an empty slug.
+var scripts = strings.NewReplacer(
+	"а", "a", "б", "b", "в", "v", "г", "g", "ґ", "g", "д", "d", "е", "e",
+	"є", "ye", "ж", "zh", "з", "z", "и", "i", "і", "i", "ї", "yi", "к", "k",
+	"л", "l", "м", "m", "н", "n", "о", "o", "п", "p", "р", "r", "с", "s",
+	"т", "t", "у", "u", "ф", "f", "х", "kh", "ц", "ts", "ч", "ch", "ш", "sh",
+	"щ", "shch", "ъ", "", "ы", "y", "ь", "", "э", "e", "ю", "yu", "я", "ya",
+	"α", "a", "β", "v", "γ", "g", "δ", "d", "ε", "e", "ζ", "z", "η", "i",
+	"θ", "th", "ι", "i", "κ", "k", "λ", "l", "μ", "m", "ν", "n", "ξ", "x",
+	"ο", "o", "π", "p", "ρ", "r", "σ", "s", "ς", "s", "τ", "t", "υ", "y",
+	"φ", "f", "χ", "ch", "ψ", "ps", "ω", "o",
+)
+
+// Make turns s into a lowercase ASCII slug of letters and digits joined by
+// single hyphens, cut at a word boundary to fit maxLength. Accents are
+// dropped and Cyrillic and Greek are transliterated, anything else is left out.
+// A maxLength of zero or less uses DefaultMaxLength.
+func Make(s string, maxLength int) string {
+	if maxLength <= 0 {
+		maxLength = DefaultMaxLength
+	}
+
+	ascii, _, err := transform.String(transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn))), letters.Replace(s))
+	if err != nil {
+		ascii = s
+	}
+
+	var b strings.Builder
+	hyphen := false
+	for _, r := range scripts.Replace(strings.ToLower(ascii)) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(r)
+			continue
+		}
+		hyphen = true
+	}
+
+	return truncate(b.String(), maxLength)
+}
+
+// truncate cuts a slug to maxLength, dropping any partial word at the end.
+// Nothing is left when maxLength is zero or less.
+func truncate(slug string, maxLength int) string {
+	if maxLength <= 0 {
+		return ""
+	}
+	if len(slug) <= maxLength {
+		return slug
+	}
+	cut := slug[:maxLength]
+	if slug[maxLength] != '-' {
+		if i := strings.LastIndexByte(cut, '-'); i > 0 {
+			cut = cut[:i]
+		}
+	}
+	return strings.TrimRight(cut, "-")
+}
+
+// Generator makes slugs that aren't already used by a post in the bucket.
+type Generator struct {
+	Bucket *storage.Bucket
+	// Key is the object key a post with the slug is stored at,
+	// storage.PostKey when nil.
+	Key func(slug string) string
+	// MaxLength is the longest slug made, including any prefix or suffix.
+	MaxLength int
+	Strategy  Strategy
+	// MaxAttempts limits the suffixes tried, DefaultMaxAttempts when zero.
+	MaxAttempts int
+	// Logger receives the generator's logs, logging.Default() when nil.
+	Logger logging.Logger
+}
+
+func (g *Generator) log() logging.Logger {
+	return logging.Or(g.Logger)
+}
+
+// Unique makes a slug for title that isn't taken in the bucket.
+// The date is used by the DatePrefix strategy.
+//
+// Another writer may take the slug before the post is written, so posts
+// should be written with Bucket.CreateFile, moving on to the candidates
+// after this one when it returns storage.ErrObjectExists.
+func (g *Generator) Unique(title string, date time.Time) (string, error) {
+	candidates, err := g.Candidates(title, date)
+	if err != nil {
+		return "", err
+	}
+
+	for _, candidate := range candidates {
+		taken, err := g.taken(candidate)
+		if err != nil || !taken {
+			return candidate, err
+		}
+	}
+
+	g.log().WithFields(logging.Fields{
+		"slug": candidates[0],
+	}).Error("Every slug tried is taken")
+	return "", ErrSlugTaken
+}
+
+// Candidates returns the slugs Unique tries for title, in order. Candidates
+// that a prefix or suffix leaves no room for within MaxLength are left out.
+func (g *Generator) Candidates(title string, date time.Time) ([]string, error) {
+	maxLength := g.MaxLength
+	if maxLength <= 0 {
+		maxLength = DefaultMaxLength
+	}
+	attempts := g.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultMaxAttempts
+	}
+
+	slug := Make(title, maxLength)
+	if slug == "" {
+		return nil, ErrEmptySlug
+	}
+	candidates := []string{slug}
+
+	if g.Strategy == DatePrefix {
+		prefix := date.Format("2006-01-02") + "-"
+		if base := truncate(slug, maxLength-len(prefix)); base != "" {
+			slug = prefix + base
+			candidates = append(candidates, slug)
+		}
+	}
+
+	for n := 2; n < attempts+2; n++ {
+		suffix := fmt.Sprintf("-%d", n)
+		if base := truncate(slug, maxLength-len(suffix)); base != "" {
+			candidates = append(candidates, base+suffix)
+		}
+	}
+	return candidates, nil
+}
+
+func (g *Generator) taken(slug string) (bool, error) {
+	key := storage.PostKey(slug)
+	if g.Key != nil {
+		key = g.Key(slug)
+	}
+	return g.Bucket.Exists(key)
+}
@@ -0,0 +1,155 @@
+package slug
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/cstdev/lambdahelpers/pkg/storage"
+)
+
+func equals(tb testing.TB, expected, actual interface{}) {
+	if !reflect.DeepEqual(expected, actual) {
+		tb.Errorf("Expected: %v \n Actual: %v", expected, actual)
+	}
+}
+
+type mockedS3API struct {
+	s3iface.S3API
+	HeadObjectFunc func(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+}
+
+func (m mockedS3API) HeadObjectWithContext(_ aws.Context, i *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	return m.HeadObjectFunc(i)
+}
+
+// bucketWith answers HeadObject as if only the given keys exist.
+func bucketWith(keys ...string) *storage.Bucket {
+	existing := make(map[string]bool)
+	for _, key := range keys {
+		existing[key] = true
+	}
+	return &storage.Bucket{
+		Client: mockedS3API{
+			HeadObjectFunc: func(i *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+				if existing[*i.Key] {
+					return &s3.HeadObjectOutput{}, nil
+				}
+				return nil, awserr.New("NotFound", "Not Found", nil)
+			},
+		},
+		Name: "TestBucket",
+	}
+}
+
+func TestMakeTransliteratesAndCollapses(t *testing.T) {
+	equals(t, "creme-brulee-in-munchen", Make("Crème Brûlée in München", 0))
+	equals(t, "strasse-and-smorrebrod", Make("Straße & Smørrebrød", 0))
+	equals(t, "dont-panic-2019", Make("  Don't   panic!!! 🎉 2019 ", 0))
+	equals(t, "", Make("🎉🎉", 0))
+}
+
+func TestMakeTransliteratesCyrillicAndGreek(t *testing.T) {
+	equals(t, "privet-mir", Make("Привет мир", 0))
+	equals(t, "tolstoy-i-shchedrin", Make("Толстой и Щедрин", 0))
+	equals(t, "kalimera-athina", Make("Καλημέρα Αθήνα", 0))
+	equals(t, "", Make("日本語", 0))
+}
+
+func TestMakeCutsAtWordBoundary(t *testing.T) {
+	equals(t, "a-long", Make("a long title", 8))
+	equals(t, "a-long", Make("a long title", 7))
+	equals(t, "abcdefgh", Make("abcdefghij", 8))
+}
+
+func TestUniqueReturnsSlugWhenFree(t *testing.T) {
+	g := Generator{Bucket: bucketWith()}
+
+	slug, err := g.Unique("My Post", time.Time{})
+
+	equals(t, nil, err)
+	equals(t, "my-post", slug)
+}
+
+func TestUniqueAppendsSuffix(t *testing.T) {
+	g := Generator{Bucket: bucketWith("/content/post/my-post.md", "/content/post/my-post-2.md")}
+
+	slug, err := g.Unique("My Post", time.Time{})
+
+	equals(t, nil, err)
+	equals(t, "my-post-3", slug)
+}
+
+func TestUniqueKeepsSuffixWithinMaxLength(t *testing.T) {
+	g := Generator{
+		Bucket:    bucketWith("posts/my-long-post"),
+		Key:       func(slug string) string { return "posts/" + slug },
+		MaxLength: 12,
+	}
+
+	slug, err := g.Unique("My long post", time.Time{})
+
+	equals(t, nil, err)
+	equals(t, "my-long-2", slug)
+}
+
+func TestUniquePrefixesDate(t *testing.T) {
+	date := time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)
+	g := Generator{Bucket: bucketWith("/content/post/my-post.md"), Strategy: DatePrefix}
+
+	slug, err := g.Unique("My Post", date)
+	equals(t, nil, err)
+	equals(t, "2019-01-02-my-post", slug)
+
+	g.Bucket = bucketWith("/content/post/my-post.md", "/content/post/2019-01-02-my-post.md")
+	slug, err = g.Unique("My Post", date)
+	equals(t, nil, err)
+	equals(t, "2019-01-02-my-post-2", slug)
+}
+
+func TestUniqueGivesUpAfterMaxAttempts(t *testing.T) {
+	g := Generator{
+		Bucket:      bucketWith("/content/post/a.md", "/content/post/a-2.md", "/content/post/a-3.md"),
+		MaxAttempts: 2,
+	}
+
+	_, err := g.Unique("a", time.Time{})
+	equals(t, ErrSlugTaken, err)
+
+	_, err = g.Unique("🎉", time.Time{})
+	equals(t, ErrEmptySlug, err)
+}
+
+func TestUniqueReturnsBucketErrors(t *testing.T) {
+	g := Generator{Bucket: &storage.Bucket{
+		Client: mockedS3API{
+			HeadObjectFunc: func(i *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+				return nil, awserr.New("AccessDenied", "Access Denied", nil)
+			},
+		},
+	}}
+
+	_, err := g.Unique("My Post", time.Time{})
+	if err == nil || errors.Is(err, storage.ErrObjectNotFound) {
+		t.Errorf("Expected access denied, received: %v", err)
+	}
+}
+
+func TestUniqueSkipsCandidatesThatDontFitMaxLength(t *testing.T) {
+	date := time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)
+	g := Generator{Bucket: bucketWith("/content/post/my.md"), Strategy: DatePrefix, MaxLength: 5}
+
+	slug, err := g.Unique("My Post", date)
+	equals(t, nil, err)
+	equals(t, "my-2", slug)
+
+	g = Generator{Bucket: bucketWith("/content/post/my.md"), MaxLength: 2}
+	_, err = g.Unique("My Post", date)
+	equals(t, ErrSlugTaken, err)
+}
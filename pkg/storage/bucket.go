@@ -1,54 +1,151 @@
 package storage
 
 import (
-	"errors"
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	"github.com/cstdev/lambdahelpers/pkg/metrics"
+	"github.com/cstdev/lambdahelpers/pkg/retry"
 	"github.com/cstdev/lambdahelpers/pkg/s3/manager"
+	"github.com/cstdev/lambdahelpers/pkg/tracing"
 	"github.com/karrick/godirwalk"
-	log "github.com/sirupsen/logrus"
+	pkgerrors "github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Bucket struct {
 	Client  s3iface.S3API
 	Manager manager.S3Manager
 	Name    string
+
+	// Symlinks decides how Upload treats symbolic links.
+	Symlinks SymlinkPolicy
+	// ContinueOnError makes Upload carry on past files it fails to upload.
+	ContinueOnError bool
+	// RedirectsFile names a file in the root of the upload path holding
+	// "/old /new 301" lines. Upload applies it instead of uploading it.
+	RedirectsFile string
+	// RedirectsAsRoutingRules makes Upload write redirects of folders as website
+	// routing rules rather than redirect objects. Single objects are always
+	// redirected with redirect objects.
+	RedirectsAsRoutingRules bool
+	// UploadTags are set on every object written by UploadFile and Upload.
+	UploadTags map[string]string
+	// UnsafeKeys decides whether DownloadAllObjectsInBucket skips or rejects
+	// keys that would be written outside the destination directory.
+	UnsafeKeys UnsafeKeyPolicy
+	// MaxReadSize limits the bytes Open and OpenRange return, no limit when zero.
+	MaxReadSize int64
+	// LeasePrefix is where Claim keeps lease objects, DefaultLeasePrefix when empty.
+	LeasePrefix string
+	// Logger receives the bucket's logs, logging.Default() when nil.
+	Logger logging.Logger
+	// Retry is the policy for S3 calls, a single attempt is made when nil.
+	Retry *retry.Policy
+	// AfterUpload is called with the report once Upload has walked the whole path,
+	// for example to invalidate a CDN.
+	AfterUpload func(*UploadReport) error
+	// Metrics receives bytes moved, objects processed and call latency, nothing is recorded when nil.
+	Metrics metrics.Sink
+	// Tracer starts the spans around bucket operations and S3 calls,
+	// the global OpenTelemetry tracer when nil.
+	Tracer trace.Tracer
+
+	ctx context.Context
+}
+
+// WithContext returns a copy of the bucket whose calls are cancelled along with
+// ctx and whose spans are children of the span in ctx.
+func (b *Bucket) WithContext(ctx context.Context) *Bucket {
+	c := *b
+	c.ctx = ctx
+	return &c
+}
+
+// startSpan starts a span for an operation and returns a copy of the bucket
+// whose S3 calls are recorded as its children.
+func (b *Bucket) startSpan(name string, attrs ...attribute.KeyValue) (*Bucket, trace.Span) {
+	ctx, span := tracing.Start(b.ctx, b.Tracer, name, append(attrs, tracing.Bucket.String(b.Name))...)
+	return b.WithContext(ctx), span
+}
+
+// log returns the bucket's logger with the bucket name attached.
+func (b *Bucket) log() logging.Logger {
+	return logging.Or(b.Logger).WithFields(logging.Fields{
+		"bucket": b.Name,
+	})
+}
+
+// call runs an S3 call under the bucket's retry policy and wraps any error it returns.
+func (b *Bucket) call(op string, fn func(ctx context.Context) error) (err error) {
+	ctx, span := tracing.Start(b.ctx, b.Tracer, op, tracing.Bucket.String(b.Name))
+	defer func() { tracing.End(span, err) }()
+	defer metrics.Since(b.Metrics, op, time.Now())
+	return wrapError(op, b.Retry.DoWithLogger(b.log(), op, func() error { return fn(ctx) }))
+}
+
+// processed records an object handled by op and the bytes moved in or out of the bucket.
+func (b *Bucket) processed(op string, bytesMetric string, n int64) {
+	dimensions := map[string]string{"Operation": op}
+	sink := metrics.Or(b.Metrics)
+	sink.Record(metrics.ObjectsProcessed, 1, metrics.Count, dimensions)
+	if bytesMetric != "" {
+		sink.Record(bytesMetric, float64(n), metrics.Bytes, dimensions)
+	}
+}
+
+// rewind moves a request body back to the start so a retried upload sends all of it.
+func rewind(body io.Seeker) {
+	body.Seek(0, io.SeekStart)
 }
 
 // ReadFile looks through the bucket and reads the first file.
 // It returns the contents of the file, its key and/or potentially an error.
-func (b *Bucket) ReadFile() (string, string, error) {
-	log.WithFields(log.Fields{
-		"bucket": b.Name,
-	}).Debug("Reading bucket")
+func (b *Bucket) ReadFile() (contents string, key string, err error) {
+	b, span := b.startSpan("storage.ReadFile")
+	defer func() {
+		span.SetAttributes(tracing.Key.String(key), tracing.Bytes.Int(len(contents)))
+		tracing.End(span, err)
+	}()
+
+	b.log().Debug("Reading bucket")
 
 	query := &s3.ListObjectsV2Input{
 		Bucket: aws.String(b.Name),
 	}
 
-	resp, err := b.Client.ListObjectsV2(query)
+	var resp *s3.ListObjectsV2Output
+	err = b.call("s3:ListObjectsV2", func(ctx context.Context) (err error) {
+		resp, err = b.Client.ListObjectsV2WithContext(ctx, query)
+		return err
+	})
 
 	if err != nil {
-		log.Error("Unable to query bucket")
+		b.log().Error("Unable to query bucket")
 		return "", "", err
 	}
 
 	if len(resp.Contents) < 1 {
-		return "", "", errors.New("No files in bucket")
+		return "", "", ErrEmptyBucket
 	}
 
 	for _, key := range resp.Contents {
 
-		log.WithFields(log.Fields{
-			"file": key.Key,
+		b.log().WithFields(logging.Fields{
+			"key": *key.Key,
 		}).Debug("Reading File...")
 
 		input := &s3.GetObjectInput{
@@ -56,59 +153,72 @@ func (b *Bucket) ReadFile() (string, string, error) {
 			Key:    key.Key,
 		}
 
-		result, err := b.Client.GetObject(input)
+		var result *s3.GetObjectOutput
+		err := b.call("s3:GetObject", func(ctx context.Context) (err error) {
+			result, err = b.Client.GetObjectWithContext(ctx, input)
+			return err
+		})
 
 		if err != nil {
-			log.Error("Failed to get the file")
+			b.log().Error("Failed to get the file")
 			return "", "", err
 		}
 
-		log.Info(result)
+		b.log().WithFields(logging.Fields{
+			"key":           *key.Key,
+			"contentLength": aws.Int64Value(result.ContentLength),
+		}).Debug("Got file")
 
 		body, err := ioutil.ReadAll(result.Body)
 		if err != nil {
-			log.Error("Unable to read bytes")
+			b.log().Error("Unable to read bytes")
 			return "", "", err
 		}
+		b.processed("ReadFile", metrics.BytesDownloaded, int64(len(body)))
 
 		return string(body[:]), *key.Key, nil
-		break
 	}
 	return "", "", nil
 }
 
 // DeleteObject takes the name of a bucket and a key of of an object in the bucket.
 // It will then delete that object if it can find it.
-func (b *Bucket) DeleteObject(key string) error {
-	_, err := b.Client.DeleteObject(&s3.DeleteObjectInput{
-		Bucket: aws.String(b.Name),
-		Key:    aws.String(key),
+func (b *Bucket) DeleteObject(key string) (err error) {
+	b, span := b.startSpan("storage.DeleteObject", tracing.Key.String(key))
+	defer func() { tracing.End(span, err) }()
+
+	err = b.call("s3:DeleteObject", func(ctx context.Context) (err error) {
+		_, err = b.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(b.Name),
+			Key:    aws.String(key),
+		})
+		return err
 	})
 
 	if err != nil {
-		log.WithFields(log.Fields{
-			"bucket": b.Name,
-			"key":    key,
+		b.log().WithFields(logging.Fields{
+			"key": key,
 		}).Error("Failed to delete")
 		return err
 	}
 
-	err = b.Client.WaitUntilObjectNotExists(&s3.HeadObjectInput{
-		Bucket: aws.String(b.Name),
-		Key:    aws.String(key),
+	err = b.call("s3:WaitUntilObjectNotExists", func(ctx context.Context) (err error) {
+		return b.Client.WaitUntilObjectNotExistsWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(b.Name),
+			Key:    aws.String(key),
+		})
 	})
 
 	if err != nil {
-		log.WithFields(log.Fields{
-			"bucket": b.Name,
-			"key":    key,
+		b.log().WithFields(logging.Fields{
+			"key": key,
 		}).Error("Failed to delete")
 		return err
 	}
 
-	log.WithFields(log.Fields{
-		"bucket": b.Name,
-		"key":    key,
+	b.processed("DeleteObject", "", 0)
+	b.log().WithFields(logging.Fields{
+		"key": key,
 	}).Info("Successfully deleted")
 	return nil
 }
@@ -117,21 +227,83 @@ func (b *Bucket) DeleteObject(key string) error {
 // Currently writes the object with the prefix /content/post and
 // suffix .md
 // It takes the body, and a fileName as the key
-func (b *Bucket) UploadFile(fileName string, body string) error {
-	objectPath := "/content/post/" + fileName + ".md"
+func (b *Bucket) UploadFile(fileName string, body string) (err error) {
+	return b.uploadPost("storage.UploadFile", fileName, body)
+}
+
+// CreateFile writes a post like UploadFile, but only if there isn't one at
+// that key already. It returns ErrObjectExists when there is, so two writers
+// can't both take the same name.
+func (b *Bucket) CreateFile(fileName string, body string) (err error) {
+	err = b.uploadPost("storage.CreateFile", fileName, body, func(u *s3manager.Uploader) {
+		u.RequestOptions = append(u.RequestOptions, ifNoneMatch("*"))
+	})
+	if isPreconditionFailed(err) {
+		return fmt.Errorf("%w: %s", ErrObjectExists, PostKey(fileName))
+	}
+	return err
+}
+
+// PostKey is the key UploadFile and CreateFile write the post named fileName to.
+func PostKey(fileName string) string {
+	return "/content/post/" + fileName + ".md"
+}
+
+func (b *Bucket) uploadPost(op string, fileName string, body string, options ...func(*s3manager.Uploader)) (err error) {
+	objectPath := PostKey(fileName)
+	b, span := b.startSpan(op, tracing.Key.String(objectPath), tracing.Bytes.Int(len(body)))
+	defer func() { tracing.End(span, err) }()
 
 	fileReader := strings.NewReader(body)
 
-	_, err := b.Manager.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(b.Name),
-		Key:    aws.String(objectPath),
-		Body:   fileReader,
+	err = b.call("s3manager:Upload", func(ctx context.Context) (err error) {
+		rewind(fileReader)
+		_, err = b.Manager.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:  aws.String(b.Name),
+			Key:     aws.String(objectPath),
+			Body:    fileReader,
+			Tagging: uploadTagging(b.UploadTags),
+		}, options...)
+		return err
 	})
 
 	if err != nil {
-		log.Error("Failed to upload")
+		if !isPreconditionFailed(err) {
+			b.log().Error("Failed to upload")
+		}
 		return err
 	}
+	b.processed(strings.TrimPrefix(op, "storage."), metrics.BytesUploaded, int64(len(body)))
+
+	return nil
+}
+
+// UploadObject writes data to key with the given content type.
+func (b *Bucket) UploadObject(key string, data []byte, contentType string) (err error) {
+	b, span := b.startSpan("storage.UploadObject", tracing.Key.String(key), tracing.Bytes.Int(len(data)))
+	defer func() { tracing.End(span, err) }()
+
+	body := bytes.NewReader(data)
+
+	err = b.call("s3manager:Upload", func(ctx context.Context) (err error) {
+		rewind(body)
+		_, err = b.Manager.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:      aws.String(b.Name),
+			Key:         aws.String(key),
+			Body:        body,
+			ContentType: optionalString(contentType),
+			Tagging:     uploadTagging(b.UploadTags),
+		})
+		return err
+	})
+
+	if err != nil {
+		b.log().WithFields(logging.Fields{
+			"key": key,
+		}).Error("Failed to upload")
+		return err
+	}
+	b.processed("UploadObject", metrics.BytesUploaded, int64(len(data)))
 
 	return nil
 }
@@ -140,10 +312,13 @@ const tempDir = "/tmp/site/"
 
 // DownloadAllObjectsInBucket downloads all objects it finds in a bucket
 // to /tmp/site
-func (b *Bucket) DownloadAllObjectsInBucket(destDir string, otherDirs ...string) error {
-	query := &s3.ListObjectsV2Input{
-		Bucket: aws.String(b.Name),
-	}
+func (b *Bucket) DownloadAllObjectsInBucket(destDir string, otherDirs ...string) (err error) {
+	objects := 0
+	b, span := b.startSpan("storage.DownloadAllObjectsInBucket")
+	defer func() {
+		span.SetAttributes(tracing.KeyCount.Int(objects))
+		tracing.End(span, err)
+	}()
 
 	if string(destDir[len(destDir)-1:]) != "/" {
 		destDir += "/"
@@ -157,42 +332,121 @@ func (b *Bucket) DownloadAllObjectsInBucket(destDir string, otherDirs ...string)
 		}
 	}
 
-	truncatedListing := true
+	err = b.listObjects("", func(contents []*s3.Object) (bool, error) {
+		objects += len(contents)
+		return true, dowloadObjectsInBucket(contents, *b, destDir)
+	})
+	if err != nil {
+		b.log().Error("Failed to download objects")
+		return err
+	}
+
+	return nil
+}
 
-	for truncatedListing {
-		resp, err := b.Client.ListObjectsV2(query)
+// Keys lists the keys of every object whose key starts with prefix.
+func (b *Bucket) Keys(prefix string) (keys []string, err error) {
+	b, span := b.startSpan("storage.Keys", tracing.Key.String(prefix))
+	defer func() {
+		span.SetAttributes(tracing.KeyCount.Int(len(keys)))
+		tracing.End(span, err)
+	}()
+
+	err = b.listObjects(prefix, func(contents []*s3.Object) (bool, error) {
+		for _, object := range contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true, nil
+	})
+	if err != nil {
+		b.log().WithFields(logging.Fields{
+			"key": prefix,
+		}).Error("Failed to list objects")
+		return keys, err
+	}
+	return keys, nil
+}
 
+// listObjects pages through the objects whose keys start with prefix, calling
+// fn with each page until fn returns false or an error, or the listing ends.
+func (b *Bucket) listObjects(prefix string, fn func(contents []*s3.Object) (bool, error)) error {
+	query := &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.Name),
+	}
+	if prefix != "" {
+		query.Prefix = aws.String(prefix)
+	}
+	for {
+		var resp *s3.ListObjectsV2Output
+		err := b.call("s3:ListObjectsV2", func(ctx context.Context) (err error) {
+			resp, err = b.Client.ListObjectsV2WithContext(ctx, query)
+			return err
+		})
 		if err != nil {
-			log.WithFields(log.Fields{
-				"query": query,
-			}).Error("Failed to list objects")
 			return err
 		}
-
-		err = dowloadObjectsInBucket(resp, *b, destDir)
-		if err != nil {
+		more, err := fn(resp.Contents)
+		if err != nil || !more {
 			return err
 		}
+		if !aws.BoolValue(resp.IsTruncated) {
+			return nil
+		}
+		query.ContinuationToken = resp.NextContinuationToken
+	}
+}
+
+// SymlinkPolicy controls what Upload does when it finds a symbolic link.
+type SymlinkPolicy int
+
+const (
+	// SymlinkUploadTarget uploads the contents of the file a link points to
+	// but does not descend into linked directories. This is the default.
+	SymlinkUploadTarget SymlinkPolicy = iota
+	// SymlinkFollow uploads linked files and descends into linked directories.
+	SymlinkFollow
+	// SymlinkSkip ignores symbolic links entirely.
+	SymlinkSkip
+)
+
+// UploadFailure records a file that could not be uploaded and why.
+type UploadFailure struct {
+	Path string
+	Key  string
+	Err  error
+}
+
+// UploadReport lists what happened to each file Upload came across.
+type UploadReport struct {
+	Uploaded []string
+	Skipped  []string
+	Failed   []UploadFailure
+}
 
-		truncatedListing = *resp.IsTruncated
+// Err returns an error summarising the failed uploads, or nil if there were none.
+func (r *UploadReport) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
 	}
+	return fmt.Errorf("%d of %d files failed to upload, first error: %s: %v",
+		len(r.Failed), len(r.Failed)+len(r.Uploaded), r.Failed[0].Path, r.Failed[0].Err)
+}
 
-	return nil
+func objectKey(inFile string, path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(inFile), filepath.ToSlash(path))
 }
 
 func uploadFile(inFile string, path string, b Bucket) error {
 	actualFile, err := os.Open(inFile)
 	if err != nil {
-		log.Error("Unable to open file to write to")
+		b.log().Error("Unable to open file to write to")
 		return err
 	}
 	defer actualFile.Close()
-	file := strings.TrimPrefix(filepath.ToSlash(inFile), filepath.ToSlash(path))
-	filePath := filepath.ToSlash(file)
-	log.WithFields(log.Fields{
-		"file":     file,
-		"filePath": filePath,
-		"inFile":   inFile,
+	filePath := objectKey(inFile, path)
+	b.log().WithFields(logging.Fields{
+		"key":  filePath,
+		"path": inFile,
 	}).Debug("File being uploaded")
 
 	contentType := "text/html"
@@ -202,112 +456,192 @@ func uploadFile(inFile string, path string, b Bucket) error {
 		contentType = "text/css"
 	}
 
-	_, err = b.Manager.Upload(&s3manager.UploadInput{
-		Bucket:      aws.String(b.Name),
-		Key:         aws.String(filePath),
-		Body:        actualFile,
-		ContentType: aws.String(contentType),
+	err = b.call("s3manager:Upload", func(ctx context.Context) (err error) {
+		rewind(actualFile)
+		_, err = b.Manager.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:      aws.String(b.Name),
+			Key:         aws.String(filePath),
+			Body:        actualFile,
+			ContentType: aws.String(contentType),
+			Tagging:     uploadTagging(b.UploadTags),
+		})
+		return err
 	})
 
 	if err != nil {
-		log.Error("Unable to upload file")
+		b.log().Error("Unable to upload file")
 		return err
 	}
+	if stat, err := actualFile.Stat(); err == nil {
+		b.processed("Upload", metrics.BytesUploaded, stat.Size())
+	}
 	return nil
 }
 
-// Upload takes all the files in the given path and uploads them to the specified bucket
-func (b *Bucket) Upload(path string) error {
-	err := godirwalk.Walk(path, &godirwalk.Options{
+// Upload takes all the files in the given path and uploads them to the specified bucket.
+// Symbolic links are handled according to b.Symlinks. If b.ContinueOnError is set a
+// file that can't be read or uploaded is recorded in the report and the walk carries on,
+// otherwise Upload stops at the first failure.
+// The returned report is always populated with whatever was done before returning.
+func (b *Bucket) Upload(path string) (report *UploadReport, err error) {
+	report = &UploadReport{}
+	b, span := b.startSpan("storage.Upload")
+	defer func() {
+		span.SetAttributes(tracing.KeyCount.Int(len(report.Uploaded)))
+		tracing.End(span, err)
+	}()
+
+	err = godirwalk.Walk(path, &godirwalk.Options{
 		Callback: func(osPathname string, de *godirwalk.Dirent) error {
-			if !isDirectory(osPathname) {
-				log.WithFields(log.Fields{
-					"osPathName": osPathname,
-					"path":       path,
-				}).Debug()
-				return uploadFile(osPathname, path, *b)
+			if de.IsDir() {
+				return nil
+			}
+			if de.IsSymlink() {
+				if b.Symlinks == SymlinkSkip {
+					report.Skipped = append(report.Skipped, osPathname)
+					return nil
+				}
+				fd, err := os.Stat(osPathname)
+				if err != nil {
+					return err
+				}
+				if fd.IsDir() {
+					if b.Symlinks != SymlinkFollow {
+						report.Skipped = append(report.Skipped, osPathname)
+					}
+					return nil
+				}
+			}
+			b.log().WithFields(logging.Fields{
+				"path": osPathname,
+			}).Debug("Uploading file")
+			if b.RedirectsFile != "" && strings.TrimPrefix(objectKey(osPathname, path), "/") == b.RedirectsFile {
+				keys, err := b.uploadRedirectsFile(osPathname, path)
+				report.Uploaded = append(report.Uploaded, keys...)
+				return err
 			}
+			if err := uploadFile(osPathname, path, *b); err != nil {
+				return err
+			}
+			report.Uploaded = append(report.Uploaded, objectKey(osPathname, path))
 			return nil
 		},
-		Unsorted: true,
+		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
+			report.Failed = append(report.Failed, UploadFailure{
+				Path: osPathname,
+				Key:  objectKey(osPathname, path),
+				Err:  pkgerrors.Cause(err),
+			})
+			b.log().WithFields(logging.Fields{
+				"path":  osPathname,
+				"error": err,
+			}).Error("Failed to upload path")
+			if b.ContinueOnError {
+				return godirwalk.SkipNode
+			}
+			return godirwalk.Halt
+		},
+		FollowSymbolicLinks: b.Symlinks == SymlinkFollow,
+		Unsorted:            true,
 	})
 	if err != nil {
-		log.WithFields(log.Fields{
+		b.log().WithFields(logging.Fields{
 			"error": err,
 		}).Error("Failed to get file paths to upload")
-		return err
+		return report, err
 	}
 
-	return nil
+	if b.AfterUpload != nil {
+		if err := b.AfterUpload(report); err != nil {
+			b.log().WithFields(logging.Fields{
+				"error": err,
+			}).Error("After upload hook failed")
+			return report, err
+		}
+	}
 
+	return report, report.Err()
 }
 
-func isDirectory(path string) bool {
-	fd, err := os.Stat(path)
+func (b *Bucket) uploadRedirectsFile(path string, root string) ([]string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("Failed to figure out if path was directory")
-		os.Exit(2)
+		return nil, err
 	}
-	switch mode := fd.Mode(); {
-	case mode.IsDir():
-		return true
-	case mode.IsRegular():
-		return false
+	defer f.Close()
+
+	redirects, err := ParseRedirects(f)
+	if err != nil {
+		return nil, err
 	}
-	return false
+	return b.applyRedirects(redirects, root)
 }
 
-func dowloadObjectsInBucket(bucketObjectsList *s3.ListObjectsV2Output, b Bucket, destDir string) error {
-
-	for _, key := range bucketObjectsList.Contents {
-		log.Debug(*key.Key)
-		destFileName := *key.Key
-
-		if strings.Contains(*key.Key, "/") {
-			var dirTree string
+func dowloadObjectsInBucket(contents []*s3.Object, b Bucket, destDir string) error {
 
-			s3FileFullPathList := strings.Split(*key.Key, "/")
+	for _, key := range contents {
 
-			for _, dir := range s3FileFullPathList[:len(s3FileFullPathList)-1] {
-				dirTree += "/" + dir
+		destFilePath, isDir, err := downloadPath(destDir, *key.Key)
+		if err != nil {
+			b.log().WithFields(logging.Fields{
+				"key":   *key.Key,
+				"error": err,
+			}).Warn("Unsafe key")
+			if b.UnsafeKeys == UnsafeKeyReject {
+				return err
 			}
-			log.Debug(fmt.Sprintf("making: %s%s", destDir, dirTree))
-			os.MkdirAll(destDir+dirTree, 0775)
+			continue
+		}
+
+		if isDir {
+			b.log().WithFields(logging.Fields{
+				"path": destFilePath,
+			}).Debug("Making folder")
+			os.MkdirAll(destFilePath, 0775)
+			continue
 		}
 
-		destFilePath := destDir + destFileName
+		b.log().WithFields(logging.Fields{
+			"path": filepath.Dir(destFilePath),
+		}).Debug("Making folder")
+		os.MkdirAll(filepath.Dir(destFilePath), 0775)
+
 		if _, err := os.Stat(destFilePath); !os.IsNotExist(err) {
-			log.WithFields(log.Fields{
-				"destFilePath": destFilePath,
-			}).Debug("Checking if file/dir Exitsts")
+			b.log().WithFields(logging.Fields{
+				"path": destFilePath,
+			}).Debug("File already exists, skipping")
 		} else {
-			log.WithFields(log.Fields{
-				"destFilePath": destFilePath,
-			}).Debug("Creating file/dir")
+			b.log().WithFields(logging.Fields{
+				"path": destFilePath,
+			}).Debug("Creating file")
 			destFile, err := os.Create(destFilePath)
 			if err != nil {
-				log.WithFields(log.Fields{
-					"destFile": destFile,
+				b.log().WithFields(logging.Fields{
+					"path":  destFilePath,
+					"error": err,
 				}).Error("Failed create file to download into")
 				return err
 			}
 
 			defer destFile.Close()
 
-			_, err = b.Manager.Download(destFile, &s3.GetObjectInput{
-				Bucket: aws.String(b.Name),
-				Key:    key.Key,
+			var n int64
+			err = b.call("s3manager:Download", func(ctx context.Context) (err error) {
+				n, err = b.Manager.DownloadWithContext(ctx, destFile, &s3.GetObjectInput{
+					Bucket: aws.String(b.Name),
+					Key:    key.Key,
+				})
+				return err
 			})
 
 			if err != nil {
-				log.WithFields(log.Fields{
-					"file":     key.Key,
-					"destFile": destFile,
+				b.log().WithFields(logging.Fields{
+					"key":  *key.Key,
+					"path": destFilePath,
 				}).Error("Failed to download file")
 				return err
 			}
+			b.processed("Download", metrics.BytesDownloaded, n)
 		}
 	}
 	return nil
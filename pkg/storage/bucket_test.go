@@ -2,18 +2,34 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/cstdev/lambdahelpers/pkg/awserror"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	"github.com/cstdev/lambdahelpers/pkg/metrics"
+	"github.com/cstdev/lambdahelpers/pkg/retry"
 	"github.com/cstdev/lambdahelpers/pkg/s3/manager"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const basePath = "testdata"
@@ -46,38 +62,91 @@ func ok(tb testing.TB, err error) {
 	}
 }
 
+func equals(tb testing.TB, expected, actual interface{}) {
+	if !reflect.DeepEqual(expected, actual) {
+		tb.Errorf("Expected: %v \n Actual: %v", expected, actual)
+	}
+}
+
 type mockedBucketAPI struct {
 	s3iface.S3API
 	manager.S3Manager
 	ListObjectsFunc  func(*s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error)
 	GetObjectFunc    func(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
 	WaitFunc         func(*s3.HeadObjectInput) error
+	HeadObjectFunc   func(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
 	DeleteObjectFunc func(*s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
 	UploadFunc       func(*s3manager.UploadInput, ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
 	DownloadFunc     func(io.WriterAt, *s3.GetObjectInput, ...func(*s3manager.Downloader)) (int64, error)
+
+	GetBucketWebsiteFunc func(*s3.GetBucketWebsiteInput) (*s3.GetBucketWebsiteOutput, error)
+	PutBucketWebsiteFunc func(*s3.PutBucketWebsiteInput) (*s3.PutBucketWebsiteOutput, error)
+
+	GetObjectTaggingFunc   func(*s3.GetObjectTaggingInput) (*s3.GetObjectTaggingOutput, error)
+	PutObjectTaggingFunc   func(*s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error)
+	GetBucketLifecycleFunc func(*s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error)
+	PutBucketLifecycleFunc func(*s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error)
+
+	PutObjectWithContextFunc    func(aws.Context, *s3.PutObjectInput, ...request.Option) (*s3.PutObjectOutput, error)
+	DeleteObjectWithContextFunc func(aws.Context, *s3.DeleteObjectInput, ...request.Option) (*s3.DeleteObjectOutput, error)
 }
 
-func (m mockedBucketAPI) ListObjectsV2(i *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+func (m mockedBucketAPI) ListObjectsV2WithContext(_ aws.Context, i *s3.ListObjectsV2Input, _ ...request.Option) (*s3.ListObjectsV2Output, error) {
 	return m.ListObjectsFunc(i)
 }
 
-func (m mockedBucketAPI) GetObject(i *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+func (m mockedBucketAPI) GetObjectWithContext(_ aws.Context, i *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
 	return m.GetObjectFunc(i)
 }
 
-func (m mockedBucketAPI) DeleteObject(i *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
-	return m.DeleteObjectFunc(i)
+func (m mockedBucketAPI) WaitUntilObjectNotExistsWithContext(_ aws.Context, i *s3.HeadObjectInput, _ ...request.WaiterOption) error {
+	return m.WaitFunc(i)
 }
 
-func (m mockedBucketAPI) WaitUntilObjectNotExists(i *s3.HeadObjectInput) error {
-	return m.WaitFunc(i)
+func (m mockedBucketAPI) HeadObjectWithContext(_ aws.Context, i *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	return m.HeadObjectFunc(i)
+}
+
+func (m mockedBucketAPI) GetBucketWebsiteWithContext(_ aws.Context, i *s3.GetBucketWebsiteInput, _ ...request.Option) (*s3.GetBucketWebsiteOutput, error) {
+	return m.GetBucketWebsiteFunc(i)
+}
+
+func (m mockedBucketAPI) PutBucketWebsiteWithContext(_ aws.Context, i *s3.PutBucketWebsiteInput, _ ...request.Option) (*s3.PutBucketWebsiteOutput, error) {
+	return m.PutBucketWebsiteFunc(i)
+}
+
+func (m mockedBucketAPI) GetObjectTaggingWithContext(_ aws.Context, i *s3.GetObjectTaggingInput, _ ...request.Option) (*s3.GetObjectTaggingOutput, error) {
+	return m.GetObjectTaggingFunc(i)
 }
 
-func (m mockedBucketAPI) Upload(input *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+func (m mockedBucketAPI) PutObjectTaggingWithContext(_ aws.Context, i *s3.PutObjectTaggingInput, _ ...request.Option) (*s3.PutObjectTaggingOutput, error) {
+	return m.PutObjectTaggingFunc(i)
+}
+
+func (m mockedBucketAPI) GetBucketLifecycleConfigurationWithContext(_ aws.Context, i *s3.GetBucketLifecycleConfigurationInput, _ ...request.Option) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	return m.GetBucketLifecycleFunc(i)
+}
+
+func (m mockedBucketAPI) PutBucketLifecycleConfigurationWithContext(_ aws.Context, i *s3.PutBucketLifecycleConfigurationInput, _ ...request.Option) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	return m.PutBucketLifecycleFunc(i)
+}
+
+func (m mockedBucketAPI) PutObjectWithContext(ctx aws.Context, i *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	return m.PutObjectWithContextFunc(ctx, i, opts...)
+}
+
+func (m mockedBucketAPI) DeleteObjectWithContext(ctx aws.Context, i *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	if m.DeleteObjectWithContextFunc != nil {
+		return m.DeleteObjectWithContextFunc(ctx, i, opts...)
+	}
+	return m.DeleteObjectFunc(i)
+}
+
+func (m mockedBucketAPI) UploadWithContext(_ aws.Context, input *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
 	return m.UploadFunc(input, options...)
 }
 
-func (m mockedBucketAPI) Download(w io.WriterAt, i *s3.GetObjectInput, options ...func(*s3manager.Downloader)) (int64, error) {
+func (m mockedBucketAPI) DownloadWithContext(_ aws.Context, w io.WriterAt, i *s3.GetObjectInput, options ...func(*s3manager.Downloader)) (int64, error) {
 	return m.DownloadFunc(w, i, options...)
 }
 
@@ -97,9 +166,79 @@ func TestReadFileReturnsErrorWhenBucketIsEmpty(t *testing.T) {
 	}
 
 	_, _, err := b.ReadFile()
-	if err == nil {
-		t.Error("Expected error to be returned but didn't receive one")
+	if !errors.Is(err, ErrEmptyBucket) {
+		t.Errorf("Expected ErrEmptyBucket, received: %v", err)
+	}
+}
+
+func TestReadFileReturnsObjectNotFoundWhenObjectHasGone(t *testing.T) {
+	bucketName := "testBucket"
+	key := "Object1"
+
+	b := Bucket{
+		Client: mockedBucketAPI{
+			ListObjectsFunc: func(*s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+				return &s3.ListObjectsV2Output{
+					Contents: []*s3.Object{{Key: &key}},
+				}, nil
+			},
+			GetObjectFunc: func(*s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+				return nil, awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "gone", nil), 404, "req-1")
+			},
+		},
+		Name: bucketName,
+	}
+
+	_, _, err := b.ReadFile()
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound, received: %v", err)
 	}
+	var aerr *awserror.Error
+	if !errors.As(err, &aerr) {
+		t.Fatalf("Expected *awserror.Error, received: %T", err)
+	}
+	equals(t, "req-1", aerr.RequestID)
+	equals(t, false, aerr.Retryable)
+}
+
+func TestReadFileTracesCallsUnderIncomingSpan(t *testing.T) {
+	key := "Object1"
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+
+	b := &Bucket{
+		Client: mockedBucketAPI{
+			ListObjectsFunc: func(*s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+				return &s3.ListObjectsV2Output{
+					Contents: []*s3.Object{{Key: &key}},
+				}, nil
+			},
+			GetObjectFunc: func(*s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+				return nil, awserr.New(s3.ErrCodeNoSuchKey, "gone", nil)
+			},
+		},
+		Name:   "testBucket",
+		Tracer: tracer,
+	}
+
+	ctx, handler := tracer.Start(context.Background(), "handler")
+	b.WithContext(ctx).ReadFile()
+	handler.End()
+
+	spans := exporter.GetSpans()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	equals(t, []string{"s3:ListObjectsV2", "s3:GetObject", "storage.ReadFile", "handler"}, names)
+
+	readFile := spans[2]
+	equals(t, handler.SpanContext().SpanID(), readFile.Parent.SpanID())
+	equals(t, readFile.SpanContext.SpanID(), spans[0].Parent.SpanID())
+	equals(t, readFile.SpanContext.SpanID(), spans[1].Parent.SpanID())
+	equals(t, codes.Error, spans[1].Status.Code)
+	equals(t, codes.Error, readFile.Status.Code)
+	equals(t, codes.Unset, spans[0].Status.Code)
 }
 
 func TestReadFileReturnsTheFirstObjectKey(t *testing.T) {
@@ -173,6 +312,78 @@ func TestReadFileReturnsTheBodyOfTheObject(t *testing.T) {
 	}
 }
 
+type logEntry struct {
+	level  string
+	msg    string
+	fields logging.Fields
+}
+
+// recordingLogger keeps every entry logged through it and its children.
+type recordingLogger struct {
+	entries *[]logEntry
+	fields  logging.Fields
+}
+
+func newRecordingLogger() recordingLogger {
+	return recordingLogger{entries: &[]logEntry{}, fields: logging.Fields{}}
+}
+
+func (r recordingLogger) WithFields(f logging.Fields) logging.Logger {
+	fields := logging.Fields{}
+	for k, v := range r.fields {
+		fields[k] = v
+	}
+	for k, v := range f {
+		fields[k] = v
+	}
+	return recordingLogger{entries: r.entries, fields: fields}
+}
+
+func (r recordingLogger) add(level, msg string) {
+	*r.entries = append(*r.entries, logEntry{level, msg, r.fields})
+}
+
+func (r recordingLogger) Debug(msg string) { r.add("debug", msg) }
+func (r recordingLogger) Info(msg string)  { r.add("info", msg) }
+func (r recordingLogger) Warn(msg string)  { r.add("warn", msg) }
+func (r recordingLogger) Error(msg string) { r.add("error", msg) }
+
+func TestReadFileLogsThroughInjectedLoggerWithBucketField(t *testing.T) {
+	bucketName := "testBucket"
+	key := "Object1"
+	logger := newRecordingLogger()
+
+	b := Bucket{
+		Client: mockedBucketAPI{
+			ListObjectsFunc: func(*s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+				return &s3.ListObjectsV2Output{
+					Contents: []*s3.Object{{Key: &key}},
+				}, nil
+			},
+			GetObjectFunc: func(*s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+				return &s3.GetObjectOutput{
+					Body: ioutil.NopCloser(bytes.NewReader([]byte("Hello"))),
+				}, nil
+			},
+		},
+		Name:   bucketName,
+		Logger: logger,
+	}
+
+	_, _, err := b.ReadFile()
+	ok(t, err)
+
+	if len(*logger.entries) == 0 {
+		t.Fatal("Expected ReadFile to log through the injected logger")
+	}
+	for _, entry := range *logger.entries {
+		equals(t, bucketName, entry.fields["bucket"])
+		if entry.level == "info" {
+			t.Errorf("Expected no info logs when reading, got %q", entry.msg)
+		}
+	}
+}
+
 // Delete tests
 func TestDeleteObjectCallsDeleteAndWaitsForObjectToNotExist(t *testing.T) {
 	isDeleteCalled := false
@@ -205,6 +416,49 @@ func TestDeleteObjectCallsDeleteAndWaitsForObjectToNotExist(t *testing.T) {
 	}
 }
 
+func TestWithContextCancelsSDKCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b := Bucket{
+		Client: mockedBucketAPI{
+			DeleteObjectWithContextFunc: func(ctx aws.Context, i *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+				return nil, ctx.Err()
+			},
+		},
+		Name: "TestBucket",
+	}
+
+	err := b.WithContext(ctx).DeleteObject("Object1")
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancelled context to reach the SDK, received: %v", err)
+	}
+}
+
+func TestCallsPassTheirSpanToTheSDK(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	var sdkSpan trace.SpanContext
+	b := Bucket{
+		Client: mockedBucketAPI{
+			DeleteObjectWithContextFunc: func(ctx aws.Context, i *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+				sdkSpan = trace.SpanContextFromContext(ctx)
+				return &s3.DeleteObjectOutput{}, nil
+			},
+			WaitFunc: func(*s3.HeadObjectInput) error {
+				return nil
+			},
+		},
+		Name:   "TestBucket",
+		Tracer: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test"),
+	}
+
+	ok(t, b.DeleteObject("Object1"))
+
+	spans := exporter.GetSpans()
+	equals(t, "s3:DeleteObject", spans[0].Name)
+	equals(t, spans[0].SpanContext.SpanID(), sdkSpan.SpanID())
+}
+
 // Upload tests
 func TestUploadFileCallsUploaderWithBucketAndKey(t *testing.T) {
 	isUploadCalled := false
@@ -244,6 +498,79 @@ func TestUploadFileCallsUploaderWithBucketAndKey(t *testing.T) {
 	}
 }
 
+func TestUploadFileRecordsBytesUploaded(t *testing.T) {
+	sink := &metrics.Memory{}
+	b := Bucket{
+		Manager: mockedBucketAPI{
+			UploadFunc: func(i *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+		Name:    "TestBucket",
+		Metrics: sink,
+	}
+
+	ok(t, b.UploadFile("TestFile", "Some content"))
+
+	equals(t, float64(12), sink.Sum(metrics.BytesUploaded))
+	equals(t, float64(1), sink.Sum(metrics.ObjectsProcessed))
+	equals(t, "s3manager:Upload", sink.Records[0].Dimensions["Operation"])
+}
+
+func TestCreateFileReturnsErrObjectExistsWhenKeyTaken(t *testing.T) {
+	var uploaded []string
+	b := Bucket{
+		Manager: mockedBucketAPI{
+			UploadFunc: func(i *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				u := &s3manager.Uploader{}
+				for _, option := range options {
+					option(u)
+				}
+				if conditions(u.RequestOptions).Get("If-None-Match") == "*" && *i.Key == "/content/post/taken.md" {
+					return nil, awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+				}
+				uploaded = append(uploaded, *i.Key)
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+
+	err := b.CreateFile("taken", "Some content")
+	if !errors.Is(err, ErrObjectExists) {
+		t.Errorf("Expected ErrObjectExists, received: %v", err)
+	}
+	ok(t, b.CreateFile("free", "Some content"))
+	equals(t, []string{"/content/post/free.md"}, uploaded)
+}
+
+type noSleepClock struct{}
+
+func (noSleepClock) Now() time.Time        { return time.Time{} }
+func (noSleepClock) Sleep(d time.Duration) {}
+
+func TestUploadFileRetriesWithWholeBody(t *testing.T) {
+	var bodies []string
+	b := Bucket{
+		Manager: mockedBucketAPI{
+			UploadFunc: func(i *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				data, _ := ioutil.ReadAll(i.Body)
+				bodies = append(bodies, string(data))
+				if len(bodies) == 1 {
+					return nil, awserr.New("SlowDown", "Reduce your request rate", nil)
+				}
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+		Name:  "TestBucket",
+		Retry: &retry.Policy{MaxAttempts: 2, Clock: noSleepClock{}},
+	}
+
+	err := b.UploadFile("TestFile", "Some content")
+	ok(t, err)
+	equals(t, []string{"Some content", "Some content"}, bodies)
+}
+
 func TestUploadSendsAllFilesInDirectoryToUpload(t *testing.T) {
 	var keys []string
 
@@ -268,7 +595,7 @@ func TestUploadSendsAllFilesInDirectoryToUpload(t *testing.T) {
 		},
 	}
 
-	err := bucket.Upload(srcFilePath)
+	_, err := bucket.Upload(srcFilePath)
 	ok(t, err)
 
 	var found bool
@@ -290,6 +617,131 @@ func TestUploadSendsAllFilesInDirectoryToUpload(t *testing.T) {
 	}
 }
 
+func symlinkTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "symlinkUpload")
+	ok(t, err)
+	ok(t, os.Mkdir(filepath.Join(dir, "linked"), 0777))
+	ok(t, ioutil.WriteFile(filepath.Join(dir, "real.md"), []byte("real"), 0666))
+	ok(t, ioutil.WriteFile(filepath.Join(dir, "linked", "inner.md"), []byte("inner"), 0666))
+	ok(t, os.Symlink(filepath.Join(dir, "real.md"), filepath.Join(dir, "file-link.md")))
+	ok(t, os.Symlink(filepath.Join(dir, "linked"), filepath.Join(dir, "dir-link")))
+	return dir
+}
+
+func uploadedKeys(keys *[]string) mockedBucketAPI {
+	return mockedBucketAPI{
+		UploadFunc: func(i *s3manager.UploadInput, up ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+			*keys = append(*keys, *i.Key)
+			return &s3manager.UploadOutput{}, nil
+		},
+	}
+}
+
+func TestUploadHandlesSymlinksByPolicy(t *testing.T) {
+	dir := symlinkTestDir(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		policy   SymlinkPolicy
+		expected []string
+	}{
+		{SymlinkUploadTarget, []string{"/file-link.md", "/linked/inner.md", "/real.md"}},
+		{SymlinkFollow, []string{"/dir-link/inner.md", "/file-link.md", "/linked/inner.md", "/real.md"}},
+		{SymlinkSkip, []string{"/linked/inner.md", "/real.md"}},
+	}
+
+	for _, test := range tests {
+		var keys []string
+		bucket := Bucket{
+			Name:     "DestBucket",
+			Manager:  uploadedKeys(&keys),
+			Symlinks: test.policy,
+		}
+
+		report, err := bucket.Upload(dir)
+		ok(t, err)
+
+		sort.Strings(keys)
+		sort.Strings(report.Uploaded)
+		equals(t, test.expected, keys)
+		equals(t, test.expected, report.Uploaded)
+	}
+}
+
+func TestUploadReturnsErrorForBrokenSymlinkWithoutExiting(t *testing.T) {
+	dir := symlinkTestDir(t)
+	defer os.RemoveAll(dir)
+	ok(t, os.Symlink(filepath.Join(dir, "missing.md"), filepath.Join(dir, "broken.md")))
+
+	var keys []string
+	bucket := Bucket{
+		Name:    "DestBucket",
+		Manager: uploadedKeys(&keys),
+	}
+
+	report, err := bucket.Upload(dir)
+	if err == nil {
+		t.Fatal("Expected error to be returned but didn't receive one")
+	}
+	equals(t, 1, len(report.Failed))
+	equals(t, "/broken.md", report.Failed[0].Key)
+}
+
+func TestUploadContinuesPastFailuresWhenConfigured(t *testing.T) {
+	dir := symlinkTestDir(t)
+	defer os.RemoveAll(dir)
+	ok(t, os.Symlink(filepath.Join(dir, "missing.md"), filepath.Join(dir, "broken.md")))
+
+	var keys []string
+	bucket := Bucket{
+		Name: "DestBucket",
+		Manager: mockedBucketAPI{
+			UploadFunc: func(i *s3manager.UploadInput, up ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				if *i.Key == "/real.md" {
+					return nil, errors.New("upload failed")
+				}
+				keys = append(keys, *i.Key)
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+		ContinueOnError: true,
+	}
+
+	report, err := bucket.Upload(dir)
+	if err == nil {
+		t.Fatal("Expected error summarising failures but didn't receive one")
+	}
+
+	var failed []string
+	for _, f := range report.Failed {
+		failed = append(failed, f.Key)
+	}
+	sort.Strings(failed)
+	sort.Strings(report.Uploaded)
+	equals(t, []string{"/broken.md", "/real.md"}, failed)
+	equals(t, []string{"/file-link.md", "/linked/inner.md"}, report.Uploaded)
+}
+
+func TestUploadCallsAfterUploadWithReport(t *testing.T) {
+	var keys []string
+	var hookReport *UploadReport
+	bucket := Bucket{
+		Name:    "DestBucket",
+		Manager: uploadedKeys(&keys),
+		AfterUpload: func(r *UploadReport) error {
+			hookReport = r
+			return nil
+		},
+	}
+
+	report, err := bucket.Upload(srcFilePath + "/testUpload")
+	ok(t, err)
+
+	if hookReport != report {
+		t.Error("Expected AfterUpload to receive the upload report")
+	}
+}
+
 func generateFilesToUpload(number int, benchmarkDir string) {
 	clearDirectories()
 	os.Mkdir(benchmarkDir, 0777)
@@ -332,12 +784,42 @@ func BenchmarkUploadSendsAllFilesInDirectoryToUpload(b *testing.B) {
 	}
 
 	for n := 0; n < b.N; n++ {
-		err := bucket.Upload(srcFilePath)
+		_, err := bucket.Upload(srcFilePath)
 		ok(b, err)
 	}
 
 }
 
+// List tests
+func TestKeysListsEveryPageUnderPrefix(t *testing.T) {
+	var prefixes []string
+	b := Bucket{
+		Client: mockedBucketAPI{
+			ListObjectsFunc: func(i *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+				prefixes = append(prefixes, *i.Prefix)
+				if i.ContinuationToken == nil {
+					return &s3.ListObjectsV2Output{
+						Contents:              []*s3.Object{{Key: aws.String("templates/a.txt")}},
+						IsTruncated:           aws.Bool(true),
+						NextContinuationToken: aws.String("page-2"),
+					}, nil
+				}
+				return &s3.ListObjectsV2Output{
+					Contents:    []*s3.Object{{Key: aws.String("templates/b.html")}},
+					IsTruncated: aws.Bool(false),
+				}, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+
+	keys, err := b.Keys("templates/")
+	ok(t, err)
+
+	equals(t, []string{"templates/a.txt", "templates/b.html"}, keys)
+	equals(t, []string{"templates/", "templates/"}, prefixes)
+}
+
 // Download Objects tests
 
 func downloadObjects(objectKey string, expectedPath string, tb testing.TB) {
@@ -387,6 +869,45 @@ func TestDownloadCreatesObjectInSubDirectory(t *testing.T) {
 
 }
 
+func TestDownloadAllObjectsInBucketFollowsEveryPage(t *testing.T) {
+	var tokens []string
+	var downloaded []string
+	bucket := Bucket{
+		Client: mockedBucketAPI{
+			ListObjectsFunc: func(i *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+				tokens = append(tokens, aws.StringValue(i.ContinuationToken))
+				if len(tokens) > 2 {
+					t.Fatal("Expected the listing to stop after the last page")
+				}
+				if i.ContinuationToken == nil {
+					return &s3.ListObjectsV2Output{
+						Contents:              []*s3.Object{{Key: aws.String("page1")}},
+						IsTruncated:           aws.Bool(true),
+						NextContinuationToken: aws.String("page-2"),
+					}, nil
+				}
+				return &s3.ListObjectsV2Output{
+					Contents:    []*s3.Object{{Key: aws.String("page2")}},
+					IsTruncated: aws.Bool(false),
+				}, nil
+			},
+		},
+		Manager: mockedBucketAPI{
+			DownloadFunc: func(w io.WriterAt, i *s3.GetObjectInput, d ...func(*s3manager.Downloader)) (int64, error) {
+				downloaded = append(downloaded, *i.Key)
+				return 0, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+
+	err := bucket.DownloadAllObjectsInBucket(destFilePath)
+	ok(t, err)
+
+	equals(t, []string{"", "page-2"}, tokens)
+	equals(t, []string{"page1", "page2"}, downloaded)
+}
+
 func TestDownloadWillCreateOtherDirsWhenTheyArePassed(t *testing.T) {
 	bucketName := "TestBucket"
 	objectKey := "Object1"
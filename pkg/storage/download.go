@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// UnsafeKeyPolicy controls what DownloadAllObjectsInBucket does with a key
+// that would land outside the destination directory or can't be a file name.
+type UnsafeKeyPolicy int
+
+const (
+	// UnsafeKeySkip logs and skips unsafe keys. This is the default.
+	UnsafeKeySkip UnsafeKeyPolicy = iota
+	// UnsafeKeyReject stops the download with an error wrapping ErrUnsafeKey.
+	UnsafeKeyReject
+)
+
+// reservedNames can't be used as file names on Windows, with or without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// downloadPath resolves where key should be written under destDir.
+// Leading slashes are dropped so keys written by UploadFile stay relative.
+// isDir is true for folder markers, keys ending in /, which only need the directory.
+// An error wrapping ErrUnsafeKey is returned for keys that escape destDir,
+// contain NUL bytes or backslashes, or use reserved names.
+func downloadPath(destDir string, key string) (path string, isDir bool, err error) {
+	if strings.ContainsAny(key, "\x00\\") {
+		return "", false, fmt.Errorf("%w: %q contains a NUL byte or backslash", ErrUnsafeKey, key)
+	}
+
+	relative := strings.TrimLeft(key, "/")
+	isDir = relative == "" || strings.HasSuffix(relative, "/")
+
+	for _, segment := range strings.Split(strings.TrimSuffix(relative, "/"), "/") {
+		name := strings.ToUpper(strings.SplitN(segment, ".", 2)[0])
+		if reservedNames[name] {
+			return "", false, fmt.Errorf("%w: %q uses the reserved name %s", ErrUnsafeKey, key, segment)
+		}
+	}
+
+	root, err := filepath.Abs(destDir)
+	if err != nil {
+		return "", false, err
+	}
+	path = filepath.Join(root, filepath.FromSlash(relative))
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false, fmt.Errorf("%w: %q resolves outside %s", ErrUnsafeKey, key, destDir)
+	}
+	if rel == "." && !isDir {
+		return "", false, fmt.Errorf("%w: %q resolves to the destination directory", ErrUnsafeKey, key)
+	}
+
+	return path, isDir, nil
+}
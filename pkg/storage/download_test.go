@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+func TestDownloadPathKeepsKeysInsideDestination(t *testing.T) {
+	root, err := filepath.Abs(destFilePath)
+	ok(t, err)
+
+	tests := []struct {
+		key   string
+		path  string
+		isDir bool
+	}{
+		{"Object1", filepath.Join(root, "Object1"), false},
+		{"/content/post/Object1.md", filepath.Join(root, "content", "post", "Object1.md"), false},
+		{"posts/", filepath.Join(root, "posts"), true},
+		{"posts/../Object1", filepath.Join(root, "Object1"), false},
+		{"/", root, true},
+	}
+
+	for _, test := range tests {
+		path, isDir, err := downloadPath(destFilePath, test.key)
+		ok(t, err)
+		equals(t, test.path, path)
+		equals(t, test.isDir, isDir)
+	}
+}
+
+func TestDownloadPathRejectsUnsafeKeys(t *testing.T) {
+	for _, key := range []string{
+		"../../etc/x",
+		"/../outside",
+		"posts/../../outside",
+		"..",
+		"posts\\..\\..\\x",
+		"nul\x00byte",
+		"CON",
+		"posts/aux.txt",
+		"posts/..",
+	} {
+		_, _, err := downloadPath(destFilePath, key)
+		if !errors.Is(err, ErrUnsafeKey) {
+			t.Errorf("Expected ErrUnsafeKey for %q, received: %v", key, err)
+		}
+	}
+}
+
+func downloadKeysBucket(policy UnsafeKeyPolicy, downloaded *[]string, keys ...string) Bucket {
+	var contents []*s3.Object
+	for _, key := range keys {
+		contents = append(contents, &s3.Object{Key: aws.String(key)})
+	}
+	return Bucket{
+		Client: mockedBucketAPI{
+			ListObjectsFunc: func(*s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+				return &s3.ListObjectsV2Output{Contents: contents, IsTruncated: aws.Bool(false)}, nil
+			},
+		},
+		Manager: mockedBucketAPI{
+			DownloadFunc: func(w io.WriterAt, i *s3.GetObjectInput, opts ...func(*s3manager.Downloader)) (int64, error) {
+				*downloaded = append(*downloaded, *i.Key)
+				return 0, nil
+			},
+		},
+		Name:       "TestBucket",
+		UnsafeKeys: policy,
+	}
+}
+
+func TestDownloadSkipsUnsafeKeysAndCreatesFolderMarkers(t *testing.T) {
+	clearDirectories()
+	defer clearDirectories()
+	var downloaded []string
+	bucket := downloadKeysBucket(UnsafeKeySkip, &downloaded, "../escaped", "drafts/", "posts/Object1")
+
+	err := bucket.DownloadAllObjectsInBucket(destFilePath)
+	ok(t, err)
+
+	equals(t, []string{"posts/Object1"}, downloaded)
+	if fi, err := os.Stat(filepath.Join(destFilePath, "drafts")); err != nil || !fi.IsDir() {
+		t.Error("Expected folder marker to be created as a directory")
+	}
+	if _, err := os.Stat(filepath.Join(destFilePath, "..", "escaped")); !os.IsNotExist(err) {
+		t.Error("Expected unsafe key not to be written")
+	}
+}
+
+func TestDownloadRejectsUnsafeKeysWhenConfigured(t *testing.T) {
+	clearDirectories()
+	defer clearDirectories()
+	var downloaded []string
+	bucket := downloadKeysBucket(UnsafeKeyReject, &downloaded, "../escaped", "posts/Object1")
+
+	err := bucket.DownloadAllObjectsInBucket(destFilePath)
+	if !errors.Is(err, ErrUnsafeKey) {
+		t.Errorf("Expected ErrUnsafeKey, received: %v", err)
+	}
+	equals(t, 0, len(downloaded))
+}
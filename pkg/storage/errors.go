@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cstdev/lambdahelpers/pkg/awserror"
+)
+
+var (
+	// ErrEmptyBucket is returned when a read finds no objects in the bucket.
+	ErrEmptyBucket = errors.New("No files in bucket")
+	// ErrObjectNotFound is matched by errors for objects that don't exist.
+	ErrObjectNotFound = errors.New("Object not found")
+	// ErrObjectTooLarge is returned when an object is bigger than Bucket.MaxReadSize.
+	ErrObjectTooLarge = errors.New("Object is larger than the maximum read size")
+	// ErrUnsafeKey is matched by errors for keys that can't be safely downloaded.
+	ErrUnsafeKey = errors.New("Unsafe object key")
+	// ErrLeaseHeld is returned when another worker holds an unexpired lease on an object,
+	// or has taken over the lease being renewed or released.
+	ErrLeaseHeld = errors.New("Object is claimed by another worker")
+	// ErrObjectExists is returned by CreateFile when the key is already taken.
+	ErrObjectExists = errors.New("Object already exists")
+	// ErrNoUnclaimedObjects is returned by ClaimNext when every object is claimed.
+	ErrNoUnclaimedObjects = errors.New("No unclaimed files in bucket")
+)
+
+// wrapError wraps an SDK error from op as an *awserror.Error,
+// marking missing objects as ErrObjectNotFound.
+func wrapError(op string, err error) error {
+	if isNotFound(err) {
+		return awserror.WrapKind(op, err, ErrObjectNotFound)
+	}
+	return awserror.Wrap(op, err)
+}
+
+func isNotFound(err error) bool {
+	switch awserror.Code(err) {
+	case s3.ErrCodeNoSuchKey, "NotFound":
+		return true
+	}
+	return false
+}
+
+// isPreconditionFailed reports whether a conditional write lost to another writer.
+func isPreconditionFailed(err error) bool {
+	switch awserror.Code(err) {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+)
+
+// DefaultLeasePrefix is where lease objects are kept when Bucket.LeasePrefix is empty.
+const DefaultLeasePrefix = "leases/"
+
+// now is swapped out in tests to move leases through time.
+var now = time.Now
+
+// Lease is a claim on an object held by one worker until it expires.
+// The lease is stored as its own object and written with S3 conditional
+// requests, so only one worker can create or replace it at a time.
+type Lease struct {
+	Key     string
+	Owner   string
+	Expires time.Time
+
+	etag string
+}
+
+type leaseBody struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// Expired reports whether the lease has run out.
+func (l *Lease) Expired() bool {
+	return !now().Before(l.Expires)
+}
+
+// Claim takes a lease on key for owner. If another worker holds a lease
+// that has expired it is taken over, otherwise ErrLeaseHeld is returned.
+// An unexpired lease already held by owner is returned as it is, as that is
+// what a retried write finds when the response to the first was lost, so
+// owners must be unique to each worker.
+func (b *Bucket) Claim(key string, owner string, ttl time.Duration) (*Lease, error) {
+	lease := &Lease{Key: key, Owner: owner, Expires: now().Add(ttl)}
+
+	err := b.putLease(lease, ifNoneMatch("*"))
+	if err == nil {
+		return lease, nil
+	}
+	if !isPreconditionFailed(err) {
+		return nil, err
+	}
+
+	existing, err := b.readLease(key)
+	if err != nil {
+		if isNotFound(err) {
+			// Released between our write and read, let the caller try again.
+			return nil, ErrLeaseHeld
+		}
+		return nil, err
+	}
+	if !existing.Expired() {
+		if existing.Owner == owner {
+			return existing, nil
+		}
+		return nil, ErrLeaseHeld
+	}
+
+	b.log().WithFields(logging.Fields{
+		"key":           key,
+		"owner":         owner,
+		"previousOwner": existing.Owner,
+	}).Info("Taking over expired lease")
+
+	err = b.putLease(lease, ifMatch(existing.etag))
+	if isPreconditionFailed(err) {
+		return nil, ErrLeaseHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// ClaimNext claims the first object in the bucket that no other worker holds,
+// skipping the lease objects themselves and any key under one of the skip prefixes.
+func (b *Bucket) ClaimNext(owner string, ttl time.Duration, skip ...string) (*Lease, error) {
+	prefixes := append(append([]string{}, skip...), b.leasePrefix())
+	var lease *Lease
+	err := b.listObjects("", func(contents []*s3.Object) (bool, error) {
+		for _, object := range contents {
+			key := aws.StringValue(object.Key)
+			if hasAnyPrefix(key, prefixes) {
+				continue
+			}
+			var err error
+			lease, err = b.Claim(key, owner, ttl)
+			if errors.Is(err, ErrLeaseHeld) {
+				continue
+			}
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		b.log().Error("Unable to query bucket")
+		return nil, err
+	}
+	if lease == nil {
+		return nil, ErrNoUnclaimedObjects
+	}
+	return lease, nil
+}
+
+// RenewLease extends a lease by ttl from now. It returns ErrLeaseHeld if
+// the lease has been taken over by another worker.
+func (b *Bucket) RenewLease(lease *Lease, ttl time.Duration) error {
+	renewed := *lease
+	renewed.Expires = now().Add(ttl)
+
+	err := b.putLease(&renewed, ifMatch(lease.etag))
+	if isPreconditionFailed(err) {
+		return ErrLeaseHeld
+	}
+	if err != nil {
+		return err
+	}
+
+	*lease = renewed
+	return nil
+}
+
+// ReleaseLease deletes a lease so the object can be claimed straight away.
+// It returns ErrLeaseHeld if the lease has been taken over by another worker.
+func (b *Bucket) ReleaseLease(lease *Lease) error {
+	err := b.call("s3:DeleteObject", func(ctx context.Context) (err error) {
+		_, err = b.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(b.Name),
+			Key:    aws.String(b.leaseKey(lease.Key)),
+		}, ifMatch(lease.etag))
+		return err
+	})
+	if isPreconditionFailed(err) {
+		return ErrLeaseHeld
+	}
+	if err != nil {
+		b.log().WithFields(logging.Fields{
+			"key": lease.Key,
+		}).Error("Failed to release lease")
+		return err
+	}
+
+	return nil
+}
+
+func (b *Bucket) leasePrefix() string {
+	if b.LeasePrefix == "" {
+		return DefaultLeasePrefix
+	}
+	return b.LeasePrefix
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if prefix != "" && strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bucket) leaseKey(key string) string {
+	return b.leasePrefix() + strings.TrimPrefix(key, "/")
+}
+
+func (b *Bucket) putLease(lease *Lease, condition request.Option) error {
+	data, err := json.Marshal(leaseBody{Owner: lease.Owner, Expires: lease.Expires})
+	if err != nil {
+		return err
+	}
+	body := bytes.NewReader(data)
+
+	var resp *s3.PutObjectOutput
+	err = b.call("s3:PutObject", func(ctx context.Context) (err error) {
+		rewind(body)
+		resp, err = b.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(b.Name),
+			Key:         aws.String(b.leaseKey(lease.Key)),
+			Body:        body,
+			ContentType: aws.String("application/json"),
+		}, condition)
+		return err
+	})
+	if err != nil {
+		if !isPreconditionFailed(err) {
+			b.log().WithFields(logging.Fields{
+				"key": lease.Key,
+			}).Error("Failed to write lease")
+		}
+		return err
+	}
+
+	lease.etag = aws.StringValue(resp.ETag)
+	return nil
+}
+
+func (b *Bucket) readLease(key string) (*Lease, error) {
+	var resp *s3.GetObjectOutput
+	err := b.call("s3:GetObject", func(ctx context.Context) (err error) {
+		resp, err = b.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(b.Name),
+			Key:    aws.String(b.leaseKey(key)),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var body leaseBody
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("Invalid lease for %s: %w", key, err)
+	}
+
+	return &Lease{
+		Key:     key,
+		Owner:   body.Owner,
+		Expires: body.Expires,
+		etag:    aws.StringValue(resp.ETag),
+	}, nil
+}
+
+func ifNoneMatch(etag string) request.Option {
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set("If-None-Match", etag)
+	}
+}
+
+func ifMatch(etag string) request.Option {
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set("If-Match", etag)
+	}
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// fakeLeaseStore keeps objects in memory and honours If-Match and
+// If-None-Match the way S3 conditional requests do.
+type fakeLeaseStore struct {
+	objects map[string][]byte
+	etags   map[string]string
+	writes  int
+}
+
+func newFakeLeaseStore() *fakeLeaseStore {
+	return &fakeLeaseStore{objects: map[string][]byte{}, etags: map[string]string{}}
+}
+
+func conditions(opts []request.Option) http.Header {
+	r := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r.HTTPRequest.Header
+}
+
+func (f *fakeLeaseStore) check(key string, opts []request.Option) error {
+	h := conditions(opts)
+	etag, exists := f.etags[key]
+	if h.Get("If-None-Match") == "*" && exists {
+		return awserr.New("PreconditionFailed", "exists", nil)
+	}
+	if m := h.Get("If-Match"); m != "" && m != etag {
+		return awserr.New("PreconditionFailed", "etag changed", nil)
+	}
+	return nil
+}
+
+func (f *fakeLeaseStore) bucket(keys ...string) Bucket {
+	var contents []*s3.Object
+	for _, key := range keys {
+		contents = append(contents, &s3.Object{Key: aws.String(key)})
+	}
+	return Bucket{
+		Name: "TestBucket",
+		Client: mockedBucketAPI{
+			ListObjectsFunc: func(*s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+				return &s3.ListObjectsV2Output{Contents: contents, IsTruncated: aws.Bool(false)}, nil
+			},
+			PutObjectWithContextFunc: func(_ aws.Context, i *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+				if err := f.check(*i.Key, opts); err != nil {
+					return nil, err
+				}
+				body, _ := ioutil.ReadAll(i.Body)
+				f.writes++
+				f.objects[*i.Key] = body
+				f.etags[*i.Key] = fmt.Sprintf("\"%d\"", f.writes)
+				return &s3.PutObjectOutput{ETag: aws.String(f.etags[*i.Key])}, nil
+			},
+			GetObjectFunc: func(i *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+				body, exists := f.objects[*i.Key]
+				if !exists {
+					return nil, awserr.New(s3.ErrCodeNoSuchKey, "missing", nil)
+				}
+				return &s3.GetObjectOutput{
+					Body: ioutil.NopCloser(bytes.NewReader(body)),
+					ETag: aws.String(f.etags[*i.Key]),
+				}, nil
+			},
+			DeleteObjectWithContextFunc: func(_ aws.Context, i *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+				if err := f.check(*i.Key, opts); err != nil {
+					return nil, err
+				}
+				delete(f.objects, *i.Key)
+				delete(f.etags, *i.Key)
+				return &s3.DeleteObjectOutput{}, nil
+			},
+		},
+	}
+}
+
+func atTime(t time.Time) func() {
+	previous := now
+	now = func() time.Time { return t }
+	return func() { now = previous }
+}
+
+func TestClaimOnlyGrantsOneWorkerTheObject(t *testing.T) {
+	store := newFakeLeaseStore()
+	b := store.bucket()
+
+	lease, err := b.Claim("mail/1", "worker-a", time.Minute)
+	ok(t, err)
+	equals(t, "worker-a", lease.Owner)
+
+	_, err = b.Claim("mail/1", "worker-b", time.Minute)
+	equals(t, ErrLeaseHeld, err)
+
+	if _, exists := store.objects["leases/mail/1"]; !exists {
+		t.Error("Expected lease object to be written under the lease prefix")
+	}
+}
+
+func TestClaimReturnsLeaseOwnerAlreadyHolds(t *testing.T) {
+	store := newFakeLeaseStore()
+	b := store.bucket()
+
+	// A retry whose first write landed but lost its response finds its own lease.
+	first, err := b.Claim("mail/1", "worker-a", time.Minute)
+	ok(t, err)
+	retried, err := b.Claim("mail/1", "worker-a", time.Minute)
+	ok(t, err)
+
+	equals(t, true, first.Expires.Equal(retried.Expires))
+	ok(t, b.ReleaseLease(retried))
+}
+
+func TestClaimReturnsNoLeaseOnError(t *testing.T) {
+	b := Bucket{
+		Name: "TestBucket",
+		Client: mockedBucketAPI{
+			PutObjectWithContextFunc: func(aws.Context, *s3.PutObjectInput, ...request.Option) (*s3.PutObjectOutput, error) {
+				return nil, awserr.New("AccessDenied", "Access Denied", nil)
+			},
+		},
+	}
+
+	lease, err := b.Claim("mail/1", "worker-a", time.Minute)
+
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	equals(t, (*Lease)(nil), lease)
+}
+
+func TestClaimTakesOverExpiredLease(t *testing.T) {
+	store := newFakeLeaseStore()
+	b := store.bucket()
+	start := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	defer atTime(start)()
+	stale, err := b.Claim("mail/1", "worker-a", time.Minute)
+	ok(t, err)
+
+	defer atTime(start.Add(2 * time.Minute))()
+	lease, err := b.Claim("mail/1", "worker-b", time.Minute)
+	ok(t, err)
+	equals(t, "worker-b", lease.Owner)
+
+	equals(t, ErrLeaseHeld, b.RenewLease(stale, time.Minute))
+	equals(t, ErrLeaseHeld, b.ReleaseLease(stale))
+}
+
+func TestRenewAndReleaseLease(t *testing.T) {
+	store := newFakeLeaseStore()
+	b := store.bucket()
+	start := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	defer atTime(start)()
+	lease, err := b.Claim("mail/1", "worker-a", time.Minute)
+	ok(t, err)
+
+	defer atTime(start.Add(50 * time.Second))()
+	ok(t, b.RenewLease(lease, time.Minute))
+	equals(t, start.Add(110*time.Second), lease.Expires)
+
+	defer atTime(start.Add(90 * time.Second))()
+	_, err = b.Claim("mail/1", "worker-b", time.Minute)
+	equals(t, ErrLeaseHeld, err)
+
+	ok(t, b.ReleaseLease(lease))
+	_, err = b.Claim("mail/1", "worker-b", time.Minute)
+	ok(t, err)
+}
+
+func TestClaimNextSkipsHeldObjectsAndLeases(t *testing.T) {
+	store := newFakeLeaseStore()
+	b := store.bucket("leases/mail/1", "rejected/mail/0", "mail/1", "mail/2")
+
+	first, err := b.ClaimNext("worker-a", time.Minute, "rejected/")
+	ok(t, err)
+	second, err := b.ClaimNext("worker-b", time.Minute, "rejected/")
+	ok(t, err)
+
+	equals(t, "mail/1", first.Key)
+	equals(t, "mail/2", second.Key)
+
+	_, err = b.ClaimNext("worker-c", time.Minute, "rejected/")
+	if err == nil {
+		t.Error("Expected error when every object is claimed")
+	}
+}
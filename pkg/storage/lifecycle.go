@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cstdev/lambdahelpers/pkg/awserror"
+)
+
+// minInfrequentAccessDays is the earliest S3 will move objects to an infrequent access class.
+const minInfrequentAccessDays = 30
+
+// LifecycleRule expires or transitions objects matching a prefix and tags.
+// Days of zero leave that action out of the rule.
+type LifecycleRule struct {
+	ID       string
+	Prefix   string
+	Tags     map[string]string
+	Disabled bool
+
+	ExpireAfterDays     int
+	TransitionAfterDays int
+	// StorageClass is where objects move after TransitionAfterDays,
+	// for example s3.TransitionStorageClassGlacier.
+	StorageClass string
+}
+
+// LifecycleRules returns the lifecycle rules configured on the bucket.
+// Rules using features not covered by LifecycleRule, such as dates or
+// noncurrent versions, are returned with just the fields it understands.
+func (b *Bucket) LifecycleRules() ([]LifecycleRule, error) {
+	raw, err := b.lifecycleConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []LifecycleRule
+	for _, r := range raw {
+		rules = append(rules, fromLifecycleRule(r))
+	}
+	return rules, nil
+}
+
+// lifecycleConfiguration returns the rules on the bucket as S3 has them.
+func (b *Bucket) lifecycleConfiguration() ([]*s3.LifecycleRule, error) {
+	var resp *s3.GetBucketLifecycleConfigurationOutput
+	err := b.call("s3:GetBucketLifecycleConfiguration", func(ctx context.Context) (err error) {
+		resp, err = b.Client.GetBucketLifecycleConfigurationWithContext(ctx, &s3.GetBucketLifecycleConfigurationInput{
+			Bucket: aws.String(b.Name),
+		})
+		return err
+	})
+	if err != nil {
+		if awserror.Code(err) == "NoSuchLifecycleConfiguration" {
+			return nil, nil
+		}
+		b.log().Error("Failed to get lifecycle configuration")
+		return nil, err
+	}
+	return resp.Rules, nil
+}
+
+func fromLifecycleRule(r *s3.LifecycleRule) LifecycleRule {
+	rule := LifecycleRule{
+		ID:       aws.StringValue(r.ID),
+		Prefix:   aws.StringValue(r.Prefix),
+		Disabled: aws.StringValue(r.Status) == s3.ExpirationStatusDisabled,
+	}
+	if f := r.Filter; f != nil {
+		switch {
+		case f.And != nil:
+			rule.Prefix = aws.StringValue(f.And.Prefix)
+			rule.Tags = fromTagSet(f.And.Tags)
+		case f.Tag != nil:
+			rule.Tags = fromTagSet([]*s3.Tag{f.Tag})
+		default:
+			rule.Prefix = aws.StringValue(f.Prefix)
+		}
+	}
+	if r.Expiration != nil {
+		rule.ExpireAfterDays = int(aws.Int64Value(r.Expiration.Days))
+	}
+	if len(r.Transitions) > 0 {
+		rule.TransitionAfterDays = int(aws.Int64Value(r.Transitions[0].Days))
+		rule.StorageClass = aws.StringValue(r.Transitions[0].StorageClass)
+	}
+	return rule
+}
+
+// PutLifecycleRules validates and replaces all lifecycle rules on the bucket.
+func (b *Bucket) PutLifecycleRules(rules []LifecycleRule) error {
+	if err := ValidateLifecycleRules(rules); err != nil {
+		return err
+	}
+
+	var raw []*s3.LifecycleRule
+	for _, rule := range rules {
+		raw = append(raw, toLifecycleRule(rule))
+	}
+	return b.putLifecycleConfiguration(raw)
+}
+
+func (b *Bucket) putLifecycleConfiguration(rules []*s3.LifecycleRule) error {
+	config := &s3.BucketLifecycleConfiguration{Rules: rules}
+	err := b.call("s3:PutBucketLifecycleConfiguration", func(ctx context.Context) (err error) {
+		_, err = b.Client.PutBucketLifecycleConfigurationWithContext(ctx, &s3.PutBucketLifecycleConfigurationInput{
+			Bucket:                 aws.String(b.Name),
+			LifecycleConfiguration: config,
+		})
+		return err
+	})
+	if err != nil {
+		b.log().Error("Failed to put lifecycle configuration")
+		return err
+	}
+
+	return nil
+}
+
+// SetLifecycleRule adds a rule to the bucket, replacing any existing rule with
+// the same ID. Other rules are written back exactly as S3 returned them, so
+// features LifecycleRule doesn't cover are kept. Only the new rule is
+// validated, along with whether it acts on the same objects as another.
+func (b *Bucket) SetLifecycleRule(rule LifecycleRule) error {
+	if rule.ID == "" {
+		return fmt.Errorf("Lifecycle rule has no ID")
+	}
+	if err := validateLifecycleRule(rule); err != nil {
+		return fmt.Errorf("Lifecycle rule %q: %v", rule.ID, err)
+	}
+
+	raw, err := b.lifecycleConfiguration()
+	if err != nil {
+		return err
+	}
+
+	rules := make([]*s3.LifecycleRule, 0, len(raw)+1)
+	replaced := false
+	for _, existing := range raw {
+		if aws.StringValue(existing.ID) == rule.ID {
+			if !replaced {
+				rules = append(rules, toLifecycleRule(rule))
+				replaced = true
+			}
+			continue
+		}
+		if other := fromLifecycleRule(existing); overlaps(rule, other) {
+			return fmt.Errorf("Lifecycle rules %q and %q apply to the same objects", rule.ID, other.ID)
+		}
+		rules = append(rules, existing)
+	}
+	if !replaced {
+		rules = append(rules, toLifecycleRule(rule))
+	}
+
+	return b.putLifecycleConfiguration(rules)
+}
+
+// ValidateLifecycleRules checks each rule makes sense on its own and
+// that no two enabled rules act differently on the same objects.
+func ValidateLifecycleRules(rules []LifecycleRule) error {
+	ids := make(map[string]bool)
+	for n, rule := range rules {
+		if rule.ID == "" {
+			return fmt.Errorf("Lifecycle rule %d has no ID", n)
+		}
+		if ids[rule.ID] {
+			return fmt.Errorf("Lifecycle rule ID %q is used more than once", rule.ID)
+		}
+		ids[rule.ID] = true
+
+		if err := validateLifecycleRule(rule); err != nil {
+			return fmt.Errorf("Lifecycle rule %q: %v", rule.ID, err)
+		}
+	}
+
+	for i, a := range rules {
+		for _, b := range rules[i+1:] {
+			if overlaps(a, b) {
+				return fmt.Errorf("Lifecycle rules %q and %q apply to the same objects", a.ID, b.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// overlaps reports whether two enabled rules filter on the same prefix and tags.
+func overlaps(a, b LifecycleRule) bool {
+	if a.Disabled || b.Disabled {
+		return false
+	}
+	return a.Prefix == b.Prefix && reflect.DeepEqual(normaliseTags(a.Tags), normaliseTags(b.Tags))
+}
+
+func validateLifecycleRule(rule LifecycleRule) error {
+	if rule.ExpireAfterDays < 0 || rule.TransitionAfterDays < 0 {
+		return fmt.Errorf("days can't be negative")
+	}
+	if rule.ExpireAfterDays == 0 && rule.TransitionAfterDays == 0 {
+		return fmt.Errorf("needs an expiration or a transition")
+	}
+	if rule.TransitionAfterDays > 0 && rule.StorageClass == "" {
+		return fmt.Errorf("transition needs a storage class")
+	}
+	if rule.TransitionAfterDays == 0 && rule.StorageClass != "" {
+		return fmt.Errorf("storage class %s given without transition days", rule.StorageClass)
+	}
+	if rule.ExpireAfterDays > 0 && rule.TransitionAfterDays >= rule.ExpireAfterDays {
+		return fmt.Errorf("transition after %d days is not before expiry after %d days", rule.TransitionAfterDays, rule.ExpireAfterDays)
+	}
+	switch rule.StorageClass {
+	case s3.TransitionStorageClassStandardIa, s3.TransitionStorageClassOnezoneIa:
+		if rule.TransitionAfterDays < minInfrequentAccessDays {
+			return fmt.Errorf("%s needs at least %d days", rule.StorageClass, minInfrequentAccessDays)
+		}
+	}
+	return ValidateTags(rule.Tags)
+}
+
+func normaliseTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+func toLifecycleRule(rule LifecycleRule) *s3.LifecycleRule {
+	r := &s3.LifecycleRule{
+		ID:     aws.String(rule.ID),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{},
+	}
+	if rule.Disabled {
+		r.Status = aws.String(s3.ExpirationStatusDisabled)
+	}
+
+	switch {
+	case len(rule.Tags) > 1 || (len(rule.Tags) == 1 && rule.Prefix != ""):
+		r.Filter.And = &s3.LifecycleRuleAndOperator{
+			Prefix: optionalString(rule.Prefix),
+			Tags:   toTagSet(rule.Tags),
+		}
+	case len(rule.Tags) == 1:
+		r.Filter.Tag = toTagSet(rule.Tags)[0]
+	default:
+		r.Filter.Prefix = aws.String(rule.Prefix)
+	}
+
+	if rule.ExpireAfterDays > 0 {
+		r.Expiration = &s3.LifecycleExpiration{
+			Days: aws.Int64(int64(rule.ExpireAfterDays)),
+		}
+	}
+	if rule.TransitionAfterDays > 0 {
+		r.Transitions = []*s3.Transition{
+			{
+				Days:         aws.Int64(int64(rule.TransitionAfterDays)),
+				StorageClass: aws.String(rule.StorageClass),
+			},
+		}
+	}
+	return r
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestValidateLifecycleRulesCatchesConflicts(t *testing.T) {
+	tests := map[string][]LifecycleRule{
+		"missing id":         {{ExpireAfterDays: 1}},
+		"duplicate id":       {{ID: "a", ExpireAfterDays: 1}, {ID: "a", Prefix: "x/", ExpireAfterDays: 1}},
+		"no action":          {{ID: "a"}},
+		"no storage class":   {{ID: "a", TransitionAfterDays: 5}},
+		"transition late":    {{ID: "a", TransitionAfterDays: 10, StorageClass: s3.TransitionStorageClassGlacier, ExpireAfterDays: 10}},
+		"ia too early":       {{ID: "a", TransitionAfterDays: 5, StorageClass: s3.TransitionStorageClassStandardIa}},
+		"same objects twice": {{ID: "a", Prefix: "mail/", ExpireAfterDays: 1}, {ID: "b", Prefix: "mail/", ExpireAfterDays: 7}},
+	}
+
+	for name, rules := range tests {
+		if err := ValidateLifecycleRules(rules); err == nil {
+			t.Errorf("Expected error for %s but didn't receive one", name)
+		}
+	}
+
+	ok(t, ValidateLifecycleRules([]LifecycleRule{
+		{ID: "a", Prefix: "mail/", ExpireAfterDays: 7},
+		{ID: "b", Prefix: "mail/", Tags: map[string]string{"status": "processed"}, ExpireAfterDays: 1},
+		{ID: "c", Prefix: "archive/", TransitionAfterDays: 1, StorageClass: s3.TransitionStorageClassGlacier},
+	}))
+}
+
+func TestSetLifecycleRuleReplacesRuleWithSameID(t *testing.T) {
+	var sent []*s3.LifecycleRule
+	b := Bucket{
+		Client: mockedBucketAPI{
+			GetBucketLifecycleFunc: func(*s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+				return &s3.GetBucketLifecycleConfigurationOutput{
+					Rules: []*s3.LifecycleRule{
+						{
+							ID:         aws.String("processed"),
+							Status:     aws.String(s3.ExpirationStatusEnabled),
+							Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("mail/")},
+							Expiration: &s3.LifecycleExpiration{Days: aws.Int64(30)},
+						},
+					},
+				}, nil
+			},
+			PutBucketLifecycleFunc: func(i *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+				sent = i.LifecycleConfiguration.Rules
+				return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+
+	err := b.SetLifecycleRule(LifecycleRule{
+		ID:              "processed",
+		Prefix:          "mail/",
+		Tags:            map[string]string{"status": "processed"},
+		ExpireAfterDays: 1,
+	})
+	ok(t, err)
+
+	equals(t, 1, len(sent))
+	equals(t, int64(1), *sent[0].Expiration.Days)
+	equals(t, "mail/", *sent[0].Filter.And.Prefix)
+	equals(t, "status", *sent[0].Filter.And.Tags[0].Key)
+}
+
+func TestSetLifecycleRuleKeepsOtherRulesAsTheyAre(t *testing.T) {
+	untouched := &s3.LifecycleRule{
+		ID:     aws.String("versions"),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("")},
+		// Neither is modelled by LifecycleRule, and an expiration without days
+		// wouldn't pass ValidateLifecycleRules.
+		Expiration:                  &s3.LifecycleExpiration{ExpiredObjectDeleteMarker: aws.Bool(true)},
+		NoncurrentVersionExpiration: &s3.NoncurrentVersionExpiration{NoncurrentDays: aws.Int64(7)},
+	}
+	var sent []*s3.LifecycleRule
+	b := Bucket{
+		Client: mockedBucketAPI{
+			GetBucketLifecycleFunc: func(*s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+				return &s3.GetBucketLifecycleConfigurationOutput{Rules: []*s3.LifecycleRule{untouched}}, nil
+			},
+			PutBucketLifecycleFunc: func(i *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+				sent = i.LifecycleConfiguration.Rules
+				return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+
+	ok(t, b.SetLifecycleRule(LifecycleRule{ID: "processed", Prefix: "mail/", ExpireAfterDays: 1}))
+
+	equals(t, 2, len(sent))
+	equals(t, untouched, sent[0])
+	equals(t, "processed", *sent[1].ID)
+}
+
+func TestLifecycleRulesReturnsNoneWhenNotConfigured(t *testing.T) {
+	b := Bucket{
+		Client: mockedBucketAPI{
+			GetBucketLifecycleFunc: func(*s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+				return nil, awserr.New("NoSuchLifecycleConfiguration", "none", nil)
+			},
+		},
+		Name: "TestBucket",
+	}
+
+	rules, err := b.LifecycleRules()
+	ok(t, err)
+	equals(t, 0, len(rules))
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+	"github.com/cstdev/lambdahelpers/pkg/metrics"
+	"github.com/cstdev/lambdahelpers/pkg/tracing"
+)
+
+// ObjectInfo describes an object opened for reading.
+// Size is the number of bytes in the returned body, which is less than the
+// whole object for range reads.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+}
+
+// Open returns a stream of the object's body along with its metadata.
+// The caller must close the body.
+func (b *Bucket) Open(key string) (io.ReadCloser, *ObjectInfo, error) {
+	return b.open(&s3.GetObjectInput{
+		Bucket: aws.String(b.Name),
+		Key:    aws.String(key),
+	})
+}
+
+// OpenRange streams length bytes of the object starting at offset.
+// A length of zero or less reads to the end of the object.
+func (b *Bucket) OpenRange(key string, offset int64, length int64) (io.ReadCloser, *ObjectInfo, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange += fmt.Sprint(offset + length - 1)
+	}
+
+	return b.open(&s3.GetObjectInput{
+		Bucket: aws.String(b.Name),
+		Key:    aws.String(key),
+		Range:  aws.String(byteRange),
+	})
+}
+
+// OpenFirst streams the first object in the bucket, like ReadFile
+// but without reading it into memory.
+func (b *Bucket) OpenFirst() (body io.ReadCloser, info *ObjectInfo, err error) {
+	b, span := b.startSpan("storage.OpenFirst")
+	defer func() { tracing.End(span, err) }()
+
+	var resp *s3.ListObjectsV2Output
+	err = b.call("s3:ListObjectsV2", func(ctx context.Context) (err error) {
+		resp, err = b.Client.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(b.Name),
+			MaxKeys: aws.Int64(1),
+		})
+		return err
+	})
+	if err != nil {
+		b.log().Error("Unable to query bucket")
+		return nil, nil, err
+	}
+
+	if len(resp.Contents) < 1 {
+		return nil, nil, ErrEmptyBucket
+	}
+
+	return b.Open(aws.StringValue(resp.Contents[0].Key))
+}
+
+// Exists reports whether there is an object at key.
+func (b *Bucket) Exists(key string) (bool, error) {
+	err := b.call("s3:HeadObject", func(ctx context.Context) (err error) {
+		_, err = b.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(b.Name),
+			Key:    aws.String(key),
+		})
+		return err
+	})
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		b.log().WithFields(logging.Fields{
+			"key": key,
+		}).Error("Failed to check for object")
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *Bucket) open(input *s3.GetObjectInput) (body io.ReadCloser, info *ObjectInfo, err error) {
+	b, span := b.startSpan("storage.Open", tracing.Key.String(aws.StringValue(input.Key)))
+	defer func() {
+		if info != nil {
+			span.SetAttributes(tracing.Bytes.Int64(info.Size))
+		}
+		tracing.End(span, err)
+	}()
+
+	b.log().WithFields(logging.Fields{
+		"key": aws.StringValue(input.Key),
+	}).Debug("Opening object")
+
+	var result *s3.GetObjectOutput
+	err = b.call("s3:GetObject", func(ctx context.Context) (err error) {
+		result, err = b.Client.GetObjectWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		b.log().WithFields(logging.Fields{
+			"key": aws.StringValue(input.Key),
+		}).Error("Failed to get the file")
+		return nil, nil, err
+	}
+
+	info = &ObjectInfo{
+		Key:          aws.StringValue(input.Key),
+		Size:         aws.Int64Value(result.ContentLength),
+		ETag:         aws.StringValue(result.ETag),
+		ContentType:  aws.StringValue(result.ContentType),
+		LastModified: aws.TimeValue(result.LastModified),
+	}
+
+	counted := &countingBody{ReadCloser: result.Body, bucket: b}
+	if b.MaxReadSize > 0 {
+		if info.Size > b.MaxReadSize {
+			result.Body.Close()
+			return nil, info, ErrObjectTooLarge
+		}
+		return &limitedBody{ReadCloser: counted, remaining: b.MaxReadSize}, info, nil
+	}
+
+	return counted, info, nil
+}
+
+// countingBody records the bytes actually read from an opened object when it's closed.
+type countingBody struct {
+	io.ReadCloser
+	bucket *Bucket
+	n      int64
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingBody) Close() error {
+	c.bucket.processed("Open", metrics.BytesDownloaded, c.n)
+	return c.ReadCloser.Close()
+}
+
+// limitedBody fails the read once more than the allowed bytes have come
+// through, for responses that didn't report their length up front.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrObjectTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n + int(l.remaining), ErrObjectTooLarge
+	}
+	return n, err
+}
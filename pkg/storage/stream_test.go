@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cstdev/lambdahelpers/pkg/metrics"
+)
+
+func streamBucket(body string, contentLength *int64, input **s3.GetObjectInput) Bucket {
+	return Bucket{
+		Client: mockedBucketAPI{
+			ListObjectsFunc: func(*s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+				return &s3.ListObjectsV2Output{
+					Contents: []*s3.Object{{Key: aws.String("Object1")}},
+				}, nil
+			},
+			GetObjectFunc: func(i *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+				if input != nil {
+					*input = i
+				}
+				return &s3.GetObjectOutput{
+					Body:          ioutil.NopCloser(bytes.NewReader([]byte(body))),
+					ContentLength: contentLength,
+					ETag:          aws.String("\"abc\""),
+					ContentType:   aws.String("message/rfc822"),
+					LastModified:  aws.Time(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)),
+				}, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+}
+
+func TestOpenFirstStreamsBodyWithMetadata(t *testing.T) {
+	b := streamBucket("Hello", aws.Int64(5), nil)
+
+	body, info, err := b.OpenFirst()
+	ok(t, err)
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	ok(t, err)
+	equals(t, "Hello", string(data))
+	equals(t, &ObjectInfo{
+		Key:          "Object1",
+		Size:         5,
+		ETag:         "\"abc\"",
+		ContentType:  "message/rfc822",
+		LastModified: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, info)
+}
+
+func TestOpenRangeRequestsByteRange(t *testing.T) {
+	var input *s3.GetObjectInput
+	b := streamBucket("llo", aws.Int64(3), &input)
+
+	_, _, err := b.OpenRange("Object1", 2, 3)
+	ok(t, err)
+	equals(t, "bytes=2-4", *input.Range)
+
+	_, _, err = b.OpenRange("Object1", 2, 0)
+	ok(t, err)
+	equals(t, "bytes=2-", *input.Range)
+}
+
+func TestOpenRejectsObjectsOverMaxReadSize(t *testing.T) {
+	b := streamBucket("Hello", aws.Int64(5), nil)
+	b.MaxReadSize = 4
+
+	_, _, err := b.Open("Object1")
+	equals(t, ErrObjectTooLarge, err)
+}
+
+func TestOpenStopsReadingPastMaxReadSizeWithoutLength(t *testing.T) {
+	b := streamBucket(strings.Repeat("a", 100), nil, nil)
+	b.MaxReadSize = 10
+
+	body, _, err := b.Open("Object1")
+	ok(t, err)
+
+	data, err := ioutil.ReadAll(body)
+	equals(t, ErrObjectTooLarge, err)
+	equals(t, 10, len(data))
+}
+
+func TestOpenRecordsBytesReadOnClose(t *testing.T) {
+	sink := &metrics.Memory{}
+	b := streamBucket("Hello", aws.Int64(5), nil)
+	b.Metrics = sink
+
+	body, _, err := b.Open("Object1")
+	ok(t, err)
+	ioutil.ReadAll(body)
+	ok(t, body.Close())
+
+	equals(t, float64(5), sink.Sum(metrics.BytesDownloaded))
+	equals(t, float64(1), sink.Sum(metrics.ObjectsProcessed))
+	equals(t, 1, sink.Count(metrics.Latency))
+}
+
+func TestExistsReportsMissingObjects(t *testing.T) {
+	b := Bucket{
+		Client: mockedBucketAPI{
+			HeadObjectFunc: func(i *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+				if *i.Key == "missing" {
+					return nil, awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), 404, "req-1")
+				}
+				return &s3.HeadObjectOutput{}, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+
+	exists, err := b.Exists("present")
+	ok(t, err)
+	equals(t, true, exists)
+
+	exists, err = b.Exists("missing")
+	ok(t, err)
+	equals(t, false, exists)
+}
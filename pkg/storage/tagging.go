@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+)
+
+// Limits S3 puts on object tags.
+const (
+	maxObjectTags  = 10
+	maxTagKeyLen   = 128
+	maxTagValueLen = 256
+)
+
+// ObjectTags returns the tags set on an object.
+func (b *Bucket) ObjectTags(key string) (map[string]string, error) {
+	var resp *s3.GetObjectTaggingOutput
+	err := b.call("s3:GetObjectTagging", func(ctx context.Context) (err error) {
+		resp, err = b.Client.GetObjectTaggingWithContext(ctx, &s3.GetObjectTaggingInput{
+			Bucket: aws.String(b.Name),
+			Key:    aws.String(key),
+		})
+		return err
+	})
+	if err != nil {
+		b.log().WithFields(logging.Fields{
+			"key": key,
+		}).Error("Failed to get object tags")
+		return nil, err
+	}
+
+	return fromTagSet(resp.TagSet), nil
+}
+
+// PutObjectTags replaces the tags on an object, for example
+// status=processed once an email has been handled.
+func (b *Bucket) PutObjectTags(key string, tags map[string]string) error {
+	if err := ValidateTags(tags); err != nil {
+		return err
+	}
+
+	err := b.call("s3:PutObjectTagging", func(ctx context.Context) (err error) {
+		_, err = b.Client.PutObjectTaggingWithContext(ctx, &s3.PutObjectTaggingInput{
+			Bucket: aws.String(b.Name),
+			Key:    aws.String(key),
+			Tagging: &s3.Tagging{
+				TagSet: toTagSet(tags),
+			},
+		})
+		return err
+	})
+	if err != nil {
+		b.log().WithFields(logging.Fields{
+			"key": key,
+		}).Error("Failed to put object tags")
+		return err
+	}
+
+	return nil
+}
+
+// DeleteObjectTags removes all tags from an object.
+func (b *Bucket) DeleteObjectTags(key string) error {
+	err := b.call("s3:DeleteObjectTagging", func(ctx context.Context) (err error) {
+		_, err = b.Client.DeleteObjectTaggingWithContext(ctx, &s3.DeleteObjectTaggingInput{
+			Bucket: aws.String(b.Name),
+			Key:    aws.String(key),
+		})
+		return err
+	})
+	if err != nil {
+		b.log().WithFields(logging.Fields{
+			"key": key,
+		}).Error("Failed to delete object tags")
+		return err
+	}
+
+	return nil
+}
+
+// ValidateTags checks tags against the limits S3 puts on them.
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > maxObjectTags {
+		return fmt.Errorf("%d tags given, S3 allows %d per object", len(tags), maxObjectTags)
+	}
+	for k, v := range tags {
+		if k == "" || len(k) > maxTagKeyLen {
+			return fmt.Errorf("Tag key %q must be between 1 and %d characters", k, maxTagKeyLen)
+		}
+		if len(v) > maxTagValueLen {
+			return fmt.Errorf("Tag value for %q is longer than %d characters", k, maxTagValueLen)
+		}
+	}
+	return nil
+}
+
+// uploadTagging encodes tags for the Tagging field of an upload,
+// returning nil when there are none.
+func uploadTagging(tags map[string]string) *string {
+	if len(tags) == 0 {
+		return nil
+	}
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return aws.String(values.Encode())
+}
+
+func toTagSet(tags map[string]string) []*s3.Tag {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tagSet := make([]*s3.Tag, 0, len(tags))
+	for _, k := range keys {
+		tagSet = append(tagSet, &s3.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(tags[k]),
+		})
+	}
+	return tagSet
+}
+
+func fromTagSet(tagSet []*s3.Tag) map[string]string {
+	tags := make(map[string]string, len(tagSet))
+	for _, tag := range tagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return tags
+}
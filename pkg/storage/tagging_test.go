@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+func TestPutObjectTagsSendsSortedTagSet(t *testing.T) {
+	var tagSet []*s3.Tag
+	b := Bucket{
+		Client: mockedBucketAPI{
+			PutObjectTaggingFunc: func(i *s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error) {
+				tagSet = i.Tagging.TagSet
+				return &s3.PutObjectTaggingOutput{}, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+
+	err := b.PutObjectTags("Object1", map[string]string{"status": "processed", "sender": "a@b.com"})
+	ok(t, err)
+
+	equals(t, []*s3.Tag{
+		{Key: aws.String("sender"), Value: aws.String("a@b.com")},
+		{Key: aws.String("status"), Value: aws.String("processed")},
+	}, tagSet)
+}
+
+func TestPutObjectTagsRejectsTooManyTags(t *testing.T) {
+	tags := make(map[string]string)
+	for n := 0; n <= maxObjectTags; n++ {
+		tags[fmt.Sprintf("tag%d", n)] = "value"
+	}
+	b := Bucket{Name: "TestBucket"}
+
+	if err := b.PutObjectTags("Object1", tags); err == nil {
+		t.Error("Expected error to be returned but didn't receive one")
+	}
+}
+
+func TestObjectTagsReturnsMap(t *testing.T) {
+	b := Bucket{
+		Client: mockedBucketAPI{
+			GetObjectTaggingFunc: func(*s3.GetObjectTaggingInput) (*s3.GetObjectTaggingOutput, error) {
+				return &s3.GetObjectTaggingOutput{
+					TagSet: []*s3.Tag{{Key: aws.String("status"), Value: aws.String("processed")}},
+				}, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+
+	tags, err := b.ObjectTags("Object1")
+	ok(t, err)
+	equals(t, map[string]string{"status": "processed"}, tags)
+}
+
+func TestUploadFileSetsUploadTags(t *testing.T) {
+	var tagging string
+	b := Bucket{
+		Manager: mockedBucketAPI{
+			UploadFunc: func(i *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				tagging = aws.StringValue(i.Tagging)
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+		Name:       "TestBucket",
+		UploadTags: map[string]string{"status": "new", "sender": "a b"},
+	}
+
+	err := b.UploadFile("TestFile", "Some content")
+	ok(t, err)
+	equals(t, "sender=a+b&status=new", tagging)
+}
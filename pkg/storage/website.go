@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/cstdev/lambdahelpers/pkg/awserror"
+	"github.com/cstdev/lambdahelpers/pkg/logging"
+)
+
+// defaultIndexDocument is used for redirect sources that name a folder.
+const defaultIndexDocument = "index.html"
+
+// maxRoutingRules is the most routing rules S3 accepts in a website configuration.
+const maxRoutingRules = 50
+
+// Website is the static website configuration of a bucket.
+type Website struct {
+	IndexDocument string
+	ErrorDocument string
+	RoutingRules  []RoutingRule
+}
+
+// RoutingRule redirects requests matching a key prefix and/or an error code.
+// Empty fields are left out of the rule sent to S3.
+type RoutingRule struct {
+	KeyPrefixEquals             string
+	HTTPErrorCodeReturnedEquals string
+
+	HostName             string
+	Protocol             string
+	ReplaceKeyPrefixWith string
+	ReplaceKeyWith       string
+	HTTPRedirectCode     string
+}
+
+// Redirect is a single line of a redirects file.
+type Redirect struct {
+	From string
+	To   string
+	Code int
+}
+
+// Website reads the static website configuration of the bucket.
+// A bucket without one returns an empty configuration.
+func (b *Bucket) Website() (*Website, error) {
+	var resp *s3.GetBucketWebsiteOutput
+	err := b.call("s3:GetBucketWebsite", func(ctx context.Context) (err error) {
+		resp, err = b.Client.GetBucketWebsiteWithContext(ctx, &s3.GetBucketWebsiteInput{
+			Bucket: aws.String(b.Name),
+		})
+		return err
+	})
+	if err != nil {
+		if awserror.Code(err) == "NoSuchWebsiteConfiguration" {
+			return &Website{}, nil
+		}
+		b.log().Error("Failed to get website configuration")
+		return nil, err
+	}
+
+	website := &Website{}
+	if resp.IndexDocument != nil {
+		website.IndexDocument = aws.StringValue(resp.IndexDocument.Suffix)
+	}
+	if resp.ErrorDocument != nil {
+		website.ErrorDocument = aws.StringValue(resp.ErrorDocument.Key)
+	}
+	for _, rule := range resp.RoutingRules {
+		r := RoutingRule{}
+		if rule.Condition != nil {
+			r.KeyPrefixEquals = aws.StringValue(rule.Condition.KeyPrefixEquals)
+			r.HTTPErrorCodeReturnedEquals = aws.StringValue(rule.Condition.HttpErrorCodeReturnedEquals)
+		}
+		if rule.Redirect != nil {
+			r.HostName = aws.StringValue(rule.Redirect.HostName)
+			r.Protocol = aws.StringValue(rule.Redirect.Protocol)
+			r.ReplaceKeyPrefixWith = aws.StringValue(rule.Redirect.ReplaceKeyPrefixWith)
+			r.ReplaceKeyWith = aws.StringValue(rule.Redirect.ReplaceKeyWith)
+			r.HTTPRedirectCode = aws.StringValue(rule.Redirect.HttpRedirectCode)
+		}
+		website.RoutingRules = append(website.RoutingRules, r)
+	}
+
+	return website, nil
+}
+
+// PutWebsite replaces the static website configuration of the bucket.
+func (b *Bucket) PutWebsite(website Website) error {
+	if website.IndexDocument == "" {
+		return errors.New("Website configuration needs an index document")
+	}
+	if len(website.RoutingRules) > maxRoutingRules {
+		return fmt.Errorf("Website configuration has %d routing rules, S3 allows %d", len(website.RoutingRules), maxRoutingRules)
+	}
+
+	config := &s3.WebsiteConfiguration{
+		IndexDocument: &s3.IndexDocument{
+			Suffix: aws.String(website.IndexDocument),
+		},
+	}
+	if website.ErrorDocument != "" {
+		config.ErrorDocument = &s3.ErrorDocument{
+			Key: aws.String(website.ErrorDocument),
+		}
+	}
+	for _, rule := range website.RoutingRules {
+		r := &s3.RoutingRule{
+			Redirect: &s3.Redirect{
+				HostName:             optionalString(rule.HostName),
+				Protocol:             optionalString(rule.Protocol),
+				ReplaceKeyPrefixWith: optionalString(rule.ReplaceKeyPrefixWith),
+				ReplaceKeyWith:       optionalString(rule.ReplaceKeyWith),
+				HttpRedirectCode:     optionalString(rule.HTTPRedirectCode),
+			},
+		}
+		if rule.KeyPrefixEquals != "" || rule.HTTPErrorCodeReturnedEquals != "" {
+			r.Condition = &s3.Condition{
+				KeyPrefixEquals:             optionalString(rule.KeyPrefixEquals),
+				HttpErrorCodeReturnedEquals: optionalString(rule.HTTPErrorCodeReturnedEquals),
+			}
+		}
+		config.RoutingRules = append(config.RoutingRules, r)
+	}
+
+	err := b.call("s3:PutBucketWebsite", func(ctx context.Context) (err error) {
+		_, err = b.Client.PutBucketWebsiteWithContext(ctx, &s3.PutBucketWebsiteInput{
+			Bucket:               aws.String(b.Name),
+			WebsiteConfiguration: config,
+		})
+		return err
+	})
+	if err != nil {
+		b.log().Error("Failed to put website configuration")
+		return err
+	}
+
+	return nil
+}
+
+// PutRedirect writes an empty object at key that the S3 website endpoint
+// answers with a 301 to location.
+func (b *Bucket) PutRedirect(key string, location string) error {
+	err := b.call("s3manager:Upload", func(ctx context.Context) (err error) {
+		_, err = b.Manager.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:                  aws.String(b.Name),
+			Key:                     aws.String(key),
+			Body:                    strings.NewReader(""),
+			ContentType:             aws.String("text/html"),
+			WebsiteRedirectLocation: aws.String(location),
+		})
+		return err
+	})
+	if err != nil {
+		b.log().WithFields(logging.Fields{
+			"key":      key,
+			"location": location,
+		}).Error("Failed to upload redirect")
+		return err
+	}
+
+	return nil
+}
+
+// ParseRedirects reads a redirects file made up of lines in the form
+// "/old /new 301". The status code is optional and defaults to 301.
+// Blank lines and lines starting with # are ignored.
+func ParseRedirects(r io.Reader) ([]Redirect, error) {
+	var redirects []Redirect
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("Redirects line %d: expected \"from to [code]\", got %q", line, text)
+		}
+
+		redirect := Redirect{From: fields[0], To: fields[1], Code: 301}
+		if len(fields) == 3 {
+			code, err := strconv.Atoi(fields[2])
+			if err != nil || code < 300 || code > 399 {
+				return nil, fmt.Errorf("Redirects line %d: invalid redirect code %q", line, fields[2])
+			}
+			redirect.Code = code
+		}
+		if !strings.HasPrefix(redirect.From, "/") {
+			return nil, fmt.Errorf("Redirects line %d: source %q must start with /", line, redirect.From)
+		}
+		redirects = append(redirects, redirect)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return redirects, nil
+}
+
+// RedirectKey returns the object key the website endpoint serves for a redirect source.
+// Sources ending in / map to the index document inside that folder.
+func RedirectKey(from string, indexDocument string) string {
+	key := strings.TrimPrefix(from, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		key += indexDocument
+	}
+	return key
+}
+
+// RoutingRules turns redirects of folders into website routing rules, with
+// keyPrefix put in front of the keys they match and rewrite to so they line up
+// with the keys Upload writes. A rule matches every key under its folder, so
+// sources must end in /; redirect single objects with PutRedirect instead.
+// Folder targets replace the matched folder and keep the rest of the key,
+// other targets replace the whole key. Targets that are full URLs keep their
+// host and protocol.
+func RoutingRules(redirects []Redirect, keyPrefix string) ([]RoutingRule, error) {
+	var rules []RoutingRule
+	for _, redirect := range redirects {
+		if !strings.HasSuffix(redirect.From, "/") {
+			return nil, fmt.Errorf("Routing rules only redirect folders, %q must end in /", redirect.From)
+		}
+		rule := RoutingRule{
+			KeyPrefixEquals:  keyPrefix + strings.TrimPrefix(redirect.From, "/"),
+			HTTPRedirectCode: strconv.Itoa(redirect.Code),
+		}
+
+		target, err := url.Parse(redirect.To)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid redirect target %q: %v", redirect.To, err)
+		}
+		key := keyPrefix + strings.TrimPrefix(target.RequestURI(), "/")
+		if target.Host != "" {
+			rule.HostName = target.Host
+			rule.Protocol = target.Scheme
+			key = strings.TrimPrefix(target.RequestURI(), "/")
+		}
+		if strings.HasSuffix(target.Path, "/") && target.RawQuery == "" {
+			rule.ReplaceKeyPrefixWith = key
+		} else {
+			rule.ReplaceKeyWith = key
+		}
+
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// keyPrefix returns what Upload puts in front of the path of a file relative
+// to root: a / unless root ends in a separator.
+func keyPrefix(root string) string {
+	if strings.HasSuffix(filepath.ToSlash(root), "/") {
+		return ""
+	}
+	return "/"
+}
+
+// applyRedirects writes redirects for the site uploaded from root. Redirects
+// of single objects are uploaded as redirect objects. Redirects of folders are
+// merged into the bucket's routing rules when b.RedirectsAsRoutingRules is set,
+// otherwise they are uploaded as a redirect object for the folder's index document.
+// It returns the keys of any objects written.
+func (b *Bucket) applyRedirects(redirects []Redirect, root string) ([]string, error) {
+	prefix := keyPrefix(root)
+
+	var folders []Redirect
+	var keys []string
+	for _, redirect := range redirects {
+		if b.RedirectsAsRoutingRules && strings.HasSuffix(redirect.From, "/") {
+			folders = append(folders, redirect)
+			continue
+		}
+		if redirect.Code != 301 {
+			return keys, fmt.Errorf("Redirect from %s uses %d, redirect objects only support 301", redirect.From, redirect.Code)
+		}
+		key := prefix + RedirectKey(redirect.From, defaultIndexDocument)
+		if err := b.PutRedirect(key, redirect.To); err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	if len(folders) == 0 {
+		return keys, nil
+	}
+
+	rules, err := RoutingRules(folders, prefix)
+	if err != nil {
+		return keys, err
+	}
+	website, err := b.Website()
+	if err != nil {
+		return keys, err
+	}
+	if website.IndexDocument == "" {
+		website.IndexDocument = defaultIndexDocument
+	}
+	website.RoutingRules = mergeRoutingRules(website.RoutingRules, rules)
+	return keys, b.PutWebsite(*website)
+}
+
+// mergeRoutingRules replaces existing rules for the same key prefix and adds
+// the rest. S3 applies the first rule that matches, so a new rule goes in front
+// of any rule for a shorter prefix of it.
+func mergeRoutingRules(existing []RoutingRule, rules []RoutingRule) []RoutingRule {
+	for _, rule := range rules {
+		at := len(existing)
+		for i, e := range existing {
+			if e.HTTPErrorCodeReturnedEquals != "" {
+				continue
+			}
+			if e.KeyPrefixEquals == rule.KeyPrefixEquals {
+				existing[i] = rule
+				at = -1
+				break
+			}
+			if at == len(existing) && strings.HasPrefix(rule.KeyPrefixEquals, e.KeyPrefixEquals) {
+				at = i
+			}
+		}
+		if at < 0 {
+			continue
+		}
+		existing = append(existing[:at], append([]RoutingRule{rule}, existing[at:]...)...)
+	}
+	return existing
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
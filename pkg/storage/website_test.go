@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+func TestParseRedirectsReadsEachLine(t *testing.T) {
+	file := `# moved posts
+/old /new 301
+
+/blog/ https://example.com/posts/ 302
+/about /about-us
+`
+	redirects, err := ParseRedirects(strings.NewReader(file))
+	ok(t, err)
+
+	equals(t, []Redirect{
+		{From: "/old", To: "/new", Code: 301},
+		{From: "/blog/", To: "https://example.com/posts/", Code: 302},
+		{From: "/about", To: "/about-us", Code: 301},
+	}, redirects)
+}
+
+func TestParseRedirectsRejectsInvalidLines(t *testing.T) {
+	for _, line := range []string{"/old", "/old /new 200", "/old /new abc", "old /new", "/a /b 301 extra"} {
+		_, err := ParseRedirects(strings.NewReader(line))
+		if err == nil {
+			t.Errorf("Expected error for line %q but didn't receive one", line)
+		}
+	}
+}
+
+func TestRoutingRulesKeepHostOfAbsoluteTargets(t *testing.T) {
+	rules, err := RoutingRules([]Redirect{
+		{From: "/old/", To: "/new/", Code: 301},
+		{From: "/blog/", To: "https://example.com/posts/", Code: 302},
+		{From: "/drafts/", To: "/", Code: 302},
+		{From: "/archive/", To: "/posts/index.html", Code: 301},
+	}, "/")
+	ok(t, err)
+
+	equals(t, []RoutingRule{
+		{KeyPrefixEquals: "/old/", ReplaceKeyPrefixWith: "/new/", HTTPRedirectCode: "301"},
+		{KeyPrefixEquals: "/blog/", HostName: "example.com", Protocol: "https", ReplaceKeyPrefixWith: "posts/", HTTPRedirectCode: "302"},
+		{KeyPrefixEquals: "/drafts/", ReplaceKeyPrefixWith: "/", HTTPRedirectCode: "302"},
+		{KeyPrefixEquals: "/archive/", ReplaceKeyWith: "/posts/index.html", HTTPRedirectCode: "301"},
+	}, rules)
+}
+
+func TestRoutingRulesRejectRedirectsOfSingleObjects(t *testing.T) {
+	_, err := RoutingRules([]Redirect{{From: "/old", To: "/new", Code: 301}}, "")
+	if err == nil {
+		t.Error("Expected error for a source that isn't a folder but didn't receive one")
+	}
+}
+
+func TestMergeRoutingRulesPutsLongerPrefixesFirst(t *testing.T) {
+	existing := []RoutingRule{
+		{HTTPErrorCodeReturnedEquals: "404", ReplaceKeyWith: "404.html"},
+		{KeyPrefixEquals: "docs/", ReplaceKeyPrefixWith: "manual/"},
+	}
+
+	rules := mergeRoutingRules(existing, []RoutingRule{
+		{KeyPrefixEquals: "docs/old/", ReplaceKeyPrefixWith: "archive/"},
+		{KeyPrefixEquals: "docs/", ReplaceKeyPrefixWith: "guide/"},
+		{KeyPrefixEquals: "blog/", ReplaceKeyPrefixWith: "posts/"},
+	})
+
+	equals(t, []RoutingRule{
+		{HTTPErrorCodeReturnedEquals: "404", ReplaceKeyWith: "404.html"},
+		{KeyPrefixEquals: "docs/old/", ReplaceKeyPrefixWith: "archive/"},
+		{KeyPrefixEquals: "docs/", ReplaceKeyPrefixWith: "guide/"},
+		{KeyPrefixEquals: "blog/", ReplaceKeyPrefixWith: "posts/"},
+	}, rules)
+}
+
+func TestWebsiteReturnsEmptyConfigurationWhenNoneSet(t *testing.T) {
+	b := Bucket{
+		Client: mockedBucketAPI{
+			GetBucketWebsiteFunc: func(*s3.GetBucketWebsiteInput) (*s3.GetBucketWebsiteOutput, error) {
+				return nil, awserr.New("NoSuchWebsiteConfiguration", "none", nil)
+			},
+		},
+		Name: "TestBucket",
+	}
+
+	website, err := b.Website()
+	ok(t, err)
+	equals(t, &Website{}, website)
+}
+
+func TestPutWebsiteSendsDocumentsAndRules(t *testing.T) {
+	var config *s3.WebsiteConfiguration
+	b := Bucket{
+		Client: mockedBucketAPI{
+			PutBucketWebsiteFunc: func(i *s3.PutBucketWebsiteInput) (*s3.PutBucketWebsiteOutput, error) {
+				config = i.WebsiteConfiguration
+				return &s3.PutBucketWebsiteOutput{}, nil
+			},
+		},
+		Name: "TestBucket",
+	}
+
+	err := b.PutWebsite(Website{
+		IndexDocument: "index.html",
+		ErrorDocument: "404.html",
+		RoutingRules: []RoutingRule{
+			{KeyPrefixEquals: "old", ReplaceKeyWith: "new", HTTPRedirectCode: "301"},
+		},
+	})
+	ok(t, err)
+
+	equals(t, "index.html", *config.IndexDocument.Suffix)
+	equals(t, "404.html", *config.ErrorDocument.Key)
+	equals(t, 1, len(config.RoutingRules))
+	equals(t, "old", *config.RoutingRules[0].Condition.KeyPrefixEquals)
+	equals(t, "new", *config.RoutingRules[0].Redirect.ReplaceKeyWith)
+	if config.RoutingRules[0].Redirect.HostName != nil {
+		t.Error("Expected empty host name to be left out of the rule")
+	}
+}
+
+func redirectsTestDir(t *testing.T, redirects string) string {
+	dir, err := ioutil.TempDir("", "redirectsUpload")
+	ok(t, err)
+	ok(t, ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("home"), 0666))
+	ok(t, ioutil.WriteFile(filepath.Join(dir, "_redirects"), []byte(redirects), 0666))
+	return dir
+}
+
+func TestUploadWritesRedirectObjectsFromRedirectsFile(t *testing.T) {
+	dir := redirectsTestDir(t, "/old /new\n/folder/ /elsewhere/\n")
+	defer os.RemoveAll(dir)
+
+	locations := make(map[string]string)
+	b := Bucket{
+		Name: "TestBucket",
+		Manager: mockedBucketAPI{
+			UploadFunc: func(i *s3manager.UploadInput, up ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				locations[*i.Key] = aws.StringValue(i.WebsiteRedirectLocation)
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+		RedirectsFile: "_redirects",
+	}
+
+	_, err := b.Upload(dir)
+	ok(t, err)
+
+	equals(t, map[string]string{
+		"/index.html":        "",
+		"/old":               "/new",
+		"/folder/index.html": "/elsewhere/",
+	}, locations)
+}
+
+func TestUploadMergesRedirectsIntoRoutingRules(t *testing.T) {
+	dir := redirectsTestDir(t, "/old/ /new/ 302\n")
+	defer os.RemoveAll(dir)
+
+	var config *s3.WebsiteConfiguration
+	b := Bucket{
+		Name: "TestBucket",
+		Client: mockedBucketAPI{
+			GetBucketWebsiteFunc: func(*s3.GetBucketWebsiteInput) (*s3.GetBucketWebsiteOutput, error) {
+				return &s3.GetBucketWebsiteOutput{
+					IndexDocument: &s3.IndexDocument{Suffix: aws.String("home.html")},
+					RoutingRules: []*s3.RoutingRule{
+						{
+							Condition: &s3.Condition{KeyPrefixEquals: aws.String("/old/")},
+							Redirect:  &s3.Redirect{ReplaceKeyPrefixWith: aws.String("/stale/")},
+						},
+					},
+				}, nil
+			},
+			PutBucketWebsiteFunc: func(i *s3.PutBucketWebsiteInput) (*s3.PutBucketWebsiteOutput, error) {
+				config = i.WebsiteConfiguration
+				return &s3.PutBucketWebsiteOutput{}, nil
+			},
+		},
+		Manager: mockedBucketAPI{
+			UploadFunc: func(i *s3manager.UploadInput, up ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				if *i.Key == "/_redirects" {
+					t.Error("Expected redirects file not to be uploaded")
+				}
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+		RedirectsFile:           "_redirects",
+		RedirectsAsRoutingRules: true,
+	}
+
+	_, err := b.Upload(dir)
+	ok(t, err)
+
+	equals(t, "home.html", *config.IndexDocument.Suffix)
+	equals(t, 1, len(config.RoutingRules))
+	equals(t, "/old/", *config.RoutingRules[0].Condition.KeyPrefixEquals)
+	equals(t, "/new/", *config.RoutingRules[0].Redirect.ReplaceKeyPrefixWith)
+	equals(t, "302", *config.RoutingRules[0].Redirect.HttpRedirectCode)
+}
+
+func TestUploadRedirectsObjectsWhosePathIsPrefixOfAnother(t *testing.T) {
+	dir := redirectsTestDir(t, "/post /about\n/posts/ /archive/\n")
+	defer os.RemoveAll(dir)
+
+	var config *s3.WebsiteConfiguration
+	locations := make(map[string]string)
+	b := Bucket{
+		Name: "TestBucket",
+		Client: mockedBucketAPI{
+			GetBucketWebsiteFunc: func(*s3.GetBucketWebsiteInput) (*s3.GetBucketWebsiteOutput, error) {
+				return nil, awserr.New("NoSuchWebsiteConfiguration", "none", nil)
+			},
+			PutBucketWebsiteFunc: func(i *s3.PutBucketWebsiteInput) (*s3.PutBucketWebsiteOutput, error) {
+				config = i.WebsiteConfiguration
+				return &s3.PutBucketWebsiteOutput{}, nil
+			},
+		},
+		Manager: mockedBucketAPI{
+			UploadFunc: func(i *s3manager.UploadInput, up ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				locations[*i.Key] = aws.StringValue(i.WebsiteRedirectLocation)
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+		RedirectsFile:           "_redirects",
+		RedirectsAsRoutingRules: true,
+	}
+
+	_, err := b.Upload(dir)
+	ok(t, err)
+
+	// /post is redirected by its own object so it can't catch /posts/ or /post-1.
+	equals(t, map[string]string{
+		"/index.html": "",
+		"/post":       "/about",
+	}, locations)
+	equals(t, 1, len(config.RoutingRules))
+	equals(t, "/posts/", *config.RoutingRules[0].Condition.KeyPrefixEquals)
+	equals(t, "/archive/", *config.RoutingRules[0].Redirect.ReplaceKeyPrefixWith)
+}
@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/cstdev/lambdahelpers/pkg/awserror"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope of spans from the global tracer provider.
+const ScopeName = "github.com/cstdev/lambdahelpers"
+
+// Attribute keys set on the helpers' spans.
+const (
+	Bucket    = attribute.Key("aws.s3.bucket")
+	Key       = attribute.Key("aws.s3.key")
+	KeyCount  = attribute.Key("lambdahelpers.key_count")
+	Bytes     = attribute.Key("lambdahelpers.bytes")
+	MessageID = attribute.Key("messaging.message.id")
+	ErrorCode = attribute.Key("aws.error.code")
+)
+
+// Or returns t, or a tracer from the global provider if t is nil.
+// The global provider discards spans until one is registered with otel.SetTracerProvider.
+func Or(t trace.Tracer) trace.Tracer {
+	if t == nil {
+		return otel.Tracer(ScopeName)
+	}
+	return t
+}
+
+// Start starts a span as a child of any span in ctx, which may be nil.
+func Start(ctx context.Context, t trace.Tracer, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return Or(t).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on the span, if there is one, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		if code := awserror.Code(err); code != "" {
+			span.SetAttributes(ErrorCode.String(code))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
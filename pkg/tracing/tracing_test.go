@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/cstdev/lambdahelpers/pkg/awserror"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func equals(tb testing.TB, expected, actual interface{}) {
+	if !reflect.DeepEqual(expected, actual) {
+		tb.Errorf("Expected: %v \n Actual: %v", expected, actual)
+	}
+}
+
+func TestStartMakesChildOfSpanInContext(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+
+	ctx, parent := tracer.Start(context.Background(), "handler")
+	_, span := Start(ctx, tracer, "child", Bucket.String("TestBucket"))
+	End(span, nil)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	equals(t, 2, len(spans))
+	equals(t, parent.SpanContext().SpanID(), spans[0].Parent.SpanID())
+	equals(t, Bucket.String("TestBucket"), spans[0].Attributes[0])
+	equals(t, codes.Unset, spans[0].Status.Code)
+}
+
+func TestEndRecordsErrorAndAWSCode(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+
+	_, span := Start(nil, tracer, "s3:GetObject")
+	End(span, awserror.Wrap("s3:GetObject", awserr.New("NoSuchKey", "The key does not exist", nil)))
+
+	s := exporter.GetSpans()[0]
+	equals(t, codes.Error, s.Status.Code)
+	equals(t, ErrorCode.String("NoSuchKey"), s.Attributes[0])
+	equals(t, "exception", s.Events[0].Name)
+}
+
+func TestEndUsesErrorAsStatusDescription(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+
+	_, span := Start(nil, tracer, "op")
+	End(span, errors.New("failed"))
+
+	equals(t, "failed", exporter.GetSpans()[0].Status.Description)
+}